@@ -0,0 +1,184 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/archive.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tarEntry is a file or directory of a tarFS.
+type tarEntry struct {
+	info    fs.FileInfo
+	data    []byte        // of a file
+	entries []fs.DirEntry // of a directory, sorted by name
+}
+
+// tarFS holds the regular files and directories of a tar archive,
+// by their slash separated paths, "." for the root.
+type tarFS map[string]*tarEntry
+
+// TarGzFS reads the .tar.gz archive r, and returns an fs.FS holding its
+// regular files, and their directories, in memory, to be the DBFS of a
+// DBProcessRequest. Other entries, i.e. links, are skipped.
+// A zip archive needs no copy, since zip.Reader is an fs.FS.
+func TarGzFS(r io.Reader) (fs.FS, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	fsys := tarFS{".": &tarEntry{info: dirInfo(".")}}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimLeft(hdr.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fsys.addDir(name)
+		case tar.TypeReg:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			fsys.addDir(path.Dir(name))
+			if fsys[name] == nil {
+				fsys.addEntry(name, hdr.FileInfo())
+			}
+			fsys[name] = &tarEntry{info: hdr.FileInfo(), data: data}
+		}
+	}
+	for _, e := range fsys {
+		sort.Slice(e.entries, func(i, j int) bool { return e.entries[i].Name() < e.entries[j].Name() })
+	}
+	return fsys, nil
+}
+
+// addDir adds the directory name, and its parents, if they are missing.
+func (fsys tarFS) addDir(name string) {
+	if fsys[name] != nil {
+		return
+	}
+	fsys.addDir(path.Dir(name))
+	fsys[name] = &tarEntry{info: dirInfo(name)}
+	fsys.addEntry(name, dirInfo(name))
+}
+
+// addEntry adds name to the entries of its directory.
+func (fsys tarFS) addEntry(name string, info fs.FileInfo) {
+	dir := fsys[path.Dir(name)]
+	dir.entries = append(dir.entries, fs.FileInfoToDirEntry(info))
+}
+
+func (fsys tarFS) lookup(op string, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e := fsys[name]
+	if e == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fsys tarFS) Open(name string) (fs.File, error) {
+	e, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.info.IsDir() {
+		return &tarDir{e: e}, nil
+	}
+	return &tarFile{Reader: bytes.NewReader(e.data), info: e.info}, nil
+}
+
+func (fsys tarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]fs.DirEntry(nil), e.entries...), nil
+}
+
+func (fsys tarFS) ReadFile(name string) ([]byte, error) {
+	e, err := fsys.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+// tarFile is an open file of a tarFS.
+type tarFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *tarFile) Close() error               { return nil }
+
+// tarDir is an open directory of a tarFS.
+type tarDir struct {
+	e   *tarEntry
+	off int
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.e.info, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.e.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.e.entries)
+		return append([]fs.DirEntry(nil), rest...), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return append([]fs.DirEntry(nil), rest[:n]...), nil
+}
+
+// dirInfo is the fs.FileInfo of a directory of a tarFS, named by its path.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return path.Base(string(d)) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
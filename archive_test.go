@@ -0,0 +1,67 @@
+package sgfdb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+	"testing/fstest"
+)
+
+// tarGz returns a .tar.gz archive of files, with the directory entries of dirs.
+func tarGz(t *testing.T, dirs []string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, d := range dirs {
+		if err := tw.WriteHeader(&tar.Header{Name: d + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTarGzFS(t *testing.T) {
+	files := map[string]string{
+		"./1999/a.sgf": "(;GM[1];B[aa];W[bb])",
+		"1999/b.sgf":   "(;GM[1];B[cc])",
+		"2000/x/c.sgf": "(;GM[1];B[dd];W[ee];B[ff])",
+		"/2000/readme": "not a game",
+	}
+	fsys, err := TarGzFS(bytes.NewReader(tarGz(t, []string{"1999", "empty"}, files)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "1999/a.sgf", "1999/b.sgf", "2000/x/c.sgf", "2000/readme", "empty"); err != nil {
+		t.Error(err)
+	}
+
+	dbrq := &DBProcessRequest{DBIndexName: "mem:", DBFS: fsys, Recursive: true}
+	c, err := CountDatabaseMoves(context.Background(), dbrq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Files != 3 || c.Moves != 6 {
+		t.Errorf("got %+v, expected 3 files, 6 moves", c)
+	}
+
+	if _, err := TarGzFS(bytes.NewReader([]byte("(;GM[1])"))); err == nil {
+		t.Errorf("expected an error for a file which is not gzipped")
+	}
+}
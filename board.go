@@ -0,0 +1,576 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/board.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Ken1JF/ah"
+)
+
+// board.go holds a small Go board, used to replay the games of a database.
+// It plays moves with captures (and suicide), and refuses moves to occupied
+// points, and moves retaking a ko at once. sgf.ParseFile plays the moves,
+// with ParserPlay, on the board of the ah package, but does not expose the
+// position after each move, which the position, pattern, and duplicate
+// indexes hash.
+// The board keeps a Zobrist hash of its position under each of the
+// eight symmetries of a square board, so a position can be found
+// independent of the orientation of the game.
+
+// Color is the color of a point of a Board.
+type Color uint8
+
+const (
+	Empty Color = iota
+	Black
+	White
+)
+
+// Opponent returns the other color, Black for White and White for Black.
+func (c Color) Opponent() Color {
+	switch c {
+	case Black:
+		return White
+	case White:
+		return Black
+	}
+	return Empty
+}
+
+func (c Color) String() string {
+	switch c {
+	case Black:
+		return "B"
+	case White:
+		return "W"
+	}
+	return "."
+}
+
+// numTrans is the number of transformations of a square board,
+// named by ah.TransName.
+const numTrans = ah.BoardTrans(len(ah.TransName))
+
+// TransPoint returns the point col, row of a board of size size,
+// transformed by t, one of the transformations of ah.BoardTrans.
+// Bit 0 of t reverses the columns, bit 1 reverses the rows,
+// and bit 2 exchanges the columns and rows, before they are reversed.
+func TransPoint(t ah.BoardTrans, col int, row int, size int) (int, int) {
+	m := size - 1
+	if t&4 != 0 {
+		col, row = row, col
+	}
+	if t&1 != 0 {
+		col = m - col
+	}
+	if t&2 != 0 {
+		row = m - row
+	}
+	return col, row
+}
+
+// InverseTrans returns the transformation which undoes t.
+func InverseTrans(t ah.BoardTrans) ah.BoardTrans {
+	switch t {
+	case 5:
+		return 6
+	case 6:
+		return 5
+	}
+	return t
+}
+
+// MaxBoardSize is the largest board size of SGF.
+const MaxBoardSize = 52
+
+// Board is a square Go board.
+type Board struct {
+	size   int
+	points []Color
+	hashes [numTrans]uint64 // Zobrist hash of the position under each ah.BoardTrans
+
+	mark    []uint32 // points seen by removeIfDead, marked with gen
+	gen     uint32
+	group   []int
+	track   bool  // record the changed points in changes
+	changes []int // points changed since changes was last reset
+
+	ko      int   // the point of a ko, which koColor cannot retake at once, or -1
+	koColor Color // the color which cannot play at ko
+}
+
+// ErrIllegalMove is returned by Board.Play for a move to an occupied point.
+var ErrIllegalMove = errors.New("move to an occupied point")
+
+// ErrKo is returned by Board.Play for a move retaking a ko at once.
+var ErrKo = errors.New("move retaking a ko at once")
+
+// ErrOffBoard is returned for a point which is not on the board.
+var ErrOffBoard = errors.New("point not on the board")
+
+// NewBoard returns an empty board of size size, or nil if size is not
+// between 1 and MaxBoardSize.
+func NewBoard(size int) *Board {
+	if size < 1 || size > MaxBoardSize {
+		return nil
+	}
+	return &Board{size: size, points: make([]Color, size*size), mark: make([]uint32, size*size), ko: -1}
+}
+
+// Size returns the number of rows (and columns) of the board.
+func (b *Board) Size() int {
+	return b.size
+}
+
+// onBoard reports whether col, row is a point of the board.
+func (b *Board) onBoard(col int, row int) bool {
+	return col >= 0 && col < b.size && row >= 0 && row < b.size
+}
+
+// At returns the color of the point col, row, counted from 0 at the top left.
+func (b *Board) At(col int, row int) Color {
+	if !b.onBoard(col, row) {
+		return Empty
+	}
+	return b.points[row*b.size+col]
+}
+
+// Hash returns the Zobrist hash of the position, which is the same for
+// the position under any of the eight symmetries.
+func (b *Board) Hash() uint64 {
+	h, _ := b.CanonicalHash()
+	return h
+}
+
+// CanonicalHash returns the smallest of the hashes of the position
+// under the symmetries, and the symmetry which gives it.
+func (b *Board) CanonicalHash() (uint64, ah.BoardTrans) {
+	h, t := b.hashes[0], ah.BoardTrans(0)
+	for i := ah.BoardTrans(1); i < numTrans; i++ {
+		if b.hashes[i] < h {
+			h, t = b.hashes[i], i
+		}
+	}
+	return h, t
+}
+
+// TransHash returns the hash of the position transformed by t.
+func (b *Board) TransHash(t ah.BoardTrans) uint64 {
+	return b.hashes[t]
+}
+
+// zobristKey returns the key of color c at point p of a board of size size.
+// The keys are computed, not tabled, and the same in every run,
+// so hashes may be saved.
+func zobristKey(size int, p int, c Color) uint64 {
+	x := uint64(size)<<40 | uint64(p)<<2 | uint64(c)
+	// splitmix64
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// toggle adds or removes color c at col, row from the hashes.
+func (b *Board) toggle(col int, row int, c Color) {
+	for t := ah.BoardTrans(0); t < numTrans; t++ {
+		tc, tr := TransPoint(t, col, row, b.size)
+		b.hashes[t] ^= zobristKey(b.size, tr*b.size+tc, c)
+	}
+}
+
+// Set sets the point col, row to c, without captures, as the SGF
+// setup properties AB, AW, and AE do. It ends a ko.
+func (b *Board) Set(col int, row int, c Color) error {
+	if !b.onBoard(col, row) {
+		return ErrOffBoard
+	}
+	b.ko = -1
+	p := row*b.size + col
+	if old := b.points[p]; old != Empty {
+		b.toggle(col, row, old)
+	}
+	b.points[p] = c
+	if c != Empty {
+		b.toggle(col, row, c)
+	}
+	if b.track {
+		b.changes = append(b.changes, p)
+	}
+	return nil
+}
+
+// Play plays a stone of color c at col, row, removes the opponent stones
+// left without liberties, and then its own group, if it has no liberties.
+// It returns the number of stones captured. A move to an occupied point
+// returns ErrIllegalMove, and a move retaking a ko at once returns ErrKo.
+func (b *Board) Play(col int, row int, c Color) (captured int, err error) {
+	if !b.onBoard(col, row) {
+		return 0, ErrOffBoard
+	}
+	p := row*b.size + col
+	if b.points[p] != Empty {
+		return 0, ErrIllegalMove
+	}
+	if p == b.ko && c == b.koColor {
+		return 0, ErrKo
+	}
+	b.Set(col, row, c)
+	var ns [4]int
+	ko := -1
+	for _, n := range b.neighbors(p, &ns) {
+		if b.points[n] == c.Opponent() {
+			if k := b.removeIfDead(n); k > 0 {
+				captured += k
+				ko = n
+			}
+		}
+	}
+	b.removeIfDead(p) // suicide
+	// a single stone capturing a single stone, left with the one liberty
+	// of the captured stone, is a ko
+	if captured == 1 {
+		for _, n := range b.neighbors(p, &ns) {
+			if n != ko && b.points[n] != c.Opponent() {
+				ko = -1
+			}
+		}
+		if ko >= 0 {
+			b.ko, b.koColor = ko, c.Opponent()
+		}
+	}
+	return captured, nil
+}
+
+// Pass ends a ko.
+func (b *Board) Pass() {
+	b.ko = -1
+}
+
+// neighbors returns the points next to p, in ns.
+func (b *Board) neighbors(p int, ns *[4]int) []int {
+	k := 0
+	col, row := p%b.size, p/b.size
+	if col > 0 {
+		ns[k] = p - 1
+		k++
+	}
+	if col < b.size-1 {
+		ns[k] = p + 1
+		k++
+	}
+	if row > 0 {
+		ns[k] = p - b.size
+		k++
+	}
+	if row < b.size-1 {
+		ns[k] = p + b.size
+		k++
+	}
+	return ns[:k]
+}
+
+// removeIfDead removes the group at p if it has no liberties,
+// and returns the number of stones removed.
+func (b *Board) removeIfDead(p int) int {
+	c := b.points[p]
+	if c == Empty {
+		return 0
+	}
+	b.gen++
+	if b.gen == 0 {
+		clear(b.mark)
+		b.gen = 1
+	}
+	b.mark[p] = b.gen
+	group := append(b.group[:0], p)
+	var ns [4]int
+	for i := 0; i < len(group); i++ {
+		for _, n := range b.neighbors(group[i], &ns) {
+			switch {
+			case b.points[n] == Empty:
+				b.group = group
+				return 0
+			case b.points[n] == c && b.mark[n] != b.gen:
+				b.mark[n] = b.gen
+				group = append(group, n)
+			}
+		}
+	}
+	for _, q := range group {
+		b.Set(q%b.size, q/b.size, Empty)
+	}
+	b.group = group
+	return len(group)
+}
+
+// String returns the board as rows of ".", "B", and "W".
+func (b *Board) String() string {
+	var sb strings.Builder
+	for row := 0; row < b.size; row++ {
+		for col := 0; col < b.size; col++ {
+			sb.WriteString(b.At(col, row).String())
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// sgfPoint converts an SGF point value, i.e. "pd", to a column and row,
+// counted from 0. ok is false if v is not a point.
+func sgfPoint(v string) (col int, row int, ok bool) {
+	if len(v) != 2 {
+		return 0, 0, false
+	}
+	col, ok1 := sgfCoord(v[0])
+	row, ok2 := sgfCoord(v[1])
+	return col, row, ok1 && ok2
+}
+
+func sgfCoord(c byte) (int, bool) {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), true
+	case 'A' <= c && c <= 'Z':
+		return int(c-'A') + 26, true
+	}
+	return 0, false
+}
+
+// sgfPointValue converts a column and row, counted from 0, to an SGF point value.
+func sgfPointValue(col int, row int) string {
+	return string([]byte{sgfLetter(col), sgfLetter(row)})
+}
+
+func sgfLetter(n int) byte {
+	if n < 26 {
+		return byte('a' + n)
+	}
+	return byte('A' + n - 26)
+}
+
+// boardSize returns the board size given by the SZ value v,
+// 19 if v is empty, and 0 if v is not a size.
+// Rectangular boards, i.e. SZ[19:13], return the number of columns and rows.
+func boardSize(v string) (nCol int, nRow int) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 19, 19
+	}
+	if idx := strings.IndexByte(v, ':'); idx >= 0 {
+		c, err1 := strconv.Atoi(strings.TrimSpace(v[:idx]))
+		r, err2 := strconv.Atoi(strings.TrimSpace(v[idx+1:]))
+		if err1 != nil || err2 != nil || c < 1 || r < 1 || c > MaxBoardSize || r > MaxBoardSize {
+			return 0, 0
+		}
+		return c, r
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > MaxBoardSize {
+		return 0, 0
+	}
+	return n, n
+}
+
+// pointList expands the values of a point list property, i.e. AB,
+// including compressed rectangles, i.e. "aa:cc".
+// Values which are not points are returned in bad.
+func pointList(vals []string) (pts [][2]int, bad []string) {
+	for _, v := range vals {
+		if idx := strings.IndexByte(v, ':'); idx >= 0 {
+			c1, r1, ok1 := sgfPoint(v[:idx])
+			c2, r2, ok2 := sgfPoint(v[idx+1:])
+			if !ok1 || !ok2 {
+				bad = append(bad, v)
+				continue
+			}
+			if c2 < c1 {
+				c1, c2 = c2, c1
+			}
+			if r2 < r1 {
+				r1, r2 = r2, r1
+			}
+			for c := c1; c <= c2; c++ {
+				for r := r1; r <= r2; r++ {
+					pts = append(pts, [2]int{c, r})
+				}
+			}
+			continue
+		}
+		c, r, ok := sgfPoint(v)
+		if !ok {
+			bad = append(bad, v)
+			continue
+		}
+		pts = append(pts, [2]int{c, r})
+	}
+	return pts, bad
+}
+
+// isPass reports whether the move value v is a pass:
+// empty, or "tt" on a board of size 19 or less.
+func isPass(v string, size int) bool {
+	return v == "" || (v == "tt" && size <= 19)
+}
+
+// errNotSquare is returned by replay for a rectangular board.
+var errNotSquare = errors.New("board is not square")
+
+// gameMoves is the main line of a game, compiled to a list of operations
+// on a board, so it can be replayed without reading the SGF again.
+// Each operation is a gameOp in the high 4 bits, and a point in the low 12 bits.
+type gameMoves struct {
+	Size int
+	Ops  []uint16
+}
+
+// gameOp is an operation of gameMoves.
+type gameOp uint16
+
+const (
+	opEmpty    gameOp = iota // AE
+	opBlack                  // AB
+	opWhite                  // AW
+	opEndSetup               // end of a node with setup properties
+	opMoveB                  // B
+	opMoveW                  // W
+	opPass                   // B pass
+	opPassW                  // W pass
+)
+
+const opShift = 12
+
+// compileGame compiles the main line of the game tree t.
+// If there is an error, the moves before the error are returned.
+func compileGame(t *sgfTree) (g gameMoves, err error) {
+	nodes := t.mainLine()
+	if len(nodes) == 0 {
+		return g, nil
+	}
+	nCol, nRow := boardSize(nodes[0].value("SZ"))
+	if nCol == 0 {
+		return g, errors.New("bad board size SZ[" + nodes[0].value("SZ") + "]")
+	}
+	if nCol != nRow {
+		return g, errNotSquare
+	}
+	g.Size = nCol
+	move := 0
+	for _, n := range nodes {
+		setup := false
+		for _, su := range []struct {
+			id string
+			op gameOp
+		}{{"AE", opEmpty}, {"AB", opBlack}, {"AW", opWhite}} {
+			p := n.prop(su.id)
+			if p == nil {
+				continue
+			}
+			pts, bad := pointList(p.vals)
+			if len(bad) > 0 {
+				return g, errors.New("bad point " + su.id + "[" + bad[0] + "] after move " + strconv.Itoa(move))
+			}
+			for _, pt := range pts {
+				if pt[0] >= g.Size || pt[1] >= g.Size {
+					return g, errors.New(su.id + "[" + sgfPointValue(pt[0], pt[1]) + "]: " + ErrOffBoard.Error())
+				}
+				g.Ops = append(g.Ops, uint16(su.op)<<opShift|uint16(pt[1]*g.Size+pt[0]))
+			}
+			setup = true
+		}
+		if setup {
+			g.Ops = append(g.Ops, uint16(opEndSetup)<<opShift)
+		}
+		for _, mv := range []struct {
+			id string
+			op gameOp
+		}{{"B", opMoveB}, {"W", opMoveW}} {
+			p := n.prop(mv.id)
+			if p == nil {
+				continue
+			}
+			move++
+			v := p.vals[0]
+			if isPass(v, g.Size) {
+				op := opPass
+				if mv.op == opMoveW {
+					op = opPassW
+				}
+				g.Ops = append(g.Ops, uint16(op)<<opShift)
+				continue
+			}
+			col, row, ok := sgfPoint(v)
+			if !ok || col >= g.Size || row >= g.Size {
+				return g, errors.New("bad move " + mv.id + "[" + v + "] at move " + strconv.Itoa(move))
+			}
+			g.Ops = append(g.Ops, uint16(mv.op)<<opShift|uint16(row*g.Size+col))
+		}
+	}
+	return g, nil
+}
+
+// play plays the moves on a new board.
+// After each move (or pass), and after each node of setup properties,
+// visit is called with the number of moves played, and the board.
+// If visit returns false, play stops.
+// If a move is to an occupied point, or retakes a ko at once, play stops,
+// and returns the number of operations played, with the error.
+func (g *gameMoves) play(visit func(move int, b *Board) bool) (int, error) {
+	b := NewBoard(g.Size)
+	if b == nil {
+		return 0, nil
+	}
+	move := 0
+	for i, op := range g.Ops {
+		p := int(op & (1<<opShift - 1))
+		col, row := p%g.Size, p/g.Size
+		switch gameOp(op >> opShift) {
+		case opEmpty:
+			b.Set(col, row, Empty)
+			continue
+		case opBlack:
+			b.Set(col, row, Black)
+			continue
+		case opWhite:
+			b.Set(col, row, White)
+			continue
+		case opEndSetup:
+		case opMoveB, opMoveW:
+			move++
+			c := Black
+			if gameOp(op>>opShift) == opMoveW {
+				c = White
+			}
+			if _, err := b.Play(col, row, c); err != nil {
+				return i, errors.New(c.String() + "[" + sgfPointValue(col, row) + "] at move " + strconv.Itoa(move) + ": " + err.Error())
+			}
+		case opPass, opPassW:
+			move++
+			b.Pass()
+		}
+		if !visit(move, b) {
+			return i + 1, nil
+		}
+	}
+	return len(g.Ops), nil
+}
+
+// replay plays the main line of the game tree t on a new board,
+// calling visit as gameMoves.play does.
+// The board of a rectangular game is not replayed, and errNotSquare is returned.
+func replay(t *sgfTree, visit func(move int, b *Board) bool) error {
+	g, err := compileGame(t)
+	_, err2 := g.play(visit)
+	if err2 != nil {
+		return err2
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package sgfdb_test
+
+import (
+	"github.com/Ken1JF/ah"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestBoardPlay(t *testing.T) {
+	b := NewBoard(5)
+	// surround a white stone in the corner, and a white group on the edge
+	for _, m := range []struct {
+		col, row int
+		c        Color
+		captured int
+	}{
+		{0, 0, White, 0},
+		{1, 0, Black, 0},
+		{0, 1, Black, 1},
+		{3, 0, White, 0},
+		{4, 0, White, 0},
+		{2, 0, Black, 0},
+		{3, 1, Black, 0},
+		{4, 1, Black, 2},
+		{0, 0, White, 0}, // suicide
+	} {
+		captured, err := b.Play(m.col, m.row, m.c)
+		if err != nil || captured != m.captured {
+			t.Errorf("%s at %d,%d: captured %d, %v; expected %d", m.c, m.col, m.row, captured, err, m.captured)
+		}
+	}
+	const want = ".BB..\nB..BB\n.....\n.....\n.....\n"
+	if b.String() != want {
+		t.Errorf("board:\n%s\nexpected:\n%s", b, want)
+	}
+	if _, err := b.Play(1, 0, White); err != ErrIllegalMove {
+		t.Errorf("play on a stone: %v", err)
+	}
+	if _, err := b.Play(5, 0, White); err != ErrOffBoard {
+		t.Errorf("play off the board: %v", err)
+	}
+
+	// a ko, at 1,1, retaken after a move elsewhere
+	b = NewBoard(5)
+	for _, pt := range [][2]int{{1, 0}, {0, 1}, {1, 2}} {
+		b.Set(pt[0], pt[1], Black)
+	}
+	for _, pt := range [][2]int{{2, 0}, {3, 1}, {2, 2}, {1, 1}} {
+		b.Set(pt[0], pt[1], White)
+	}
+	if captured, err := b.Play(2, 1, Black); captured != 1 || err != nil {
+		t.Errorf("taking the ko: captured %d, %v", captured, err)
+	}
+	if _, err := b.Play(1, 1, White); err != ErrKo {
+		t.Errorf("retaking the ko at once: %v", err)
+	}
+	b.Play(4, 4, White)
+	b.Play(4, 3, Black)
+	if captured, err := b.Play(1, 1, White); captured != 1 || err != nil {
+		t.Errorf("retaking the ko: captured %d, %v", captured, err)
+	}
+}
+
+func TestBoardTrans(t *testing.T) {
+	for s := ah.BoardTrans(0); int(s) < len(ah.TransName); s++ {
+		b1, b2 := NewBoard(9), NewBoard(9)
+		for i, pt := range [][2]int{{2, 2}, {6, 2}, {2, 5}, {4, 4}, {0, 8}} {
+			c := Black
+			if i%2 == 1 {
+				c = White
+			}
+			b1.Play(pt[0], pt[1], c)
+			col, row := TransPoint(s, pt[0], pt[1], 9)
+			b2.Play(col, row, c)
+			if ic, ir := TransPoint(InverseTrans(s), col, row, 9); ic != pt[0] || ir != pt[1] {
+				t.Errorf("%s: inverse of %d,%d is %d,%d", ah.TransName[s], pt[0], pt[1], ic, ir)
+			}
+		}
+		if b1.Hash() != b2.Hash() {
+			t.Errorf("%s: hash %x, expected %x", ah.TransName[s], b2.Hash(), b1.Hash())
+		}
+		if b2.TransHash(InverseTrans(s)) != b1.TransHash(0) {
+			t.Errorf("%s: transformed hash %x, expected %x", ah.TransName[s], b2.TransHash(InverseTrans(s)), b1.TransHash(0))
+		}
+	}
+	b1, b2 := NewBoard(9), NewBoard(9)
+	b1.Play(2, 2, Black)
+	b2.Play(2, 2, White)
+	if b1.Hash() == b2.Hash() {
+		t.Errorf("colors have the same hash")
+	}
+}
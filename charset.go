@@ -0,0 +1,339 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/charset.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// ErrCharsetUnknown is returned by TranscodeSGF for files whose character set
+// cannot be determined.
+var ErrCharsetUnknown = errors.New("character set not determined")
+
+// A charset is a character set detected by TranscodeSGF.
+type charset struct {
+	name string
+	enc  encoding.Encoding
+	// single reports whether the byte c, >= 0x80, is a character by itself
+	single func(c byte) bool
+	// common reports whether the two byte character lead, trail is in the
+	// part of the table holding the frequent characters: kana, hangul,
+	// the first level of kanji or hanzi, and the punctuation
+	common func(lead, trail byte) bool
+	// surnames are frequent first characters of the names of players
+	surnames string
+}
+
+// charsets are the character sets of SGF files from Japanese, Korean,
+// and Chinese servers, and collections.
+var charsets = []*charset{
+	{"Shift_JIS", japanese.ShiftJIS,
+		func(c byte) bool { return c >= 0xA1 && c <= 0xDF },
+		func(lead, trail byte) bool {
+			return lead >= 0x81 && lead <= 0x83 || lead == 0x88 && trail >= 0x9F || lead >= 0x89 && lead <= 0x97 || lead == 0x98 && trail <= 0x72
+		},
+		"小大山田中高井石藤加武坂林橋村松結芝羽依河三宮本木吉佐渡工酒苑梶趙張王金"},
+	{"EUC-KR", korean.EUCKR,
+		func(c byte) bool { return false },
+		func(lead, trail byte) bool {
+			return trail >= 0xA1 && (lead >= 0xA1 && lead <= 0xA3 || lead >= 0xB0 && lead <= 0xC8)
+		},
+		"이김박최조유윤강서송안한홍목원백양정장신권오황임류전허진남고문변나"},
+	{"GBK", simplifiedchinese.GBK,
+		func(c byte) bool { return false },
+		func(lead, trail byte) bool {
+			return trail >= 0xA1 && (lead >= 0xA1 && lead <= 0xA3 || lead >= 0xB0 && lead <= 0xD7)
+		},
+		"王李张刘陈杨黄赵周吴马聂常古孔俞罗芮柯范谢朴时檀辜江唐彭党连丁邱钱胡曹廖孙邵邬童陶柁芈於华"},
+	{"Big5", traditionalchinese.Big5,
+		func(c byte) bool { return false },
+		func(lead, trail byte) bool {
+			return lead >= 0xA1 && lead <= 0xC5 || lead == 0xC6 && trail <= 0x7E
+		},
+		"王李張劉陳楊黃趙周吳馬聶林蔡謝許蕭曾彭簡賴鄭徐郭洪邱莊蘇葉呂施"},
+}
+
+// latin1Score is the score of ISO-8859-1, the default of SGF, which can read any file
+// without the control characters 0x80-0x9F. A CJK character set wins only with most
+// of its characters in its common part.
+const latin1Score = 0.5
+
+// reCharset finds the CA property, in the bytes of a file not yet transcoded.
+var reCharset = regexp.MustCompile(`CA\s*\[([^\]]*)\]`)
+
+// rePlayer finds the names of the players, in a transcoded file.
+var rePlayer = regexp.MustCompile(`P[BW]\s*\[([^\]]*)\]`)
+
+// decode returns b decoded by enc, and false if b has bytes which enc cannot decode.
+func decode(enc encoding.Encoding, b []byte) ([]byte, bool) {
+	out, err := enc.NewDecoder().Bytes(b)
+	if err != nil || bytes.ContainsRune(out, utf8.RuneError) {
+		return nil, false
+	}
+	return out, true
+}
+
+// score returns the fraction of the characters of b, read in cs, which are common,
+// plus half of the fraction of the player names of out, b decoded, starting with
+// one of the surnames of cs.
+func (cs *charset) score(b []byte, out []byte) float64 {
+	n, common := 0, 0
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if c < 0x80 {
+			continue
+		}
+		n++
+		if cs.single(c) || i+1 == len(b) {
+			continue
+		}
+		if cs.common(c, b[i+1]) {
+			common++
+		}
+		i++
+	}
+	if n == 0 {
+		return 0
+	}
+	s := float64(common) / float64(n)
+	names, surnames := 0, 0
+	for _, m := range rePlayer.FindAllSubmatch(out, -1) {
+		name := strings.TrimSpace(string(m[1]))
+		r, _ := utf8.DecodeRuneInString(name)
+		if r < 0x80 {
+			continue
+		}
+		names++
+		if strings.ContainsRune(cs.surnames, r) {
+			surnames++
+		}
+	}
+	if names > 0 {
+		s += 0.5 * float64(surnames) / float64(names)
+	}
+	return s
+}
+
+// DetectCharset returns the name of the character set of the SGF file b,
+// one of UTF-8, ISO-8859-1, Shift_JIS, EUC-KR, GBK, and Big5, or
+// ErrCharsetUnknown. See TranscodeSGF.
+func DetectCharset(b []byte) (string, error) {
+	_, cs, err := transcode(b)
+	return cs, err
+}
+
+// transcode returns b decoded to UTF-8, and the name of its character set.
+func transcode(b []byte) ([]byte, string, error) {
+	if utf8.Valid(b) {
+		return b, "UTF-8", nil
+	}
+	type candidate struct {
+		name  string
+		out   []byte
+		score float64
+	}
+	var cands []candidate
+	ca := ""
+	if m := reCharset.FindSubmatch(b); m != nil {
+		ca = strings.TrimSpace(string(m[1]))
+	}
+	if !hasC1Controls(b) {
+		out, _ := decode(charmap.ISO8859_1, b)
+		cands = append(cands, candidate{"ISO-8859-1", out, latin1Score})
+	}
+	var declared encoding.Encoding
+	if ca != "" {
+		declared, _ = htmlindex.Get(ca)
+		if strings.EqualFold(ca, "ISO-8859-1") || strings.EqualFold(ca, "latin1") {
+			declared = charmap.ISO8859_1
+		}
+	}
+	found := false
+	for _, cs := range charsets {
+		out, ok := decode(cs.enc, b)
+		if !ok {
+			continue
+		}
+		s := cs.score(b, out)
+		if declared != nil && sameEncoding(declared, cs.enc) {
+			s += 0.2
+			found = true
+		}
+		cands = append(cands, candidate{cs.name, out, s})
+	}
+	if declared != nil && !found {
+		// honour a character set which is not detected
+		if out, ok := decode(declared, b); ok {
+			name, _ := htmlindex.Name(declared)
+			if declared == charmap.ISO8859_1 {
+				name = "ISO-8859-1"
+			}
+			cands = append(cands, candidate{name, out, 1.2})
+		}
+	}
+	best, second := -1, -1
+	for i, c := range cands {
+		switch {
+		case best < 0 || c.score > cands[best].score:
+			best, second = i, best
+		case second < 0 || c.score > cands[second].score:
+			second = i
+		}
+	}
+	switch {
+	case best < 0:
+		return nil, "", ErrCharsetUnknown
+	case second >= 0 && cands[best].score-cands[second].score < 0.1:
+		return nil, "", fmt.Errorf("%w: %s or %s", ErrCharsetUnknown, cands[best].name, cands[second].name)
+	}
+	return cands[best].out, cands[best].name, nil
+}
+
+// sameEncoding reports whether a and b decode the same way,
+// i.e. GB2312 and GBK, which htmlindex does not tell apart.
+func sameEncoding(a encoding.Encoding, b encoding.Encoding) bool {
+	na, _ := htmlindex.Name(a)
+	nb, _ := htmlindex.Name(b)
+	return na != "" && na == nb
+}
+
+// TranscodeSGF returns the SGF file b in UTF-8, with CA[UTF-8] in the root of
+// each game, and the name of the character set b was written in.
+// The CA property of the file is honoured, if b can be read in it, but a file
+// valid in UTF-8 is UTF-8, whatever its CA. Otherwise, the character set is
+// detected: the Shift_JIS, EUC-KR, GBK, or Big5 with most of the characters
+// of b among the frequent characters of its table, and the names of the players
+// starting with a frequent surname, or ISO-8859-1, the default of SGF.
+// If no character set reads b, or two score alike, TranscodeSGF returns
+// an error wrapping ErrCharsetUnknown.
+// Files in UTF-8, without other characters than ASCII, or already with
+// CA[UTF-8], are returned unchanged.
+func TranscodeSGF(b []byte) ([]byte, string, error) {
+	out, cs, err := transcode(b)
+	if err != nil {
+		return nil, "", err
+	}
+	if cs == "UTF-8" {
+		m := reCharset.FindSubmatch(b)
+		if m == nil && !hasNonASCII(b) || m != nil && isUTF8Name(string(m[1])) {
+			return b, cs, nil
+		}
+	}
+	return setCharsetUTF8(out), cs, nil
+}
+
+func hasC1Controls(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 && c <= 0x9F {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNonASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+func isUTF8Name(ca string) bool {
+	ca = strings.TrimSpace(ca)
+	return strings.EqualFold(ca, "UTF-8") || strings.EqualFold(ca, "UTF8")
+}
+
+// setCharsetUTF8 returns the SGF file b with CA[UTF-8] in the root node of each game:
+// the CA property replaced, or added first.
+func setCharsetUTF8(b []byte) []byte {
+	out := make([]byte, 0, len(b)+16)
+	s := sgfScanner{b: b}
+	depth := 0
+	gameStart, inRoot, hasCA := false, false, false
+	rootPos := 0
+	id := ""
+	endRoot := func() {
+		if inRoot && !hasCA {
+			out = append(out[:rootPos], append([]byte("CA[UTF-8]"), out[rootPos:]...)...)
+		}
+		inRoot = false
+	}
+	for s.pos < len(b) {
+		start := s.pos
+		switch c := b[s.pos]; {
+		case isLetter(c):
+			id = s.ident()
+		case c == '[':
+			// copy, or replace, the value
+			s.value(nil)
+			if inRoot && id == "CA" {
+				out = append(out, "[UTF-8]"...)
+				hasCA = true
+				continue
+			}
+		case c == '(':
+			endRoot()
+			depth++
+			gameStart = depth == 1
+			s.pos++
+		case c == ')':
+			endRoot()
+			depth--
+			s.pos++
+		case c == ';':
+			endRoot()
+			if gameStart {
+				inRoot, hasCA, gameStart = true, false, false
+				rootPos = len(out) + 1
+			}
+			s.pos++
+		default:
+			s.pos++
+		}
+		out = append(out, b[start:s.pos]...)
+	}
+	endRoot()
+	return out
+}
+
+// TranscodeSGFFile is the Action Function writing the file fName, b,
+// in UTF-8 to the Output sink, or the DBOutName directory.
+// The files converted from another character set are counted as moves,
+// and those whose character set cannot be determined are recorded as errors.
+func TranscodeSGFFile(r *DirectoryProcessRequest, fName string, b []byte) {
+	r.cntf += 1
+	out, cs, err := TranscodeSGF(b)
+	if err != nil {
+		fmt.Printf("%s Error transcoding: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+		r.FileError(fName, "Transcoding", err)
+		return
+	}
+	if cs != "UTF-8" {
+		r.cntm++
+	}
+	if err = r.WriteOutput(fName, out); err != nil {
+		fmt.Printf("%s Error writing: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+		r.FileError(fName, "Writing", err)
+	}
+}
@@ -0,0 +1,91 @@
+package sgfdb_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/Ken1JF/sgfdb"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"testing"
+)
+
+func encode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("encoding %q: %s", s, err)
+	}
+	return b
+}
+
+func TestTranscodeSGF(t *testing.T) {
+	tests := []struct {
+		enc     encoding.Encoding
+		game    string
+		charset string
+		out     string
+	}{
+		{nil, "(;GM[1]PB[Honinbo Shusaku])", "UTF-8", "(;GM[1]PB[Honinbo Shusaku])"},
+		{nil, "(;GM[1]CA[UTF-8]PB[이창호])", "UTF-8", "(;GM[1]CA[UTF-8]PB[이창호])"},
+		{nil, "(;GM[1]CA[gb2312]PB[聂卫平])", "UTF-8", "(;GM[1]CA[UTF-8]PB[聂卫平])"},
+		{charmap.ISO8859_1, "(;GM[1]PB[José]C[Bon coup])", "ISO-8859-1", "(;CA[UTF-8]GM[1]PB[José]C[Bon coup])"},
+		{japanese.ShiftJIS, "(;GM[1]PB[井山裕太]PW[趙治勲]C[黒の勝ち])", "Shift_JIS", "(;CA[UTF-8]GM[1]PB[井山裕太]PW[趙治勲]C[黒の勝ち])"},
+		{korean.EUCKR, "(;GM[1]PB[이창호]PW[조훈현];B[pd](;W[dd])(;GM[1]PB[x]))(;GM[1]PB[박정환]PW[신진서])", "EUC-KR",
+			"(;CA[UTF-8]GM[1]PB[이창호]PW[조훈현];B[pd](;W[dd])(;GM[1]PB[x]))(;CA[UTF-8]GM[1]PB[박정환]PW[신진서])"},
+		{simplifiedchinese.GBK, "(;GM[1]PB[聂卫平]PW[马晓春])", "GBK", "(;CA[UTF-8]GM[1]PB[聂卫平]PW[马晓春])"},
+		{traditionalchinese.Big5, "(;GM[1]PB[林海峰]PW[王立誠])", "Big5", "(;CA[UTF-8]GM[1]PB[林海峰]PW[王立誠])"},
+		{traditionalchinese.Big5, "(;GM[1]CA[Big5]PB[周俊勳])", "Big5", "(;GM[1]CA[UTF-8]PB[周俊勳])"},
+		{simplifiedchinese.GBK, "(;GM[1]CA[Big5]PB[古力]PW[常昊]C[黑中盘胜])", "GBK", "(;GM[1]CA[UTF-8]PB[古力]PW[常昊]C[黑中盘胜])"},
+	}
+	for _, tst := range tests {
+		b := []byte(tst.game)
+		if tst.enc != nil {
+			b = encode(t, tst.enc, tst.game)
+		}
+		out, cs, err := TranscodeSGF(b)
+		if err != nil {
+			t.Errorf("%s: %s", tst.game, err)
+			continue
+		}
+		if cs != tst.charset || string(out) != tst.out {
+			t.Errorf("%s:\ngot      %s %s\nexpected %s %s", tst.game, cs, out, tst.charset, tst.out)
+		}
+		if cs2, err := DetectCharset(b); cs2 != cs || err != nil {
+			t.Errorf("%s: DetectCharset %s, %v", tst.game, cs2, err)
+		}
+	}
+	if _, _, err := TranscodeSGF([]byte("(;GM[1]C[\x81\x7f\xff])")); !errors.Is(err, ErrCharsetUnknown) {
+		t.Errorf("expected ErrCharsetUnknown, got %v", err)
+	}
+}
+
+func TestTranscodeDatabase(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": string(encode(t, japanese.ShiftJIS, "(;GM[1]PB[井山裕太]PW[張栩])")),
+		"a/2.sgf": "(;GM[1]C[\x81\x7f\xff])",
+		"a/3.sgf": "(;GM[1]PB[Go Seigen])",
+	}
+	var sink MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &sink, FileActionFunc: TranscodeSGFFile})
+	if err := ProcessDatabaseContext(context.Background(), dbReq); err == nil {
+		t.Errorf("expected an error for 2.sgf")
+	}
+	if len(sink.Files) != 2 || string(sink.Files["a/1.sgf"]) != "(;CA[UTF-8]GM[1]PB[井山裕太]PW[張栩])" {
+		t.Errorf("output: %q", sink.Files)
+	}
+	if _, files, transcoded, errs := dbReq.Totals(); files != 3 || transcoded != 1 || errs != 1 {
+		t.Errorf("%d files, %d transcoded, %d errors", files, transcoded, errs)
+	}
+
+	// with Transcode, the action functions read UTF-8
+	var names []string
+	dbReq = memDB(files, DBProcessRequest{Transcode: true,
+		FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) { names = append(names, string(b)) }})
+	ProcessDatabaseContext(context.Background(), dbReq)
+	if len(names) != 2 || names[0] != "(;CA[UTF-8]GM[1]PB[井山裕太]PW[張栩])" {
+		t.Errorf("read: %q", names)
+	}
+}
@@ -0,0 +1,774 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/cmd/sgfdb/main.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+// Command sgfdb builds and queries the indexes of an SGF database.
+//
+//	sgfdb index [-o index.gob.gz] [-r] [-refresh] [-utf8=false] dbdir
+//	sgfdb query [-i index.gob.gz] [-l] expr
+//	sgfdb positions [-o positions.gob.gz] [-r] dbdir
+//	sgfdb find [-i positions.gob.gz] fragment
+//	sgfdb patterns [-o patterns.gob.gz] [-r] dbdir
+//	sgfdb shape [-i patterns.gob.gz] [-corner] rows
+//	sgfdb openings [-n 20] [-min 1] [-filter expr] [-o openings.sgf] [-next fragment] [-r] dbdir
+//	sgfdb joseki [-n 30] [-region 9] [-min 1] [-filter expr] [-o joseki.sgf] [-find fragment] [-r] dbdir
+//	sgfdb dedupe [-min 20] [-diff 4] [-plan file] [-near] [-r] dbdir
+//	sgfdb lint [-enable rules] [-disable rules] [-required props] [-rules] [-r] dbdir
+//	sgfdb repair -o outdir -q quarantinedir [-r] dbdir
+//	sgfdb normalize -o outdir [-n 10] [-r] dbdir
+//	sgfdb transcode -o outdir [-r] dbdir
+//	sgfdb players -aliases file [-i index.gob.gz] [-max 2] [name ...]
+//	sgfdb stats [-aliases file] [-filter expr] [-player id] [-min 1] [-n 10] [-format text] [-r] dbdir
+//	sgfdb ratings [-method elo|bt] [-k 16] [-aliases file] [-filter expr] [-min 1] [-history] [-csv] [-r] dbdir
+//	sgfdb count [-approx] [-d] [-r] dbdir
+//
+// index reads every .sgf file of the directories of dbdir into an index,
+// converted to UTF-8 unless -utf8=false, so names in any character set match.
+// query prints the paths, or with -l the records, of the games selected
+// by expr, i.e.
+//
+//	sgfdb query 'PB~"Honinbo Shusaku" AND DT>=1840 AND RE=B+*'
+//
+// See sgfdb.Query for the expressions.
+//
+// positions builds a position index of the games of dbdir, and find prints
+// the games, and moves, reaching the position at the end of an SGF fragment,
+// under any symmetry, i.e.
+//
+//	sgfdb find ';B[pd];W[dd];B[pp];W[dp]'
+//
+// patterns compiles the games of dbdir for pattern search, and shape prints
+// the games, moves, and places where a pattern appears, i.e. the 3-3 point
+// in an empty corner:
+//
+//	sgfdb shape -corner '..../..../..X./....'
+//
+// See sgfdb.ParsePattern for the rows.
+//
+// openings writes the opening tree of the games of dbdir selected by the
+// filter expression, with the statistics of each move in its comment.
+// With -next, it prints the moves played after the fragment, i.e.
+//
+//	sgfdb openings -filter 'BR=*p AND WR=*p AND DT>=2000' -next ';B[pd];W[dd]' dbdir
+//
+// joseki writes the tree of the corner sequences of the games of dbdir,
+// with their count in each directory, i.e. each era of GoGoD.
+// With -find, it prints the count, and share, of a sequence in each era, i.e.
+//
+//	sgfdb joseki -r -find ';B[pd];W[qf];B[nc]' GoGoD
+//
+// dedupe prints the groups of duplicate games of dbdir, the game kept first,
+// and with -plan, writes the paths of the other games, to be removed, i.e.
+//
+//	sgfdb dedupe -r -plan remove.txt db && grep -v '^#' remove.txt | (cd db && xargs rm)
+//
+// lint checks the files of dbdir, and prints the problems found as JSON
+// lines, with the file, node, rule, and severity. The rules are listed by
+// -rules, and selected by comma separated lists of IDs, i.e.
+//
+//	sgfdb lint -r -disable gogod-km,gogod-header GoGoD | grep '"severity":"error"'
+//
+// lint exits with status 1 if a problem of severity error is found.
+// The files are checked one at a time, unless the rules reported by
+// sgf.ParseFile (sgf, bad-point, occupied, suicide, and ko) are disabled.
+//
+// repair writes a repaired copy of each file of dbdir to outdir, with a
+// .log file listing the changes, and copies the files it cannot repair
+// to quarantinedir. See sgfdb.RepairSGF for the defects repaired.
+//
+// normalize writes each file of dbdir to outdir in a canonical form, with
+// -n moves on a line, so copies of a game from different sources can be
+// compared with diff. See sgfdb.NormalizeSGF for the form.
+//
+// transcode writes each file of dbdir to outdir in UTF-8, converted from
+// the character set of its CA property, or the one detected, and prints
+// the files whose character set cannot be determined.
+// See sgfdb.TranscodeSGF.
+//
+// players resolves the names of the players to their IDs, with the alias
+// file of sgfdb.ReadPlayerTable. Without names, it prints the names of PB
+// and PW in the index which are not resolved, the most frequent first,
+// with the names within -max edits, to be added to the alias file, and
+// the names of the alias file which are names of more than one player.
+//
+// stats prints the career statistics of the players of the games of dbdir
+// selected by the filter expression, as text, csv, or json lines: the
+// results as Black and White, and by year, the dates of the first and last
+// games, the -n most frequent opponents, the handicaps, komi, and average
+// length of the games, i.e.
+//
+//	sgfdb stats -r -aliases players.txt -player 'Go Seigen' GoGoD
+//
+// ratings prints the ratings of the players of dbdir, fitted to the
+// results of their dated games, by Elo, or by a whole-history
+// Bradley-Terry fit with a rating for each year, allowing for the
+// handicap and komi. With -csv, it prints the rating history of each
+// player. See sgfdb.RatingOptions.
+//
+// count reads the games of dbdir, and prints the number of files, games,
+// nodes, moves, passes, setup stones, variations, and main line moves,
+// with -d, of each directory. With -approx, it counts the semi-colons of
+// each file, as CountFilesAndMoves does, which is faster, but counts
+// the root and setup nodes, and semi-colons in comments, as moves.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/Ken1JF/ah"
+	"github.com/Ken1JF/sgf"
+	"github.com/Ken1JF/sgfdb"
+)
+
+const (
+	defaultIndex     = "index.gob.gz"
+	defaultPositions = "positions.gob.gz"
+	defaultPatterns  = "patterns.gob.gz"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb index [-o index] [-r] [-refresh] [-utf8=false] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb query [-i index] [-l] expr\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb positions [-o positions] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb find [-i positions] fragment\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb patterns [-o patterns] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb shape [-i patterns] [-corner] rows\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb openings [-n moves] [-min games] [-filter expr] [-o file] [-next fragment] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb joseki [-n moves] [-region points] [-min count] [-filter expr] [-o file] [-find fragment] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb dedupe [-min moves] [-diff moves] [-plan file] [-near] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb lint [-enable rules] [-disable rules] [-required props] [-rules] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb repair -o outdir -q quarantinedir [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb normalize -o outdir [-n moves] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb transcode -o outdir [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb players -aliases file [-i index] [-max edits] [name ...]\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb stats [-aliases file] [-filter expr] [-player id] [-min games] [-n opponents] [-format text|csv|json] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb ratings [-method elo|bt] [-k factor] [-aliases file] [-filter expr] [-min games] [-history] [-csv] [-r] dbdir\n")
+	fmt.Fprintf(os.Stderr, "\tsgfdb count [-approx] [-d] [-r] dbdir\n")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "index":
+		err = indexCmd(os.Args[2:])
+	case "query":
+		err = queryCmd(os.Args[2:])
+	case "positions":
+		err = positionsCmd(os.Args[2:])
+	case "find":
+		err = findCmd(os.Args[2:])
+	case "patterns":
+		err = patternsCmd(os.Args[2:])
+	case "shape":
+		err = shapeCmd(os.Args[2:])
+	case "openings":
+		err = openingsCmd(os.Args[2:])
+	case "joseki":
+		err = josekiCmd(os.Args[2:])
+	case "dedupe":
+		err = dedupeCmd(os.Args[2:])
+	case "lint":
+		err = lintCmd(os.Args[2:])
+	case "repair":
+		err = repairCmd(os.Args[2:])
+	case "normalize":
+		err = normalizeCmd(os.Args[2:])
+	case "transcode":
+		err = transcodeCmd(os.Args[2:])
+	case "players":
+		err = playersCmd(os.Args[2:])
+	case "stats":
+		err = statsCmd(os.Args[2:])
+	case "ratings":
+		err = ratingsCmd(os.Args[2:])
+	case "count":
+		err = countCmd(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sgfdb %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// dbRequest returns a request to process the directories of dbDir,
+// scheduling the files on all of the CPUs.
+func dbRequest(dbDir string, recursive bool) *sgfdb.DBProcessRequest {
+	if !strings.HasSuffix(dbDir, "/") {
+		dbDir += "/"
+	}
+	return &sgfdb.DBProcessRequest{
+		DBIndexName: dbDir,
+		DoMultiCPU:  true,
+		MaxAtOnce:   runtime.NumCPU(),
+		NumCPUs:     runtime.NumCPU(),
+		Schedule:    sgfdb.ScheduleFiles,
+		Recursive:   recursive,
+	}
+}
+
+func indexCmd(args []string) error {
+	fl := flag.NewFlagSet("index", flag.ExitOnError)
+	out := fl.String("o", defaultIndex, "index file to write")
+	recursive := fl.Bool("r", false, "index the directories below the directories of dbdir")
+	refresh := fl.Bool("refresh", false, "only parse the files changed since the index was written")
+	toUTF8 := fl.Bool("utf8", true, "convert the files to UTF-8 before indexing them")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	var old *sgfdb.GameIndex
+	if *refresh {
+		var err error
+		old, err = sgfdb.LoadGameIndex(*out)
+		if err != nil {
+			return err
+		}
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Transcode = *toUTF8
+	ix, err := sgfdb.RefreshGameIndex(context.Background(), dbrq, old)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d games indexed\n", len(ix.Records))
+	return ix.Save(*out)
+}
+
+func queryCmd(args []string) error {
+	fl := flag.NewFlagSet("query", flag.ExitOnError)
+	in := fl.String("i", defaultIndex, "index file to read")
+	long := fl.Bool("l", false, "print the records, not only the paths")
+	fl.Parse(args)
+	if fl.NArg() == 0 {
+		usage()
+	}
+	q, err := sgfdb.ParseQuery(strings.Join(fl.Args(), " "))
+	if err != nil {
+		return err
+	}
+	ix, err := sgfdb.LoadGameIndex(*in)
+	if err != nil {
+		return err
+	}
+	for _, rec := range ix.Select(q) {
+		if !*long {
+			fmt.Println(rec.Path)
+			continue
+		}
+		fmt.Print(rec.Path)
+		for _, id := range sgfdb.IndexedProperties {
+			if v := rec.Property(id); v != "" {
+				fmt.Printf("\t%s[%s]", id, v)
+			}
+		}
+		fmt.Printf("\tMV[%d]\n", rec.Moves)
+	}
+	return nil
+}
+
+func positionsCmd(args []string) error {
+	fl := flag.NewFlagSet("positions", flag.ExitOnError)
+	out := fl.String("o", defaultPositions, "position index file to write")
+	recursive := fl.Bool("r", false, "index the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	px, err := sgfdb.BuildPositionIndex(context.Background(), dbRequest(fl.Arg(0), *recursive))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d positions of %d games indexed\n", len(px.Entries), len(px.Paths))
+	return px.Save(*out)
+}
+
+func findCmd(args []string) error {
+	fl := flag.NewFlagSet("find", flag.ExitOnError)
+	in := fl.String("i", defaultPositions, "position index file to read")
+	fl.Parse(args)
+	if fl.NArg() == 0 {
+		usage()
+	}
+	px, err := sgfdb.LoadPositionIndex(*in)
+	if err != nil {
+		return err
+	}
+	matches, err := px.LookupSGF([]byte(strings.Join(fl.Args(), " ")))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		fmt.Printf("%s\t%d\n", m.Path, m.Move)
+	}
+	return nil
+}
+
+func patternsCmd(args []string) error {
+	fl := flag.NewFlagSet("patterns", flag.ExitOnError)
+	out := fl.String("o", defaultPatterns, "pattern index file to write")
+	recursive := fl.Bool("r", false, "index the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	px, err := sgfdb.BuildPatternIndex(context.Background(), dbRequest(fl.Arg(0), *recursive))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d games indexed\n", len(px.Paths))
+	return px.Save(*out)
+}
+
+func shapeCmd(args []string) error {
+	fl := flag.NewFlagSet("shape", flag.ExitOnError)
+	in := fl.String("i", defaultPatterns, "pattern index file to read")
+	corner := fl.Bool("corner", false, "place the pattern at a corner of the board")
+	fl.Parse(args)
+	if fl.NArg() == 0 {
+		usage()
+	}
+	p, err := sgfdb.ParsePattern(strings.Join(fl.Args(), "/"), *corner)
+	if err != nil {
+		return err
+	}
+	px, err := sgfdb.LoadPatternIndex(*in)
+	if err != nil {
+		return err
+	}
+	px.MaxAtOnce = runtime.NumCPU()
+	matches, err := px.Search(context.Background(), p)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		rev := ""
+		if m.Reversed {
+			rev = " reversed"
+		}
+		fmt.Printf("%s\t%d\t%d,%d\t%s%s\n", m.Path, m.Move, m.Col, m.Row, ah.TransName[m.Trans], rev)
+	}
+	return nil
+}
+
+func openingsCmd(args []string) error {
+	fl := flag.NewFlagSet("openings", flag.ExitOnError)
+	moves := fl.Int("n", 20, "number of moves of each game")
+	minGames := fl.Int("min", 1, "drop the moves played in fewer games")
+	filter := fl.String("filter", "", "query expression selecting the games")
+	out := fl.String("o", "openings.sgf", "SGF file to write")
+	next := fl.String("next", "", "print the moves played after this SGF fragment")
+	recursive := fl.Bool("r", false, "read the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	opts := sgfdb.OpeningOptions{Moves: *moves, MinGames: *minGames}
+	if *filter != "" {
+		q, err := sgfdb.ParseQuery(*filter)
+		if err != nil {
+			return err
+		}
+		opts.Filter = q
+	}
+	ot, err := sgfdb.BuildOpeningTree(context.Background(), dbRequest(fl.Arg(0), *recursive), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d games\n", ot.Root.Games)
+	if *next != "" {
+		mvs, err := ot.Next([]byte(*next))
+		if err != nil {
+			return err
+		}
+		for _, mv := range mvs {
+			fmt.Printf("%s[%s]\t%s\n", mv.Color, mv.Move, mv.OpeningCounts)
+		}
+		return nil
+	}
+	return ot.WriteFile(*out)
+}
+
+func josekiCmd(args []string) error {
+	fl := flag.NewFlagSet("joseki", flag.ExitOnError)
+	moves := fl.Int("n", 30, "longest corner sequence")
+	region := fl.Int("region", 0, "size of the corners, half of the board if 0")
+	minCount := fl.Int("min", 1, "drop the moves played in fewer sequences")
+	filter := fl.String("filter", "", "query expression selecting the games")
+	out := fl.String("o", "joseki.sgf", "SGF file to write")
+	find := fl.String("find", "", "print the count of this SGF sequence in each era")
+	recursive := fl.Bool("r", false, "read the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	opts := sgfdb.JosekiOptions{Moves: *moves, Region: *region, MinCount: *minCount}
+	if *filter != "" {
+		q, err := sgfdb.ParseQuery(*filter)
+		if err != nil {
+			return err
+		}
+		opts.Filter = q
+	}
+	jt, err := sgfdb.BuildJosekiTree(context.Background(), dbRequest(fl.Arg(0), *recursive), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d corner sequences\n", jt.Root.Count)
+	if *find != "" {
+		n, err := jt.Find([]byte(*find))
+		if err == sgfdb.ErrNotInTree {
+			return fmt.Errorf("%s: not found", *find)
+		}
+		if err != nil {
+			return err
+		}
+		for _, era := range jt.Eras {
+			fmt.Printf("%s\t%d\t%.2f%%\n", era, n.Eras[era], 100*jt.Share(n, era))
+		}
+		return nil
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = jt.WriteSGF(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func dedupeCmd(args []string) error {
+	fl := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	minMoves := fl.Int("min", 20, "do not compare shorter games")
+	maxDiff := fl.Int("diff", 4, "most moves differing in near duplicates, none if < 0")
+	plan := fl.String("plan", "", "file to write the paths of the games to remove")
+	near := fl.Bool("near", false, "remove the near duplicates in the plan")
+	recursive := fl.Bool("r", false, "read the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	rep, err := sgfdb.FindDuplicates(context.Background(), dbRequest(fl.Arg(0), *recursive), sgfdb.DedupeOptions{MinMoves: *minMoves, MaxDiff: *maxDiff})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d groups of duplicates in %d games\n", len(rep.Groups), rep.Games)
+	if err := rep.WriteReport(os.Stdout); err != nil {
+		return err
+	}
+	if *plan == "" {
+		return nil
+	}
+	f, err := os.Create(*plan)
+	if err != nil {
+		return err
+	}
+	err = rep.WritePlan(f, *near)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// idList splits a comma separated list of IDs.
+func idList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func lintCmd(args []string) error {
+	fl := flag.NewFlagSet("lint", flag.ExitOnError)
+	enable := fl.String("enable", "", "rules checked, all if empty")
+	disable := fl.String("disable", "", "rules not checked")
+	required := fl.String("required", strings.Join(sgfdb.DefaultRequired, ","), "root properties of the root-missing rule")
+	list := fl.Bool("rules", false, "list the rules")
+	recursive := fl.Bool("r", false, "check the directories below the directories of dbdir")
+	fl.Parse(args)
+	if *list {
+		for _, r := range sgfdb.LintRules {
+			fmt.Printf("%s\t%s\t%s\n", r.ID, r.Severity, r.Doc)
+		}
+		return nil
+	}
+	if fl.NArg() != 1 {
+		usage()
+	}
+	opts := sgfdb.LintOptions{Enable: idList(*enable), Disable: idList(*disable), Required: idList(*required)}
+	if opts.Required == nil {
+		opts.Required = []string{}
+	}
+	findings, err := sgfdb.LintDatabase(context.Background(), dbRequest(fl.Arg(0), *recursive), opts)
+	if findings == nil && err != nil {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	if err := sgfdb.WriteLintFindings(os.Stdout, findings); err != nil {
+		return err
+	}
+	for _, f := range findings {
+		if f.Severity == sgfdb.SeverityError {
+			os.Exit(1)
+		}
+	}
+	return nil
+}
+
+func repairCmd(args []string) error {
+	fl := flag.NewFlagSet("repair", flag.ExitOnError)
+	out := fl.String("o", "", "directory to write the repaired files")
+	quarantine := fl.String("q", "", "directory to write the files which cannot be repaired")
+	recursive := fl.Bool("r", false, "repair the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 || *out == "" || *quarantine == "" {
+		usage()
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Requester = "sgfdb repair"
+	dbrq.DBOutName = *out
+	dbrq.Quarantine = sgfdb.DirSink(*quarantine)
+	dbrq.PModeReq = sgf.ParserGoGoD | sgf.ParserPlay
+	dbrq.FileActionFunc = sgfdb.RepairSGFFile
+	err := sgfdb.ProcessDatabaseContext(context.Background(), dbrq)
+	_, files, changes, errs := dbrq.Totals()
+	fmt.Fprintf(os.Stderr, "%d files, %d changes, %d not repaired\n", files, changes, errs)
+	return err
+}
+
+func normalizeCmd(args []string) error {
+	fl := flag.NewFlagSet("normalize", flag.ExitOnError)
+	out := fl.String("o", "", "directory to write the normalized files")
+	numPerLine := fl.Int("n", sgf.DefaultNumPerLine, "number of moves per line")
+	recursive := fl.Bool("r", false, "normalize the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 || *out == "" {
+		usage()
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Requester = "sgfdb normalize"
+	dbrq.DBOutName = *out
+	dbrq.PModeReq = sgf.ParserGoGoD | sgf.ParserPlay
+	dbrq.FileActionFunc = sgfdb.WriteSGFFile
+	dbrq.NumPerLine = *numPerLine
+	dbrq.VerifyOutput = true
+	dbrq.Normalize = true
+	err := sgfdb.ProcessDatabaseContext(context.Background(), dbrq)
+	_, files, _, errs := dbrq.Totals()
+	fmt.Fprintf(os.Stderr, "%d files, %d not normalized\n", files, errs)
+	return err
+}
+
+func transcodeCmd(args []string) error {
+	fl := flag.NewFlagSet("transcode", flag.ExitOnError)
+	out := fl.String("o", "", "directory to write the files in UTF-8")
+	recursive := fl.Bool("r", false, "transcode the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 || *out == "" {
+		usage()
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Requester = "sgfdb transcode"
+	dbrq.DBOutName = *out
+	dbrq.FileActionFunc = sgfdb.TranscodeSGFFile
+	err := sgfdb.ProcessDatabaseContext(context.Background(), dbrq)
+	_, files, transcoded, errs := dbrq.Totals()
+	fmt.Fprintf(os.Stderr, "%d files, %d transcoded, %d not determined\n", files, transcoded, errs)
+	return err
+}
+
+func playersCmd(args []string) error {
+	fl := flag.NewFlagSet("players", flag.ExitOnError)
+	aliases := fl.String("aliases", "", "alias file of the players")
+	in := fl.String("i", defaultIndex, "index file to read")
+	maxDist := fl.Int("max", 2, "maximum edit distance of the suggested names")
+	fl.Parse(args)
+	if *aliases == "" {
+		usage()
+	}
+	pt, err := sgfdb.LoadPlayerTable(*aliases)
+	if err != nil {
+		return err
+	}
+	if fl.NArg() > 0 {
+		for _, name := range fl.Args() {
+			if id, ok := pt.Resolve(name); ok {
+				fmt.Printf("%s\t%s\n", name, id)
+				continue
+			}
+			if ids := pt.AmbiguousPlayers(name); ids != nil {
+				fmt.Printf("%s\tambiguous\t%s\n", name, strings.Join(ids, "\t"))
+				continue
+			}
+			fmt.Printf("%s\t?", name)
+			for _, m := range pt.Suggest(name, *maxDist) {
+				fmt.Printf("\t%s (%s, %d)", m.ID, m.Name, m.Distance)
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+	ix, err := sgfdb.LoadGameIndex(*in)
+	if err != nil {
+		return err
+	}
+	return sgfdb.WriteUnresolved(os.Stdout, pt.Unresolved(ix, *maxDist))
+}
+
+func statsCmd(args []string) error {
+	fl := flag.NewFlagSet("stats", flag.ExitOnError)
+	aliases := fl.String("aliases", "", "alias file of the players")
+	filter := fl.String("filter", "", "query expression selecting the games")
+	player := fl.String("player", "", "print only the statistics of this player")
+	minGames := fl.Int("min", 1, "drop the players of fewer games")
+	opponents := fl.Int("n", 10, "number of opponents of each player")
+	format := fl.String("format", "text", "text, csv, or json")
+	recursive := fl.Bool("r", false, "read the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	opts := sgfdb.PlayerStatsOptions{Opponents: *opponents, MinGames: *minGames}
+	if *aliases != "" {
+		pt, err := sgfdb.LoadPlayerTable(*aliases)
+		if err != nil {
+			return err
+		}
+		opts.Players = pt
+	}
+	if *filter != "" {
+		q, err := sgfdb.ParseQuery(*filter)
+		if err != nil {
+			return err
+		}
+		opts.Filter = q
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Transcode = true
+	pr, err := sgfdb.BuildPlayerStats(context.Background(), dbrq, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	if *player != "" {
+		id := *player
+		if opts.Players != nil {
+			if pid, ok := opts.Players.Resolve(id); ok {
+				id = pid
+			}
+		}
+		ps := pr.Player(id)
+		if ps == nil {
+			return fmt.Errorf("no games of %s", *player)
+		}
+		pr.Players = []*sgfdb.PlayerStats{ps}
+	}
+	switch *format {
+	case "text":
+		return pr.WriteText(os.Stdout)
+	case "csv":
+		return pr.WriteCSV(os.Stdout)
+	case "json":
+		return pr.WriteJSON(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %s", *format)
+}
+
+func ratingsCmd(args []string) error {
+	fl := flag.NewFlagSet("ratings", flag.ExitOnError)
+	method := fl.String("method", "elo", "elo, or bt for Bradley-Terry")
+	k := fl.Float64("k", 16, "Elo K factor")
+	aliases := fl.String("aliases", "", "alias file of the players")
+	filter := fl.String("filter", "", "query expression selecting the games")
+	minGames := fl.Int("min", 1, "drop the players of fewer games")
+	history := fl.Bool("history", false, "print the rating history of each player")
+	asCSV := fl.Bool("csv", false, "print the rating histories as CSV")
+	recursive := fl.Bool("r", false, "read the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	opts := sgfdb.RatingOptions{K: *k, MinGames: *minGames}
+	switch *method {
+	case "elo":
+		opts.Method = sgfdb.Elo
+	case "bt":
+		opts.Method = sgfdb.BradleyTerry
+	default:
+		return fmt.Errorf("unknown method %s", *method)
+	}
+	if *aliases != "" {
+		pt, err := sgfdb.LoadPlayerTable(*aliases)
+		if err != nil {
+			return err
+		}
+		opts.Players = pt
+	}
+	if *filter != "" {
+		q, err := sgfdb.ParseQuery(*filter)
+		if err != nil {
+			return err
+		}
+		opts.Filter = q
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Transcode = true
+	rt, err := sgfdb.BuildRatings(context.Background(), dbrq, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	if *asCSV {
+		return rt.WriteCSV(os.Stdout)
+	}
+	return rt.WriteText(os.Stdout, *history)
+}
+
+func countCmd(args []string) error {
+	fl := flag.NewFlagSet("count", flag.ExitOnError)
+	approx := fl.Bool("approx", false, "count the semi-colons of each file, without reading the games")
+	perDir := fl.Bool("d", false, "print the counts of each directory")
+	recursive := fl.Bool("r", false, "count the directories below the directories of dbdir")
+	fl.Parse(args)
+	if fl.NArg() != 1 {
+		usage()
+	}
+	dbrq := dbRequest(fl.Arg(0), *recursive)
+	dbrq.Requester = "sgfdb count"
+	if *approx {
+		dbrq.FileActionFunc = sgfdb.CountMoves
+		if *perDir {
+			dbrq.EndDirActionFunc = sgfdb.ReportDirCounts
+		}
+		err := sgfdb.ProcessDatabaseContext(context.Background(), dbrq)
+		dirs, files, moves, _ := dbrq.Totals()
+		fmt.Printf("directories: %d, files: %d, moves (approximate): %d\n", dirs, files, moves)
+		return err
+	}
+	if *perDir {
+		dbrq.EndDirActionFunc = func(r *sgfdb.DirectoryProcessRequest, fName string, b []byte) {
+			if c, ok := r.Result().(sgfdb.MoveCounts); ok {
+				fmt.Printf("%s: %s\n", r.RelDir(), c)
+			}
+		}
+	}
+	c, err := sgfdb.CountDatabaseMoves(context.Background(), dbrq)
+	fmt.Printf("%s\n", c)
+	return err
+}
@@ -0,0 +1,200 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/compare.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SGFDiff is the first difference found by CompareSGF between two files.
+type SGFDiff struct {
+	Game      int    // index of the game in the file
+	Node      int    // index of the node from the root
+	Variation string // the variations taken from the main line, i.e. "0.1", "" for the main line
+	Msg       string
+}
+
+func (d *SGFDiff) Error() string {
+	s := "game " + strconv.Itoa(d.Game) + ", node " + strconv.Itoa(d.Node)
+	if d.Variation != "" {
+		s += ", variation " + d.Variation
+	}
+	return s + ": " + d.Msg
+}
+
+// sgfCursor is a node of an sgfTree: the node i of t.nodes.
+type sgfCursor struct {
+	t *sgfTree
+	i int
+}
+
+// children returns the nodes following the node of c.
+// A tree with a single variation is the same as its sequence of nodes
+// followed by the nodes of the variation.
+func (c sgfCursor) children() []sgfCursor {
+	if c.i+1 < len(c.t.nodes) {
+		return []sgfCursor{{c.t, c.i + 1}}
+	}
+	var cs []sgfCursor
+	for _, v := range c.t.vars {
+		if len(v.nodes) > 0 {
+			cs = append(cs, sgfCursor{v, 0})
+		} else {
+			cs = append(cs, sgfCursor{v, -1}.children()...)
+		}
+	}
+	return cs
+}
+
+// CompareSGF compares the SGF files a and b, game by game, node by node,
+// and property by property, and returns an *SGFDiff for the first
+// difference, or nil if they hold the same games.
+// The layout of the files, the order of the properties of a node, the
+// order of the points of a list, compressed point lists, the white space
+// in text values, and passes written "tt" or [], are ignored.
+func CompareSGF(a []byte, b []byte) error {
+	ta, err := readSGF(a)
+	if err != nil {
+		return fmt.Errorf("reading the first file: %w", err)
+	}
+	tb, err := readSGF(b)
+	if err != nil {
+		return fmt.Errorf("reading the second file: %w", err)
+	}
+	for g := 0; g < len(ta) && g < len(tb); g++ {
+		if len(ta[g].nodes) == 0 || len(tb[g].nodes) == 0 {
+			if len(ta[g].nodes) != len(tb[g].nodes) {
+				return &SGFDiff{Game: g, Msg: "empty game"}
+			}
+			continue
+		}
+		size, _ := boardSize(ta[g].nodes[0].value("SZ"))
+		if d := compareNodes(sgfCursor{ta[g], 0}, sgfCursor{tb[g], 0}, size, 0, nil); d != nil {
+			d.Game = g
+			return d
+		}
+	}
+	if len(ta) != len(tb) {
+		return &SGFDiff{Game: min(len(ta), len(tb)), Msg: fmt.Sprintf("%d games, and %d games", len(ta), len(tb))}
+	}
+	return nil
+}
+
+// compareNodes compares the nodes of a and b, number node, and the nodes following them.
+func compareNodes(a sgfCursor, b sgfCursor, size int, node int, variation []int) *SGFDiff {
+	for {
+		if msg := compareProps(a.t.nodes[a.i], b.t.nodes[b.i], size); msg != "" {
+			return newSGFDiff(node, variation, msg)
+		}
+		ca, cb := a.children(), b.children()
+		if len(ca) != len(cb) {
+			return newSGFDiff(node, variation, fmt.Sprintf("followed by %d nodes, and %d nodes", len(ca), len(cb)))
+		}
+		node++
+		if len(ca) == 0 {
+			return nil
+		}
+		if len(ca) == 1 {
+			a, b = ca[0], cb[0]
+			continue
+		}
+		for i := range ca {
+			v := append(variation[:len(variation):len(variation)], i)
+			if d := compareNodes(ca[i], cb[i], size, node, v); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+}
+
+// newSGFDiff returns the difference msg at the node number node of the variation.
+func newSGFDiff(node int, variation []int, msg string) *SGFDiff {
+	d := &SGFDiff{Node: node, Msg: msg}
+	if len(variation) > 0 {
+		vs := make([]string, len(variation))
+		for i, v := range variation {
+			vs[i] = strconv.Itoa(v)
+		}
+		d.Variation = strings.Join(vs, ".")
+	}
+	return d
+}
+
+// pointListProps are the properties with lists of points.
+var pointListProps = map[string]bool{
+	"AB": true, "AW": true, "AE": true, "TR": true, "CR": true, "SQ": true,
+	"MA": true, "SL": true, "DD": true, "VW": true, "TB": true, "TW": true,
+}
+
+// compareValues returns the values of the property p, to be compared.
+func compareValues(p *sgfProp, size int) []string {
+	var vals []string
+	switch {
+	case p.id == "B" || p.id == "W":
+		for _, v := range p.vals {
+			if isPass(v, size) {
+				v = ""
+			}
+			vals = append(vals, v)
+		}
+	case pointListProps[p.id]:
+		pts, bad := pointList(p.vals)
+		for _, pt := range pts {
+			vals = append(vals, sgfPointValue(pt[0], pt[1]))
+		}
+		vals = append(vals, bad...)
+		sort.Strings(vals)
+		uniq := vals[:0]
+		for i, v := range vals {
+			if i == 0 || v != vals[i-1] {
+				uniq = append(uniq, v)
+			}
+		}
+		vals = uniq
+	default:
+		for _, v := range p.vals {
+			vals = append(vals, strings.Join(strings.Fields(v), " "))
+		}
+	}
+	return vals
+}
+
+// compareProps compares the properties of the nodes a and b,
+// and returns a description of the first difference, or "".
+func compareProps(a *sgfNode, b *sgfNode, size int) string {
+	ids := make(map[string]bool)
+	for _, p := range a.props {
+		ids[p.id] = true
+	}
+	for _, p := range b.props {
+		ids[p.id] = true
+	}
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		pa, pb := a.prop(id), b.prop(id)
+		switch {
+		case pb == nil:
+			return fmt.Sprintf("%s[%s] missing in the second file", id, strings.Join(pa.vals, "]["))
+		case pa == nil:
+			return fmt.Sprintf("%s[%s] missing in the first file", id, strings.Join(pb.vals, "]["))
+		}
+		va, vb := compareValues(pa, size), compareValues(pb, size)
+		if strings.Join(va, "\x00") != strings.Join(vb, "\x00") || len(va) != len(vb) {
+			return fmt.Sprintf("%s[%s], and %s[%s]", id, strings.Join(pa.vals, "]["), id, strings.Join(pb.vals, "]["))
+		}
+	}
+	return ""
+}
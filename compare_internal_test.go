@@ -0,0 +1,38 @@
+package sgfdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareGameTrees(t *testing.T) {
+	const game = "(;GM[1]FF[4]SZ[19]PB[Black]C[a comment];B[pd](;W[dd];B[pp])(;W[dp]))"
+	r := &DirectoryProcessRequest{dir: "a", dbReq: &DBProcessRequest{}}
+	prsr, errL := parseSGF("a/1.sgf", []byte(game), r.dbReq.PModeReq, 0)
+	if len(errL) != 0 {
+		t.Fatal(errL)
+	}
+	tests := []struct {
+		out  string
+		diff string
+	}{
+		// only the order of the properties, and the white space, differ
+		{"(;SZ[19] FF[4]\n GM[1] C[a\n  comment]PB[Black]\n;B[pd]\n(;W[dd];B[pp])\n(;W[dp]))", ""},
+		// one value differs
+		{"(;GM[1]FF[4]SZ[19]PB[Black]C[a comment];B[pd](;W[dd];B[pq])(;W[dp]))", "game 0, node 3, variation 0: B[pp], and B[pq]"},
+	}
+	for _, tst := range tests {
+		err := r.compareGameTrees("1.sgf", &prsr.GameTree, []byte(tst.out))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tst.diff {
+			t.Errorf("%s:\ngot      %q\nexpected %q", tst.out, got, tst.diff)
+		}
+		var d *SGFDiff
+		if tst.diff != "" && !errors.As(err, &d) {
+			t.Errorf("%s: expected an *SGFDiff, got %T", tst.out, err)
+		}
+	}
+}
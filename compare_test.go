@@ -0,0 +1,52 @@
+package sgfdb_test
+
+import (
+	"context"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestCompareSGF(t *testing.T) {
+	const game = "(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a  comment\n here];B[pd](;W[dd];B[tt])(;W[dp]))"
+	tests := []struct {
+		b    string
+		diff string
+	}{
+		{game, ""},
+		{"(;FF[4]GM[1]\n SZ[19] AB[pp][dd] C[a comment here]\n;B[pd]\n(;W[dd];B[])\n(;W[dp]))", ""},
+		{"(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a comment here](;B[pd](;W[dd](;B[tt]))(;W[dp])))", ""},
+		{"(;GM[1]FF[4]SZ[19]AB[dd]C[a comment here];B[pd](;W[dd];B[tt])(;W[dp]))", "game 0, node 0: AB[dd][pp], and AB[dd]"},
+		{"(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a comment here];B[pd](;W[dd];B[tt])(;W[dq]))", "game 0, node 2, variation 1: W[dp], and W[dq]"},
+		{"(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a comment here];B[pd](;W[dd])(;W[dp]))", "game 0, node 2, variation 0: followed by 1 nodes, and 0 nodes"},
+		{"(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a comment here];B[pd];W[dd];B[tt])", "game 0, node 1: followed by 2 nodes, and 1 nodes"},
+		{"(;GM[1]FF[4]SZ[19]AB[dd][pp]C[a comment here]KM[6.5];B[pd](;W[dd];B[tt])(;W[dp]))", "game 0, node 0: KM[6.5] missing in the first file"},
+		{game + "(;GM[1])", "game 1, node 0: 1 games, and 2 games"},
+	}
+	for _, tst := range tests {
+		err := CompareSGF([]byte(game), []byte(tst.b))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tst.diff {
+			t.Errorf("%s:\ngot      %q\nexpected %q", tst.b, got, tst.diff)
+		}
+	}
+	if _, ok := CompareSGF([]byte(game), []byte(tests[3].b)).(*SGFDiff); !ok {
+		t.Errorf("expected an *SGFDiff")
+	}
+}
+
+func TestWriteSGFFileVerify(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1]FF[4]SZ[19];B[pd];W[dd])",
+	}
+	var sink MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &sink, FileActionFunc: WriteSGFFile, VerifyOutput: true})
+	if err := ProcessDatabaseContext(context.Background(), dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.Files) != 1 {
+		t.Errorf("output: %q", sink.Files)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/counts.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"context"
+	"fmt"
+)
+
+// MoveCounts holds the counts of the nodes and moves of SGF files.
+type MoveCounts struct {
+	Files      int // files read
+	Games      int // game trees
+	Nodes      int // nodes, including the root and setup nodes
+	Moves      int // B and W properties, including passes
+	Passes     int // B and W properties which are passes
+	Setup      int // stones added by AB and AW
+	Variations int // variations other than the main line
+	MainLine   int // moves of the main lines
+}
+
+// Add adds the counts of o to c.
+func (c *MoveCounts) Add(o MoveCounts) {
+	c.Files += o.Files
+	c.Games += o.Games
+	c.Nodes += o.Nodes
+	c.Moves += o.Moves
+	c.Passes += o.Passes
+	c.Setup += o.Setup
+	c.Variations += o.Variations
+	c.MainLine += o.MainLine
+}
+
+func (c MoveCounts) String() string {
+	return fmt.Sprintf("files: %d, games: %d, nodes: %d, moves: %d, passes: %d, setup: %d, variations: %d, main line: %d",
+		c.Files, c.Games, c.Nodes, c.Moves, c.Passes, c.Setup, c.Variations, c.MainLine)
+}
+
+// countNode adds the moves, passes, and setup stones of n to c.
+func (c *MoveCounts) countNode(n *sgfNode, size int) {
+	for _, id := range []string{"B", "W"} {
+		if p := n.prop(id); p != nil {
+			c.Moves++
+			v := ""
+			if len(p.vals) > 0 {
+				v = p.vals[0]
+			}
+			if isPass(v, size) {
+				c.Passes++
+			}
+		}
+	}
+	for _, id := range []string{"AB", "AW"} {
+		if p := n.prop(id); p != nil {
+			pts, _ := pointList(p.vals)
+			c.Setup += len(pts)
+		}
+	}
+}
+
+// CountSGF reads b, an SGF collection, with readSGF, and returns the counts
+// of its games. Semi-colons in property values, i.e. comments, do not start
+// nodes, so are not counted.
+// If there is an error, the counts of the nodes read before the error are returned.
+func CountSGF(b []byte) (MoveCounts, error) {
+	c := MoveCounts{Files: 1}
+	trees, err := readSGF(b)
+	for _, t := range trees {
+		c.Games++
+		size := 19
+		if t.nodes[0].prop("SZ") != nil {
+			size, _ = boardSize(t.nodes[0].value("SZ"))
+		}
+		c.countTree(t, size, true)
+	}
+	return c, err
+}
+
+// countTree adds the counts of the nodes of t, and of its variations, to c.
+// main reports whether t is on the main line.
+func (c *MoveCounts) countTree(t *sgfTree, size int, main bool) {
+	for _, n := range t.nodes {
+		moves := c.Moves
+		c.Nodes++
+		c.countNode(n, size)
+		if main {
+			c.MainLine += c.Moves - moves
+		}
+	}
+	if len(t.vars) > 1 {
+		c.Variations += len(t.vars) - 1
+	}
+	for i, v := range t.vars {
+		c.countTree(v, size, main && i == 0)
+	}
+}
+
+// CountNodesAndMoves is an action function, like CountMoves,
+// which reads each file, and counts its B and W moves,
+// without the root, setup nodes, or semi-colons in text values.
+func CountNodesAndMoves(req *DirectoryProcessRequest, fName string, b []byte) {
+	req.cntf++
+	c, err := CountSGF(b)
+	req.cntm += c.Moves
+	if err != nil {
+		req.FileError(fName, "Counting", err)
+	}
+}
+
+// CountDatabaseMoves reads the games of the database of dbrq,
+// and returns the counts of their nodes and moves.
+// The counts of each directory are the Result of its DirectoryProcessRequest,
+// i.e. for use by an EndDirActionFunc.
+func CountDatabaseMoves(ctx context.Context, dbrq *DBProcessRequest) (MoveCounts, error) {
+	defer un(trace("CountDatabaseMoves"), nil)
+	return ProcessDatabaseReduce(ctx, dbrq, Reducer[MoveCounts, MoveCounts, MoveCounts]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) MoveCounts {
+			c, err := CountSGF(b)
+			if err != nil {
+				r.FileError(fName, "Counting", err)
+			}
+			return c
+		},
+		Dir: func(d MoveCounts, f MoveCounts) MoveCounts {
+			d.Add(f)
+			return d
+		},
+		DB: func(res MoveCounts, d MoveCounts) MoveCounts {
+			res.Add(d)
+			return res
+		},
+	})
+}
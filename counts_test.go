@@ -0,0 +1,76 @@
+package sgfdb_test
+
+import (
+	"context"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestCountSGF(t *testing.T) {
+	tests := []struct {
+		sgf    string
+		counts MoveCounts
+	}{
+		{";(;GM[1];B[aa];W[bb])", MoveCounts{Files: 1, Games: 1, Nodes: 3, Moves: 2, MainLine: 2}},
+		{"(;GM[1]GN[a;b]C[x;y;z];B[pd]C[;];W[])", MoveCounts{Files: 1, Games: 1, Nodes: 3, Moves: 2, Passes: 1, MainLine: 2}},
+		{"(;GM[1]SZ[19]AB[aa:bc]AW[dd];W[tt];B[cc])", MoveCounts{Files: 1, Games: 1, Nodes: 3, Moves: 2, Passes: 1, Setup: 7, MainLine: 2}},
+		{"(;GM[1];B[aa](;W[bb];B[cc])(;W[cc])(;AE[aa]))", MoveCounts{Files: 1, Games: 1, Nodes: 6, Moves: 4, Variations: 2, MainLine: 3}},
+		{"(;GM[1];B[aa])(;GM[1]SZ[21];B[tt];W[dd])", MoveCounts{Files: 1, Games: 2, Nodes: 5, Moves: 3, MainLine: 3}},
+	}
+	for _, tst := range tests {
+		c, err := CountSGF([]byte(tst.sgf))
+		if err != nil && tst.sgf[0] != ';' {
+			t.Errorf("%s: %s", tst.sgf, err)
+		}
+		if c != tst.counts {
+			t.Errorf("%s: got %+v, expected %+v", tst.sgf, c, tst.counts)
+		}
+	}
+}
+
+func TestCountDatabaseMoves(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf":   "(;GM[1]C[a;b];B[aa];W[bb])",
+		"a/2.sgf":   "(;GM[1]AB[cc];W[dd](;B[ee])(;B[ff]))",
+		"a/b/3.sgf": "(;GM[1];B[aa];W[]",
+	}
+	dirs := map[string]MoveCounts{}
+	dbrq := memDB(files, DBProcessRequest{Recursive: true,
+		EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			dirs[r.RelDir()] = r.Result().(MoveCounts)
+		}})
+	c, err := CountDatabaseMoves(context.Background(), dbrq)
+	if err == nil {
+		t.Errorf("expected an error for a/b/3.sgf")
+	}
+	expected := MoveCounts{Files: 3, Games: 3, Nodes: 10, Moves: 7, Passes: 1, Setup: 1, Variations: 1, MainLine: 6}
+	if c != expected {
+		t.Errorf("got %+v, expected %+v", c, expected)
+	}
+	if dirs["a"].Files != 2 || dirs["a"].Moves != 5 || dirs["a/b"].Passes != 1 {
+		t.Errorf("directories: %+v", dirs)
+	}
+
+	// the approximate count adds the root nodes, and the semi-colon of the comment
+	dbrq = memDB(files, DBProcessRequest{Recursive: true, FileActionFunc: CountMoves})
+	if err := ProcessDatabaseContext(context.Background(), dbrq); err != nil {
+		t.Fatal(err)
+	}
+	if _, f, m, _ := dbrq.Totals(); f != 3 || m != 11 {
+		t.Errorf("approximate: %d files, %d moves", f, m)
+	}
+	// as it always has, the approximate count stops at a ';' following another,
+	// or starting the file
+	dbrq = memDB(map[string]string{"a/1.sgf": "(;GM[1];B[aa];;W[bb];B[cc])", "a/2.sgf": ";(;B[aa])"}, DBProcessRequest{Recursive: true, FileActionFunc: CountMoves})
+	if err := ProcessDatabaseContext(context.Background(), dbrq); err != nil {
+		t.Fatal(err)
+	}
+	if _, f, m, _ := dbrq.Totals(); f != 2 || m != 3 {
+		t.Errorf("approximate, stopping at ';': %d files, %d moves", f, m)
+	}
+	dbrq = memDB(files, DBProcessRequest{Recursive: true, FileActionFunc: CountNodesAndMoves})
+	ProcessDatabaseContext(context.Background(), dbrq)
+	if _, f, m, e := dbrq.Totals(); f != 3 || m != 7 || e != 1 {
+		t.Errorf("CountNodesAndMoves: %d files, %d moves, %d errors", f, m, e)
+	}
+}
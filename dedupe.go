@@ -0,0 +1,425 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/dedupe.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+
+	"github.com/Ken1JF/ah"
+)
+
+// DuplicateKind is the relation of a game of a DuplicateGroup
+// to the game kept.
+type DuplicateKind int
+
+const (
+	Kept      DuplicateKind = iota // the game kept
+	Exact                          // the same moves
+	Truncated                      // the first moves of the game kept
+	Near                           // Diff moves differ from the game kept
+)
+
+var duplicateKindNames = [...]string{"keep", "exact", "truncated", "near"}
+
+func (k DuplicateKind) String() string {
+	if k < 0 || int(k) >= len(duplicateKindNames) {
+		return fmt.Sprintf("DuplicateKind(%d)", int(k))
+	}
+	return duplicateKindNames[k]
+}
+
+// DuplicateGame is a game of a DuplicateGroup.
+type DuplicateGame struct {
+	Path  string
+	Moves int
+	Kind  DuplicateKind
+	Diff  int // moves differing from the game kept, up to the end of the shorter game
+}
+
+// DuplicateGroup holds the copies of a game, the game kept first.
+type DuplicateGroup []DuplicateGame
+
+// DedupeOptions control the comparison of the games.
+type DedupeOptions struct {
+	MinMoves int // shorter games are not compared, 20 if 0
+	MaxDiff  int // most moves differing in near duplicates, 4 if 0, none if < 0
+}
+
+// DedupeReport holds the duplicate games of a database.
+type DedupeReport struct {
+	Games  int // games compared
+	Groups []DuplicateGroup
+}
+
+// dedupeGame holds the moves of a game, by the symmetry giving the smallest sequence.
+type dedupeGame struct {
+	path  string
+	size  int
+	ops   []uint16
+	moves int
+	props int // root properties of IndexedProperties
+}
+
+// better reports whether g is a better game to keep than h:
+// a longer game, or a game with more root properties.
+func (g *dedupeGame) better(h *dedupeGame) bool {
+	if g.moves != h.moves {
+		return g.moves > h.moves
+	}
+	if g.props != h.props {
+		return g.props > h.props
+	}
+	return g.path < h.path
+}
+
+// transformOps returns the operations of a game of size size, transformed by s.
+func transformOps(ops []uint16, size int, s ah.BoardTrans, t []uint16) []uint16 {
+	t = t[:0]
+	for _, op := range ops {
+		switch gameOp(op >> opShift) {
+		case opEmpty, opBlack, opWhite, opMoveB, opMoveW:
+			p := int(op & (1<<opShift - 1))
+			c, r := TransPoint(s, p%size, p/size, size)
+			op = op&^(1<<opShift-1) | uint16(r*size+c)
+		}
+		t = append(t, op)
+	}
+	return t
+}
+
+// canonicalOps returns the operations transformed by the symmetry giving the smallest sequence.
+func canonicalOps(ops []uint16, size int) []uint16 {
+	best := transformOps(ops, size, 0, nil)
+	var t []uint16
+	for s := ah.BoardTrans(1); s < numTrans; s++ {
+		t = transformOps(ops, size, s, t)
+		if lessOps(t, best) {
+			best, t = t, best
+		}
+	}
+	return best
+}
+
+func lessOps(a []uint16, b []uint16) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func hasPrefixOps(a []uint16, prefix []uint16) bool {
+	if len(a) < len(prefix) {
+		return false
+	}
+	for i, op := range prefix {
+		if a[i] != op {
+			return false
+		}
+	}
+	return true
+}
+
+// diffOps returns the smallest number of operations differing between a
+// and b under a symmetry, up to the end of the shorter one.
+// It stops counting after limit.
+func diffOps(a []uint16, b []uint16, size int, limit int) int {
+	best := limit + 1
+	var t []uint16
+	for s := ah.BoardTrans(0); s < numTrans; s++ {
+		t = transformOps(b, size, s, t)
+		d := 0
+		for i := 0; i < len(a) && i < len(t) && d < best; i++ {
+			if a[i] != t[i] {
+				d++
+			}
+		}
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// dedupeBlock is the number of operations of the blocks compared to find
+// near duplicates, and dedupeMaxBucket the most games sharing a block,
+// i.e. a common opening, for the block to be used.
+const (
+	dedupeBlock     = 16
+	dedupeMaxBucket = 64
+)
+
+// blockKey returns the hash of a block of operations, the same under every symmetry.
+func blockKey(block []uint16, size int) uint64 {
+	var key uint64
+	var t []uint16
+	for s := ah.BoardTrans(0); s < numTrans; s++ {
+		t = transformOps(block, size, s, t)
+		h := fnv.New64a()
+		for _, op := range t {
+			h.Write([]byte{byte(op >> 8), byte(op)})
+		}
+		if k := h.Sum64(); s == 0 || k < key {
+			key = k
+		}
+	}
+	return key
+}
+
+// FindDuplicates compares the main lines of the games of the database of
+// dbrq, under the eight symmetries, and returns the groups of copies of
+// the same game:
+// games with the same moves (Exact), games whose moves are the first moves
+// of a longer game (Truncated), and games differing from another in at most
+// opts.MaxDiff moves (Near).
+// The game kept is the longest, then the one with the most root properties.
+//
+// Near duplicates are found among the games sharing a block of 16 moves at
+// the same place, so they are always found when the games have more
+// than 16*(MaxDiff+1) moves, and differ by replaced moves, not by missing
+// or added moves in the middle of the game.
+func FindDuplicates(ctx context.Context, dbrq *DBProcessRequest, opts DedupeOptions) (*DedupeReport, error) {
+	defer un(trace("FindDuplicates"), nil)
+	if opts.MinMoves <= 0 {
+		opts.MinMoves = 20
+	}
+	if opts.MaxDiff == 0 {
+		opts.MaxDiff = 4
+	}
+	games, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[*dedupeGame, []*dedupeGame, []*dedupeGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) *dedupeGame {
+			trees, err := readSGF(b)
+			if len(trees) == 0 {
+				r.FileError(fName, "Reading moves", err)
+				return nil
+			}
+			g, err2 := compileGame(trees[0])
+			if err == nil && err2 != errNotSquare {
+				err = err2
+			}
+			if err != nil {
+				r.FileError(fName, "Reading moves", err)
+			}
+			dg := &dedupeGame{path: r.FilePath(fName), size: g.Size}
+			for _, op := range g.Ops {
+				if gameOp(op>>opShift) >= opMoveB {
+					dg.moves++
+				}
+			}
+			if dg.moves < opts.MinMoves {
+				return nil
+			}
+			var rec GameRecord
+			rec.readTree(trees[0])
+			for _, id := range IndexedProperties {
+				if rec.Property(id) != "" {
+					dg.props++
+				}
+			}
+			dg.ops = canonicalOps(g.Ops, g.Size)
+			return dg
+		},
+		Dir: func(d []*dedupeGame, g *dedupeGame) []*dedupeGame {
+			if g == nil {
+				return d
+			}
+			return append(d, g)
+		},
+		DB: func(res []*dedupeGame, d []*dedupeGame) []*dedupeGame {
+			return append(res, d...)
+		},
+	})
+	sort.Slice(games, func(i, j int) bool {
+		a, b := games[i], games[j]
+		if a.size != b.size {
+			return a.size < b.size
+		}
+		if lessOps(a.ops, b.ops) || lessOps(b.ops, a.ops) {
+			return lessOps(a.ops, b.ops)
+		}
+		return a.better(b)
+	})
+
+	// group[i] is the head of the group of games[i]: the first of the
+	// games with the same moves, or of the longest games extending them.
+	group := make([]int, len(games))
+	var heads []int
+	for i := 0; i < len(games); {
+		j := i + 1
+		for j < len(games) && games[j].size == games[i].size && !lessOps(games[i].ops, games[j].ops) {
+			j++
+		}
+		longest := -1
+		for k := j; k < len(games) && games[k].size == games[i].size && hasPrefixOps(games[k].ops, games[i].ops); k++ {
+			if longest < 0 || len(games[k].ops) > len(games[longest].ops) {
+				longest = k
+			}
+		}
+		// the longest game has no longer game extending it, so it is a head
+		if longest < 0 {
+			heads = append(heads, i)
+			longest = i
+		}
+		for k := i; k < j; k++ {
+			group[k] = longest
+		}
+		i = j
+	}
+
+	// near duplicates: union the heads sharing a block, and differing in
+	// at most MaxDiff moves
+	parent := make(map[int]int)
+	var find func(i int) int
+	find = func(i int) int {
+		p, ok := parent[i]
+		if !ok || p == i {
+			return i
+		}
+		p = find(p)
+		parent[i] = p
+		return p
+	}
+	if opts.MaxDiff > 0 {
+		type bucketKey struct {
+			size, block int
+			key         uint64
+		}
+		buckets := make(map[bucketKey][]int)
+		for _, h := range heads {
+			g := games[h]
+			for b := 0; (b+1)*dedupeBlock <= len(g.ops); b++ {
+				k := bucketKey{g.size, b, blockKey(g.ops[b*dedupeBlock:(b+1)*dedupeBlock], g.size)}
+				buckets[k] = append(buckets[k], h)
+			}
+		}
+		for _, hs := range buckets {
+			if len(hs) > dedupeMaxBucket {
+				continue
+			}
+			for x := 0; x < len(hs); x++ {
+				for y := x + 1; y < len(hs); y++ {
+					a, b := find(hs[x]), find(hs[y])
+					if a == b {
+						continue
+					}
+					ga, gb := games[hs[x]], games[hs[y]]
+					d := len(ga.ops) - len(gb.ops)
+					if d < 0 {
+						d = -d
+					}
+					if d <= opts.MaxDiff && d+diffOps(ga.ops, gb.ops, ga.size, opts.MaxDiff-d) <= opts.MaxDiff {
+						parent[b] = a
+					}
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	var keys []int
+	for i := range games {
+		k := find(group[i])
+		if _, ok := members[k]; !ok {
+			keys = append(keys, k)
+		}
+		members[k] = append(members[k], i)
+	}
+	rep := &DedupeReport{Games: len(games)}
+	sort.Slice(keys, func(i, j int) bool { return games[keys[i]].path < games[keys[j]].path })
+	for _, k := range keys {
+		if len(members[k]) == 1 {
+			continue
+		}
+		// the game kept is the best of the longest games
+		kept := members[k][0]
+		for _, i := range members[k] {
+			if games[i].better(games[kept]) {
+				kept = i
+			}
+		}
+		kg := games[kept]
+		grp := DuplicateGroup{{Path: kg.path, Moves: kg.moves, Kind: Kept}}
+		for _, i := range members[k] {
+			if i == kept {
+				continue
+			}
+			g := games[i]
+			dg := DuplicateGame{Path: g.path, Moves: g.moves}
+			switch {
+			case len(g.ops) == len(kg.ops) && hasPrefixOps(kg.ops, g.ops):
+				dg.Kind = Exact
+			case hasPrefixOps(kg.ops, g.ops):
+				dg.Kind = Truncated
+			default:
+				dg.Kind = Near
+				dg.Diff = diffOps(kg.ops, g.ops, kg.size, len(g.ops))
+			}
+			grp = append(grp, dg)
+		}
+		sort.Slice(grp[1:], func(i, j int) bool {
+			a, b := &grp[1+i], &grp[1+j]
+			if a.Kind != b.Kind {
+				return a.Kind < b.Kind
+			}
+			return a.Path < b.Path
+		})
+		rep.Groups = append(rep.Groups, grp)
+	}
+	return rep, err
+}
+
+// WriteReport writes the groups to w, one game a line, with its kind,
+// path, and number of moves, and the differing moves of near duplicates.
+// The groups are separated by empty lines.
+func (rep *DedupeReport) WriteReport(w io.Writer) error {
+	for i, grp := range rep.Groups {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		for _, g := range grp {
+			var err error
+			if g.Kind == Near {
+				_, err = fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", g.Kind, g.Path, g.Moves, g.Diff)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\t%s\t%d\n", g.Kind, g.Path, g.Moves)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WritePlan writes to w the paths of the games to remove, keeping one
+// game of each group, after a comment line naming the game kept.
+// If near is false, the near duplicates, which may be different games,
+// are not removed.
+func (rep *DedupeReport) WritePlan(w io.Writer, near bool) error {
+	for _, grp := range rep.Groups {
+		if _, err := fmt.Fprintf(w, "# keep %s\n", grp[0].Path); err != nil {
+			return err
+		}
+		for _, g := range grp[1:] {
+			if g.Kind == Near && !near {
+				continue
+			}
+			if _, err := fmt.Fprintln(w, g.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
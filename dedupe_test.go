@@ -0,0 +1,69 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"strings"
+	"testing"
+)
+
+// dedupeSGF returns a game of n moves, at points step apart, the move at
+// change replaced, and rotated by rotate.
+func dedupeSGF(root string, step int, n int, change int, rotate bool) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "(;GM[1]SZ[19]%s", root)
+	for i := 0; i < n; i++ {
+		p := (step*i + 5) % 361
+		if i == change {
+			p = 360
+		}
+		col, row := p%19, p/19
+		if rotate {
+			col, row = 18-row, col
+		}
+		fmt.Fprintf(&b, ";%c[%c%c]", "BW"[i%2], 'a'+col, 'a'+row)
+	}
+	b.WriteString(")")
+	return []byte(b.String())
+}
+
+func TestFindDuplicates(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": string(dedupeSGF("PB[Honinbo Shusaku]PW[Ota Yuzo]DT[1853]", 37, 30, -1, false)),
+		"b/1.sgf": string(dedupeSGF("PB[Shusaku]", 37, 30, -1, true)),
+		"b/2.sgf": string(dedupeSGF("", 37, 25, -1, false)),
+		"c/3.sgf": string(dedupeSGF("", 37, 30, 20, false)),
+		"c/4.sgf": string(dedupeSGF("", 41, 30, -1, false)),
+		"c/5.sgf": "(;GM[1]SZ[19];B[pd];W[dd])",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	rep, err := FindDuplicates(context.Background(), dbReq, DedupeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Games != 5 {
+		t.Errorf("games: %d", rep.Games)
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	const want = "keep\ta/1.sgf\t30\nexact\tb/1.sgf\t30\ntruncated\tb/2.sgf\t25\nnear\tc/3.sgf\t30\t1\n"
+	if buf.String() != want {
+		t.Errorf("report:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+	buf.Reset()
+	if err := rep.WritePlan(&buf, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "# keep a/1.sgf\nb/1.sgf\nb/2.sgf\n" {
+		t.Errorf("plan:\n%s", got)
+	}
+
+	rep, _ = FindDuplicates(context.Background(), dbReq, DedupeOptions{MaxDiff: -1})
+	if len(rep.Groups) != 1 || len(rep.Groups[0]) != 3 {
+		t.Errorf("without near duplicates: %v", rep.Groups)
+	}
+}
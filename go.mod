@@ -0,0 +1,8 @@
+module github.com/Ken1JF/sgfdb
+
+go 1.21
+
+require golang.org/x/text v0.14.0
+
+// github.com/Ken1JF/ah and github.com/Ken1JF/sgf have no tagged versions:
+// go get github.com/Ken1JF/ah@latest github.com/Ken1JF/sgf@latest
@@ -0,0 +1,263 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/index.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// GameRecord holds the root properties and other facts about one .sgf file,
+// so that games can be selected without parsing the files again.
+type GameRecord struct {
+	Path    string            // path of the file, relative to the Index directory
+	Size    int64             // size of the file
+	ModTime time.Time         // modification time of the file, if known
+	Hash    [sha256.Size]byte // SHA-256 of the file contents
+
+	// root properties
+	PB, PW, BR, WR, DT, EV, RO, PC, RE, KM, HA, SZ, RU string
+
+	Moves int    // number of B and W moves in the main line
+	Err   string // error reading the file as SGF, if any
+}
+
+// IndexedProperties are the root properties kept in a GameRecord.
+var IndexedProperties = []string{"PB", "PW", "BR", "WR", "DT", "EV", "RO", "PC", "RE", "KM", "HA", "SZ", "RU"}
+
+// Property returns the value of the root property id, or "" if it is not kept.
+func (rec *GameRecord) Property(id string) string {
+	switch id {
+	case "PB":
+		return rec.PB
+	case "PW":
+		return rec.PW
+	case "BR":
+		return rec.BR
+	case "WR":
+		return rec.WR
+	case "DT":
+		return rec.DT
+	case "EV":
+		return rec.EV
+	case "RO":
+		return rec.RO
+	case "PC":
+		return rec.PC
+	case "RE":
+		return rec.RE
+	case "KM":
+		return rec.KM
+	case "HA":
+		return rec.HA
+	case "SZ":
+		return rec.SZ
+	case "RU":
+		return rec.RU
+	}
+	return ""
+}
+
+// setProperty sets the root property id, if it is kept.
+func (rec *GameRecord) setProperty(id string, v string) {
+	switch id {
+	case "PB":
+		rec.PB = v
+	case "PW":
+		rec.PW = v
+	case "BR":
+		rec.BR = v
+	case "WR":
+		rec.WR = v
+	case "DT":
+		rec.DT = v
+	case "EV":
+		rec.EV = v
+	case "RO":
+		rec.RO = v
+	case "PC":
+		rec.PC = v
+	case "RE":
+		rec.RE = v
+	case "KM":
+		rec.KM = v
+	case "HA":
+		rec.HA = v
+	case "SZ":
+		rec.SZ = v
+	case "RU":
+		rec.RU = v
+	}
+}
+
+// readGame sets the root properties and move count of rec from b.
+// If the file has a syntax error, the properties read before the error are kept.
+func (rec *GameRecord) readGame(b []byte) error {
+	trees, err := readSGF(b)
+	if len(trees) > 0 {
+		rec.readTree(trees[0])
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	return err
+}
+
+// readTree sets the properties and number of moves of rec from the game tree t.
+func (rec *GameRecord) readTree(t *sgfTree) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	for _, id := range IndexedProperties {
+		rec.setProperty(id, t.nodes[0].value(id))
+	}
+	for _, n := range t.mainLine() {
+		if n.prop("B") != nil || n.prop("W") != nil {
+			rec.Moves++
+		}
+	}
+}
+
+// GameIndex holds a GameRecord for each non-empty .sgf file of a database,
+// sorted by Path.
+type GameIndex struct {
+	Records []GameRecord
+	byPath  map[string]int
+}
+
+// gameIndexVersion is changed when the GameRecord changes.
+const gameIndexVersion = 1
+
+// gameIndexFile is the form of a GameIndex written to disk, gob encoded and gzip compressed.
+type gameIndexFile struct {
+	Version int
+	Records []GameRecord
+}
+
+// Lookup returns the record of the file path, or nil.
+func (ix *GameIndex) Lookup(path string) *GameRecord {
+	if ix.byPath == nil {
+		ix.byPath = make(map[string]int, len(ix.Records))
+		for i := range ix.Records {
+			ix.byPath[ix.Records[i].Path] = i
+		}
+	}
+	if i, ok := ix.byPath[path]; ok {
+		return &ix.Records[i]
+	}
+	return nil
+}
+
+// BuildGameIndex reads every .sgf file of the database of dbrq,
+// and returns a GameIndex of the games.
+func BuildGameIndex(ctx context.Context, dbrq *DBProcessRequest) (*GameIndex, error) {
+	return RefreshGameIndex(ctx, dbrq, nil)
+}
+
+// RefreshGameIndex is BuildGameIndex, reusing the records of old:
+// a file with the same size and modification time as its record in old,
+// or the same hash, is not parsed again.
+// Records of files no longer in the database are dropped.
+func RefreshGameIndex(ctx context.Context, dbrq *DBProcessRequest, old *GameIndex) (*GameIndex, error) {
+	defer un(trace("RefreshGameIndex"), nil)
+	if old != nil {
+		old.Lookup("") // build the map before the parallel lookups
+	}
+	recs, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[GameRecord, []GameRecord, []GameRecord]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) GameRecord {
+			// the size of the file, not of b, which may have been transcoded
+			rec := GameRecord{Path: r.FilePath(fName)}
+			if fi, err := r.FileInfo(fName); err == nil {
+				rec.Size = fi.Size()
+				rec.ModTime = fi.ModTime()
+			}
+			var o *GameRecord
+			if old != nil {
+				o = old.Lookup(rec.Path)
+			}
+			if o != nil && !rec.ModTime.IsZero() && o.Size == rec.Size && o.ModTime.Equal(rec.ModTime) {
+				return *o
+			}
+			rec.Hash = sha256.Sum256(b)
+			if o != nil && o.Hash == rec.Hash {
+				same := *o
+				same.ModTime = rec.ModTime
+				return same
+			}
+			if err := rec.readGame(b); err != nil {
+				r.FileError(fName, "Indexing", err)
+			}
+			return rec
+		},
+		Dir: func(d []GameRecord, rec GameRecord) []GameRecord {
+			return append(d, rec)
+		},
+		DB: func(res []GameRecord, d []GameRecord) []GameRecord {
+			return append(res, d...)
+		},
+	})
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Path < recs[j].Path })
+	return &GameIndex{Records: recs}, err
+}
+
+// Write writes the index to w.
+func (ix *GameIndex) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	err := gob.NewEncoder(zw).Encode(gameIndexFile{Version: gameIndexVersion, Records: ix.Records})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadGameIndex reads an index written by GameIndex.Write.
+func ReadGameIndex(r io.Reader) (*GameIndex, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var f gameIndexFile
+	err = gob.NewDecoder(zr).Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+	if f.Version != gameIndexVersion {
+		return nil, fmt.Errorf("game index version %d, expected %d", f.Version, gameIndexVersion)
+	}
+	return &GameIndex{Records: f.Records}, nil
+}
+
+// Save writes the index to the file fileName.
+func (ix *GameIndex) Save(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = ix.Write(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// LoadGameIndex reads the index saved in the file fileName.
+func LoadGameIndex(fileName string) (*GameIndex, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadGameIndex(f)
+}
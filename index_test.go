@@ -0,0 +1,75 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestGameIndex(t *testing.T) {
+	dbReq := memDB(map[string]string{
+		"2005/a.sgf": "(;GM[1]SZ[19]PB[Go Seigen]BR[9d]PW[Kitani Minoru]DT[1933-10-16]RE[W+2]KM[0];B[qd];W[dc](;B[pq])(;B[dp];W[pp]))",
+		"2005/b.sgf": "(;GM[1]PB[Honinbo Shusaku]PW[Gennan Inseki]DT[1846-09-11]RE[B+2];B[qd];W[dc",
+	}, DBProcessRequest{})
+	ix, err := BuildGameIndex(context.Background(), dbReq)
+	if err == nil {
+		t.Errorf("expected an error for 2005/b.sgf")
+	}
+	if len(ix.Records) != 2 {
+		t.Fatalf("%d records", len(ix.Records))
+	}
+	a := ix.Lookup("2005/a.sgf")
+	if a == nil || a.PB != "Go Seigen" || a.BR != "9d" || a.RE != "W+2" || a.Moves != 3 || a.Err != "" {
+		t.Errorf("record a: %+v", a)
+	}
+	b := ix.Lookup("2005/b.sgf")
+	if b == nil || b.PB != "Honinbo Shusaku" || b.Moves != 1 || b.Err == "" {
+		t.Errorf("record b: %+v", b)
+	}
+
+	var buf bytes.Buffer
+	if err := ix.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	old, err := ReadGameIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old.Records) != 2 || old.Records[0] != ix.Records[0] {
+		t.Errorf("records read back: %+v", old.Records)
+	}
+
+	// unchanged files are not parsed again, changed files are
+	old.Lookup("2005/a.sgf").PB = "not parsed"
+	old.Lookup("2005/b.sgf").PB = "not parsed"
+	dbReq.DBFS.(fstest.MapFS)["2005/b.sgf"] = &fstest.MapFile{Data: []byte("(;GM[1]PB[Honinbo Shusaku]PW[Gennan Inseki];B[qd])"), ModTime: time.Now()}
+	ix, err = RefreshGameIndex(context.Background(), dbReq, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Lookup("2005/a.sgf").PB != "not parsed" || ix.Lookup("2005/b.sgf").PB != "Honinbo Shusaku" {
+		t.Errorf("refreshed records: %+v", ix.Records)
+	}
+
+	// the size of a transcoded file is the size of the file, so the file is not parsed again
+	latin1 := "(;GM[1]CA[ISO-8859-1]PB[Jos\xe9]PW[Ana];B[qd])"
+	dbReq = memDB(map[string]string{"2006/c.sgf": latin1}, DBProcessRequest{Transcode: true})
+	dbReq.DBFS.(fstest.MapFS)["2006/c.sgf"].ModTime = time.Date(2006, 1, 2, 3, 4, 5, 0, time.UTC)
+	ix, err = BuildGameIndex(context.Background(), dbReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ix.Lookup("2006/c.sgf")
+	if c == nil || c.Size != int64(len(latin1)) || c.PB != "Jos\u00e9" {
+		t.Fatalf("record c: %+v", c)
+	}
+	c.PB = "not parsed"
+	c.Hash = [32]byte{}
+	ix, err = RefreshGameIndex(context.Background(), dbReq, ix)
+	if err != nil || ix.Lookup("2006/c.sgf").PB != "not parsed" {
+		t.Errorf("refreshed record c: %+v, %v", ix.Lookup("2006/c.sgf"), err)
+	}
+}
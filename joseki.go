@@ -0,0 +1,365 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/joseki.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Ken1JF/ah"
+)
+
+// JosekiStats are the statistics of the corner sequences reaching a node of a JosekiTree.
+type JosekiStats struct {
+	Count     int // number of corner sequences
+	FirstYear int // 0 if no game has a year
+	LastYear  int
+	Eras      map[string]int // Count by era, the directory of the game
+}
+
+// add adds one sequence of the year year and the era era.
+func (st *JosekiStats) add(year int, era string) {
+	st.Count++
+	if year != 0 && (st.FirstYear == 0 || year < st.FirstYear) {
+		st.FirstYear = year
+	}
+	if year > st.LastYear {
+		st.LastYear = year
+	}
+	if st.Eras == nil {
+		st.Eras = make(map[string]int)
+	}
+	st.Eras[era]++
+}
+
+// JosekiNode is a move of a JosekiTree, with the statistics of the corner sequences playing it.
+type JosekiNode struct {
+	Move  string // the SGF point of the move, "" for the root
+	Color Color
+	JosekiStats
+	Children []*JosekiNode // most played first
+}
+
+// JosekiTree holds the corner sequences of the games of a database.
+//
+// The sequence of a corner is made of the moves played in the Region by
+// Region points of the corner, from the first one, and ends when a player
+// does not answer a move in the corner, once both players have stones
+// there, i.e. after a move elsewhere, or a pass.
+// The sequences are transformed to the upper left corner, and by the
+// diagonal symmetry giving the smaller sequence of points.
+// The colors are reversed if White plays first, so every sequence starts
+// with a Black move.
+//
+// The era of a game is the directory of its file, relative to the
+// database directory, i.e. the eras of the GoGoD collection.
+type JosekiTree struct {
+	Size   int
+	Region int
+	Eras   []string // sorted
+	Root   JosekiNode
+}
+
+// JosekiOptions select the games and moves of a JosekiTree.
+type JosekiOptions struct {
+	Moves    int    // longest sequence, 30 if 0
+	Size     int    // board size of the games, 19 if 0
+	Region   int    // size of the corners, Size/2 if 0
+	Filter   *Query // the games; all if nil
+	MinCount int    // moves played in fewer sequences are dropped
+}
+
+// josekiGame holds the corner sequences of a game.
+type josekiGame struct {
+	points [][]int
+	colors [][]Color
+	year   int
+	era    string
+}
+
+// inCorner reports whether col, row is in the corner k, 0 to 3 for the
+// upper left, upper right, lower left, and lower right corners, as the
+// ah.BoardTrans transforming the corner to the upper left corner.
+func inCorner(k int, col int, row int, size int, region int) bool {
+	if k&1 == 0 && col >= region || k&1 != 0 && col < size-region {
+		return false
+	}
+	return !(k&2 == 0 && row >= region || k&2 != 0 && row < size-region)
+}
+
+// cornerSequences returns the sequences of moves of the four corners of
+// the moves of a game, ignoring the empty ones. Passes are size*size.
+func cornerSequences(points []int, colors []Color, size int, region int, limit int) (seqs [][]int, cols [][]Color) {
+	for k := 0; k < 4; k++ {
+		var seq []int
+		var col []Color
+		var black, white, prevIn bool
+		for i, p := range points {
+			in := p < size*size && inCorner(k, p%size, p/size, size, region)
+			if in {
+				if len(seq) == limit {
+					break
+				}
+				seq, col = append(seq, p), append(col, colors[i])
+				black = black || colors[i] == Black
+				white = white || colors[i] == White
+			} else if prevIn && black && white {
+				break // tenuki
+			}
+			prevIn = in
+		}
+		if len(seq) > 0 {
+			seqs, cols = append(seqs, seq), append(cols, col)
+		}
+	}
+	return seqs, cols
+}
+
+// normalizeCorner transforms the points of the sequence of a corner to
+// the upper left corner, by the symmetry and the diagonal symmetry giving
+// the smallest sequence, and reverses the colors if White plays first.
+func normalizeCorner(points []int, colors []Color, size int, region int) ([]int, []Color) {
+	col, row := points[0]%size, points[0]/size
+	s := ah.BoardTrans(0)
+	if col >= region {
+		s |= 1
+	}
+	if row >= region {
+		s |= 2
+	}
+	a := make([]int, len(points))
+	b := make([]int, len(points))
+	for i, p := range points {
+		c, r := TransPoint(s, p%size, p/size, size)
+		a[i] = r*size + c
+		b[i] = c*size + r // Transpose
+	}
+	if lessPoints(b, a) {
+		a = b
+	}
+	cols := colors
+	if colors[0] == White {
+		cols = make([]Color, len(colors))
+		for i, c := range colors {
+			cols[i] = c.Opponent()
+		}
+	}
+	return a, cols
+}
+
+// BuildJosekiTree returns the JosekiTree of the games of the database of dbrq
+// selected by opts. Games with setup stones, i.e. handicap games, are not included.
+func BuildJosekiTree(ctx context.Context, dbrq *DBProcessRequest, opts JosekiOptions) (*JosekiTree, error) {
+	defer un(trace("BuildJosekiTree"), nil)
+	if opts.Moves <= 0 {
+		opts.Moves = 30
+	}
+	if opts.Size <= 0 {
+		opts.Size = 19
+	}
+	if opts.Region <= 0 || opts.Region > opts.Size/2 {
+		opts.Region = opts.Size / 2
+	}
+	games, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[*josekiGame, []*josekiGame, []*josekiGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) *josekiGame {
+			trees, err := readSGF(b)
+			if len(trees) == 0 {
+				r.FileError(fName, "Reading joseki", err)
+				return nil
+			}
+			var rec GameRecord
+			rec.readTree(trees[0])
+			if opts.Filter != nil && !opts.Filter.Match(&rec) {
+				return nil
+			}
+			g, err2 := compileGame(trees[0])
+			if err == nil && err2 != errNotSquare {
+				err = err2
+			}
+			if err != nil {
+				r.FileError(fName, "Reading joseki", err)
+			}
+			if g.Size != opts.Size {
+				return nil
+			}
+			points, colors, _ := openingMoves(&g, len(g.Ops))
+			jg := &josekiGame{year: gameYear(rec.DT), era: r.RelDir()}
+			jg.points, jg.colors = cornerSequences(points, colors, g.Size, opts.Region, opts.Moves)
+			if len(jg.points) == 0 {
+				return nil
+			}
+			return jg
+		},
+		Dir: func(d []*josekiGame, g *josekiGame) []*josekiGame {
+			if g == nil {
+				return d
+			}
+			return append(d, g)
+		},
+		DB: func(res []*josekiGame, d []*josekiGame) []*josekiGame {
+			return append(res, d...)
+		},
+	})
+	jt := &JosekiTree{Size: opts.Size, Region: opts.Region}
+	for _, g := range games {
+		for i := range g.points {
+			points, colors := normalizeCorner(g.points[i], g.colors[i], jt.Size, jt.Region)
+			jt.add(points, colors, g.year, g.era)
+		}
+	}
+	for era := range jt.Root.Eras {
+		jt.Eras = append(jt.Eras, era)
+	}
+	sort.Strings(jt.Eras)
+	jt.Root.finish(opts.MinCount)
+	return jt, err
+}
+
+// add adds a normalized corner sequence to the tree.
+func (jt *JosekiTree) add(points []int, colors []Color, year int, era string) {
+	n := &jt.Root
+	n.add(year, era)
+	for i, p := range points {
+		child := n.child(sgfPointValue(p%jt.Size, p/jt.Size), colors[i])
+		if child == nil {
+			child = &JosekiNode{Move: sgfPointValue(p%jt.Size, p/jt.Size), Color: colors[i]}
+			n.Children = append(n.Children, child)
+		}
+		child.add(year, era)
+		n = child
+	}
+}
+
+// child returns the child of n playing mv with color c, or nil.
+func (n *JosekiNode) child(mv string, c Color) *JosekiNode {
+	for _, ch := range n.Children {
+		if ch.Move == mv && ch.Color == c {
+			return ch
+		}
+	}
+	return nil
+}
+
+// finish drops the children played in fewer than minCount sequences,
+// and sorts the others, most played first.
+func (n *JosekiNode) finish(minCount int) {
+	kept := n.Children[:0]
+	for _, c := range n.Children {
+		if c.Count >= minCount {
+			c.finish(minCount)
+			kept = append(kept, c)
+		}
+	}
+	n.Children = kept
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Color != b.Color {
+			return a.Color < b.Color
+		}
+		return a.Move < b.Move
+	})
+}
+
+// Share returns the part of the corner sequences of the era era which reach n.
+func (jt *JosekiTree) Share(n *JosekiNode, era string) float64 {
+	return percent(n.Eras[era], jt.Root.Eras[era]) / 100
+}
+
+// Find returns the node of the tree reached by the moves of the SGF
+// fragment, i.e. ";B[pd];W[qf];B[nc]", played in any corner.
+// Find returns ErrNotInTree if the sequence is not in the tree.
+func (jt *JosekiTree) Find(fragment []byte) (*JosekiNode, error) {
+	if bytes.IndexByte(fragment, '(') < 0 {
+		fragment = append(append([]byte("("), fragment...), ')')
+	}
+	trees, err := readSGF(fragment)
+	if err != nil {
+		return nil, err
+	}
+	g, err := compileGame(trees[0])
+	if err != nil {
+		return nil, err
+	}
+	if g.Size != jt.Size {
+		return nil, fmt.Errorf("board size %d, expected %d", g.Size, jt.Size)
+	}
+	points, colors, setup := openingMoves(&g, len(g.Ops))
+	if setup {
+		return nil, errors.New("joseki with setup stones")
+	}
+	if len(points) == 0 {
+		return &jt.Root, nil
+	}
+	seqs, cols := cornerSequences(points, colors, jt.Size, jt.Region, len(points))
+	if len(seqs) != 1 || len(seqs[0]) != len(points) {
+		return nil, errors.New("moves outside of a corner")
+	}
+	points, colors = normalizeCorner(seqs[0], cols[0], jt.Size, jt.Region)
+	n := &jt.Root
+	for i, p := range points {
+		if n = n.child(sgfPointValue(p%jt.Size, p/jt.Size), colors[i]); n == nil {
+			return nil, ErrNotInTree
+		}
+	}
+	return n, nil
+}
+
+// String returns the statistics, as written in the comments of the SGF tree.
+func (st *JosekiStats) String() string {
+	s := fmt.Sprintf("Count: %d", st.Count)
+	if st.FirstYear != 0 {
+		s += fmt.Sprintf("\nYears: %d-%d", st.FirstYear, st.LastYear)
+	}
+	return s
+}
+
+// WriteSGF writes the tree to w as an SGF GameTree, with the statistics
+// of each move, and its share of the sequences of each era, in its comment.
+func (jt *JosekiTree) WriteSGF(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(;GM[1]FF[4]CA[UTF-8]SZ[%d]GN[Joseki tree, %dx%d corners]C[%s]", jt.Size, jt.Region, jt.Region, sgfEscape(jt.comment(&jt.Root)))
+	jt.writeChildren(&buf, &jt.Root)
+	buf.WriteString(")\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// comment returns the comment of n in the SGF tree.
+func (jt *JosekiTree) comment(n *JosekiNode) string {
+	s := n.JosekiStats.String()
+	for _, era := range jt.Eras {
+		if c := n.Eras[era]; c != 0 {
+			s += fmt.Sprintf("\n%s: %d (%.1f%%)", era, c, 100*jt.Share(n, era))
+		}
+	}
+	return s
+}
+
+func (jt *JosekiTree) writeChildren(buf *bytes.Buffer, n *JosekiNode) {
+	for len(n.Children) == 1 {
+		n = n.Children[0]
+		jt.writeNode(buf, n)
+	}
+	for _, c := range n.Children {
+		buf.WriteString("\n(")
+		jt.writeNode(buf, c)
+		jt.writeChildren(buf, c)
+		buf.WriteString(")")
+	}
+}
+
+func (jt *JosekiTree) writeNode(buf *bytes.Buffer, n *JosekiNode) {
+	fmt.Fprintf(buf, ";%s[%s]C[%s]", n.Color, n.Move, sgfEscape(jt.comment(n)))
+}
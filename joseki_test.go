@@ -0,0 +1,85 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	. "github.com/Ken1JF/sgfdb"
+	"strings"
+	"testing"
+)
+
+func TestJosekiTree(t *testing.T) {
+	files := map[string]string{
+		// upper right, ended by the tenuki at pj
+		"1990s/1.sgf": "(;GM[1]SZ[19]DT[1990];B[pd];W[qf];B[nc];W[pj];B[qh])",
+		// 1.sgf in the lower left, White first, and a stone in the lower right
+		"2000s/2.sgf": "(;GM[1]SZ[19]DT[2005];W[dp];B[cn];W[fq];B[qq])",
+		"2000s/3.sgf": "(;GM[1]SZ[19]DT[2003];B[pd];W[qc])",
+		// an enclosure, not ended by the move elsewhere
+		"2000s/4.sgf": "(;GM[1]SZ[19]DT[2001];B[pd];W[dd];B[qf])",
+		"2000s/5.sgf": "(;GM[1]SZ[19]HA[2]AB[pd][dp];W[pp])",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	jt, err := BuildJosekiTree(context.Background(), dbReq, JosekiOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jt.Root.Count != 6 || jt.Root.Eras["1990s"] != 1 || jt.Root.Eras["2000s"] != 5 {
+		t.Errorf("root: %+v", jt.Root.JosekiStats)
+	}
+	if strings.Join(jt.Eras, " ") != "1990s 2000s" {
+		t.Errorf("eras: %v", jt.Eras)
+	}
+	var got []string
+	for _, c := range jt.Root.Children {
+		got = append(got, c.Color.String()+c.Move)
+	}
+	if strings.Join(got, " ") != "Bdd Bcc" {
+		t.Errorf("first moves: %v", got)
+	}
+	got = nil
+	for _, c := range jt.Root.Children[0].Children {
+		got = append(got, c.Color.String()+c.Move)
+	}
+	if strings.Join(got, " ") != "Wfc Bfc Wcc" {
+		t.Errorf("second moves: %v", got)
+	}
+
+	n, err := jt.Find([]byte(";B[pd];W[qf];B[nc]"))
+	if err != nil || n == nil {
+		t.Fatalf("Find: %v %v", n, err)
+	}
+	if n.Move != "cf" || n.Count != 2 || n.FirstYear != 1990 || n.LastYear != 2005 {
+		t.Errorf("Find: %+v", n)
+	}
+	if s := jt.Share(n, "1990s"); s != 1 {
+		t.Errorf("share 1990s: %v", s)
+	}
+	if s := jt.Share(n, "2000s"); s != 0.2 {
+		t.Errorf("share 2000s: %v", s)
+	}
+	if n, _ := jt.Find([]byte(";W[dp];B[cn]")); n == nil || n.Count != 2 {
+		t.Errorf("Find White first: %+v", n)
+	}
+	if n, err := jt.Find([]byte(";B[pd];W[pc]")); n != nil || err != ErrNotInTree {
+		t.Errorf("Find missing: %+v %v, expected ErrNotInTree", n, err)
+	}
+	for _, f := range []string{";B[pd];W[dd]", ";B[jj]"} {
+		if _, err := jt.Find([]byte(f)); err == nil {
+			t.Errorf("%s: expected an error", f)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jt.WriteSGF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "(;GM[1]FF[4]CA[UTF-8]SZ[19]GN[Joseki tree, 9x9 corners]C[Count: 6\nYears: 1990-2005\n1990s: 1 (100.0%)\n2000s: 5 (100.0%)]\n(;B[dd]") {
+		t.Errorf("SGF:\n%s", buf.String())
+	}
+
+	jt, _ = BuildJosekiTree(context.Background(), dbReq, JosekiOptions{MinCount: 2})
+	if len(jt.Root.Children) != 1 || len(jt.Root.Children[0].Children) != 1 {
+		t.Errorf("sequences played twice: %+v", jt.Root.Children)
+	}
+}
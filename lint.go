@@ -0,0 +1,495 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/lint.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Ken1JF/ah"
+	"github.com/Ken1JF/sgf"
+)
+
+// Severity is the severity of a LintRule.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // the game cannot be replayed as recorded
+	SeverityWarning                 // the game is replayed, but a property is wrong or missing
+	SeverityInfo                    // the file does not follow the GoGoD conventions
+)
+
+var severityNames = [...]string{"error", "warning", "info"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return "Severity(" + strconv.Itoa(int(s)) + ")"
+	}
+	return severityNames[s]
+}
+
+// MarshalText writes the severity by name in JSON.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// LintRule is a check of Lint.
+type LintRule struct {
+	ID       string
+	Severity Severity
+	Doc      string
+}
+
+// LintRules are the checks of Lint, by ID.
+var LintRules = []LintRule{
+	{"sgf", SeverityError, "errors reported by sgf.ParseFile, with ParserPlay, not of the rules below"},
+	{"syntax", SeverityError, "SGF syntax errors; the file is checked up to the error"},
+	{"bad-point", SeverityError, "values of B, W, AB, AW, and AE which are not points, reported by sgf.ParseFile"},
+	{"sz-mismatch", SeverityError, "SZ malformed, or not in the root node, or points outside of the board"},
+	{"occupied", SeverityError, "moves on an occupied point, reported by sgf.ParseFile"},
+	{"suicide", SeverityError, "moves leaving their own stones without liberties, reported by sgf.ParseFile"},
+	{"ko", SeverityError, "moves retaking a ko at once, reported by sgf.ParseFile"},
+	{"root-missing", SeverityWarning, "root properties of LintOptions.Required missing"},
+	{"dt-format", SeverityWarning, "DT not a list of YYYY-MM-DD dates"},
+	{"re-format", SeverityWarning, "RE not 0, Draw, Void, ?, or a winner and a score, R, T, or F"},
+	{"re-mismatch", SeverityWarning, "RE a resignation, but the game ends with two passes, or the last move is by the player who resigned"},
+	{"gogod-header", SeverityInfo, "GM not 1, or FF not 4"},
+	{"gogod-km", SeverityInfo, "KM not a number"},
+	{"gogod-ha", SeverityInfo, "HA not the number of AB stones of the root node"},
+	{"gogod-root-move", SeverityInfo, "moves in the root node"},
+	{"gogod-collection", SeverityInfo, "more than one game in the file"},
+	{"old-ident", SeverityInfo, "property identifiers with lower case letters, of old versions of SGF, i.e. AddBlack for AB"},
+}
+
+// DefaultRequired are the root properties required by the root-missing rule.
+var DefaultRequired = []string{"GM", "FF", "SZ", "PB", "PW", "DT", "RE"}
+
+// LintOptions select the rules of Lint.
+type LintOptions struct {
+	Enable   []string // IDs of the rules checked; all if empty
+	Disable  []string // IDs of the rules not checked
+	Required []string // the root properties of the root-missing rule, DefaultRequired if nil
+}
+
+// LintFinding is a problem found by Lint, written as a JSON object.
+type LintFinding struct {
+	File      string   `json:"file"`
+	Game      int      `json:"game"`                // index of the game in the file
+	Node      int      `json:"node"`                // index of the node from the root, -1 for the whole file
+	Variation string   `json:"variation,omitempty"` // the variations taken from the main line, i.e. "0.1", "" for the main line
+	Move      int      `json:"move,omitempty"`      // number of the move of the node
+	Rule      string   `json:"rule"`
+	Severity  Severity `json:"severity"`
+	Msg       string   `json:"msg"`
+}
+
+// lintRules returns the rules enabled by opts, by ID.
+func (opts *LintOptions) lintRules() (map[string]Severity, error) {
+	known := make(map[string]Severity, len(LintRules))
+	for _, r := range LintRules {
+		known[r.ID] = r.Severity
+	}
+	rules := known
+	if len(opts.Enable) > 0 {
+		rules = make(map[string]Severity)
+		for _, id := range opts.Enable {
+			s, ok := known[id]
+			if !ok {
+				return nil, fmt.Errorf("unknown lint rule %q", id)
+			}
+			rules[id] = s
+		}
+	}
+	for _, id := range opts.Disable {
+		if _, ok := known[id]; !ok {
+			return nil, fmt.Errorf("unknown lint rule %q", id)
+		}
+		delete(rules, id)
+	}
+	return rules, nil
+}
+
+// linter checks a file.
+type linter struct {
+	file     string
+	rules    map[string]Severity
+	required []string
+	findings []LintFinding
+	game     int
+}
+
+// report adds a finding of the rule id, if it is enabled.
+func (l *linter) report(id string, node int, variation []int, move int, format string, args ...any) {
+	s, ok := l.rules[id]
+	if !ok {
+		return
+	}
+	f := LintFinding{File: l.file, Game: l.game, Node: node, Move: move, Rule: id, Severity: s, Msg: fmt.Sprintf(format, args...)}
+	if len(variation) > 0 {
+		vs := make([]string, len(variation))
+		for i, v := range variation {
+			vs[i] = strconv.Itoa(v)
+		}
+		f.Variation = strings.Join(vs, ".")
+	}
+	l.findings = append(l.findings, f)
+}
+
+// Lint checks the SGF file b, named path, with the rules of opts.
+func Lint(path string, b []byte, opts LintOptions) ([]LintFinding, error) {
+	rules, err := opts.lintRules()
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{file: path, rules: rules, required: opts.Required}
+	if l.required == nil {
+		l.required = DefaultRequired
+	}
+	l.lint(b)
+	return l.findings, nil
+}
+
+// LintDatabase checks the .sgf files of the database of dbrq with the
+// rules of opts, and returns the findings, sorted by file.
+// If a rule reported by sgf.ParseFile is enabled, the files are checked
+// one at a time, since what sgf.ParseFile prints is captured from os.Stdout:
+// dbrq.DoMultiCPU is cleared.
+func LintDatabase(ctx context.Context, dbrq *DBProcessRequest, opts LintOptions) ([]LintFinding, error) {
+	defer un(trace("LintDatabase"), nil)
+	rules, err := opts.lintRules()
+	if err != nil {
+		return nil, err
+	}
+	if sgfPass(rules) {
+		dbrq.DoMultiCPU = false
+	}
+	required := opts.Required
+	if required == nil {
+		required = DefaultRequired
+	}
+	findings, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[[]LintFinding, []LintFinding, []LintFinding]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) []LintFinding {
+			l := &linter{file: r.FilePath(fName), rules: rules, required: required}
+			l.lint(b)
+			return l.findings
+		},
+		Dir: func(d []LintFinding, f []LintFinding) []LintFinding {
+			return append(d, f...)
+		},
+		DB: func(res []LintFinding, d []LintFinding) []LintFinding {
+			return append(res, d...)
+		},
+	})
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].File < findings[j].File })
+	return findings, err
+}
+
+// WriteLintFindings writes the findings to w as JSON lines, one finding a line.
+func WriteLintFindings(w io.Writer, findings []LintFinding) error {
+	enc := json.NewEncoder(w)
+	for i := range findings {
+		if err := enc.Encode(&findings[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sgfErrorRules are the rules of the errors of sgf.ParseFile, by words of
+// their message, i.e. "repeat position" for a ko, and "BAD Property Value"
+// for a value which is not a point, the first words found first.
+// The other errors are of the sgf rule.
+var sgfErrorRules = []struct{ words, id string }{
+	{"occupied", "occupied"},
+	{"suicide", "suicide"},
+	{"repeat position", "ko"},
+	{"ko", "ko"},
+	{"bad property value", "bad-point"},
+	{"point", "bad-point"},
+	{"coordinate", "bad-point"},
+}
+
+// sgfErrorRule returns the rule of the error of sgf.ParseFile with message msg.
+func sgfErrorRule(msg string) string {
+	words := strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool { return !unicode.IsLetter(r) })
+	text := " " + strings.Join(words, " ") + " "
+	for _, r := range sgfErrorRules {
+		if strings.Contains(text, " "+r.words+" ") {
+			return r.id
+		}
+	}
+	return "sgf"
+}
+
+// sgfPass reports whether a rule of the errors of sgf.ParseFile is enabled.
+func sgfPass(rules map[string]Severity) bool {
+	if _, ok := rules["sgf"]; ok {
+		return true
+	}
+	for _, r := range sgfErrorRules {
+		if _, ok := rules[r.id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSGF parses b, with ParserPlay, and returns the errors of sgf.ParseFile,
+// and what it printed, which is not written to os.Stdout.
+func (l *linter) parseSGF(b []byte, moveLimit int) (errL ah.ErrorList, printed string) {
+	printed = captureStdout(func() {
+		_, errL = parseSGF(l.file, b, sgf.ParserPlay, moveLimit)
+	})
+	return errL, printed
+}
+
+// lintSGF reports the errors of sgf.ParseFile, with ParserPlay, of the file b,
+// whose first game is t. sgf.ParseFile stops at the first error, and does not
+// tell its node: an error of the root node alone is of node 0, else the move
+// of the error is the smallest moveLimit giving an error, found by bisection
+// over the moves of the main line of t. An error found with no moveLimit only,
+// i.e. in a variation, is of the whole file. An error whose message is of no
+// rule has the rule of the message sgf.ParseFile printed, if there is one.
+func (l *linter) lintSGF(b []byte, t *sgfTree) {
+	fails := func(moveLimit int) bool {
+		errL, _ := l.parseSGF(b, moveLimit)
+		return len(errL) != 0
+	}
+	errL, printed := l.parseSGF(b, 0)
+	if len(errL) == 0 {
+		return
+	}
+	node, move := -1, 0
+	if t != nil {
+		var root bytes.Buffer
+		writeSGF(&root, []*sgfTree{{nodes: t.nodes[:1]}})
+		if errL, _ := l.parseSGF(root.Bytes(), 0); len(errL) != 0 {
+			node = 0
+		}
+	}
+	if t != nil && node < 0 {
+		var moveNodes []int // the node of each move of the main line
+		for i, n := range t.mainLine() {
+			for _, id := range []string{"B", "W"} {
+				if n.prop(id) != nil {
+					moveNodes = append(moveNodes, i)
+				}
+			}
+		}
+		m := sort.Search(len(moveNodes), func(i int) bool { return fails(i + 1) })
+		if m < len(moveNodes) {
+			node, move = moveNodes[m], m+1
+		}
+	}
+	for _, err := range errL {
+		id := sgfErrorRule(err.Error())
+		if id == "sgf" {
+			id = sgfErrorRule(printed)
+		}
+		l.report(id, node, nil, move, "%s", err)
+	}
+}
+
+// lint checks the file b.
+func (l *linter) lint(b []byte) {
+	r := sgfReader{sgfScanner: sgfScanner{b: b}}
+	trees, err := r.collection()
+	for _, c := range r.changes {
+		l.report("old-ident", -1, nil, 0, "%s", c)
+	}
+	if sgfPass(l.rules) {
+		var t *sgfTree
+		if len(trees) > 0 && len(trees[0].nodes) > 0 {
+			t = trees[0]
+		}
+		l.lintSGF(b, t)
+	}
+	if err != nil {
+		l.report("syntax", -1, nil, 0, "%s", err)
+	}
+	if len(trees) > 1 {
+		l.report("gogod-collection", -1, nil, 0, "%d games in the file", len(trees))
+	}
+	for i, t := range trees {
+		l.game = i
+		if len(t.nodes) == 0 {
+			continue
+		}
+		l.lintRoot(t.nodes[0])
+		size, nRow := boardSize(t.nodes[0].value("SZ"))
+		if size == 0 {
+			l.report("sz-mismatch", 0, nil, 0, "bad board size SZ[%s]", t.nodes[0].value("SZ"))
+		}
+		l.walk(t, lintState{size: size, nRow: nRow}, nil, 0)
+		l.lintResult(t)
+	}
+}
+
+var (
+	reResult  = regexp.MustCompile(`^(0|Draw|Void|\?|[BW]\+([0-9]+(\.[0-9]+)?|R|Resign|T|Time|F|Forfeit)?)$`)
+	reDate    = regexp.MustCompile(`^[0-9]{4}(-[0-9]{2}(-[0-9]{2})?)?$`)
+	reDateCut = regexp.MustCompile(`^[0-9]{2}(-[0-9]{2})?$`)
+)
+
+// validDate reports whether dt is a list of dates, as DT of FF[4]:
+// YYYY-MM-DD, YYYY-MM, or YYYY, and after a date, the shortcuts MM-DD and DD.
+func validDate(dt string) bool {
+	for i, d := range strings.Split(dt, ",") {
+		d = strings.TrimSpace(d)
+		if !reDate.MatchString(d) && (i == 0 || !reDateCut.MatchString(d)) {
+			return false
+		}
+		parts := strings.Split(d, "-")
+		year := len(parts[0]) == 4
+		if year {
+			parts = parts[1:]
+		}
+		for j, p := range parts {
+			n, _ := strconv.Atoi(p)
+			month := j == 0 && (year || len(parts) == 2)
+			if n < 1 || month && n > 12 || n > 31 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lintRoot checks the properties of the root node n.
+func (l *linter) lintRoot(n *sgfNode) {
+	for _, id := range l.required {
+		if n.prop(id) == nil {
+			l.report("root-missing", 0, nil, 0, "%s missing", id)
+		}
+	}
+	if dt := n.value("DT"); n.prop("DT") != nil && !validDate(dt) {
+		l.report("dt-format", 0, nil, 0, "DT[%s]", dt)
+	}
+	if re := n.value("RE"); n.prop("RE") != nil && !reResult.MatchString(re) {
+		l.report("re-format", 0, nil, 0, "RE[%s]", re)
+	}
+	if gm := n.value("GM"); n.prop("GM") != nil && gm != "1" {
+		l.report("gogod-header", 0, nil, 0, "GM[%s]", gm)
+	}
+	if ff := n.value("FF"); n.prop("FF") != nil && ff != "4" {
+		l.report("gogod-header", 0, nil, 0, "FF[%s]", ff)
+	}
+	if km := n.value("KM"); n.prop("KM") != nil {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(km), 64); err != nil {
+			l.report("gogod-km", 0, nil, 0, "KM[%s]", km)
+		}
+	}
+	stones := 0
+	if p := n.prop("AB"); p != nil {
+		pts, _ := pointList(p.vals)
+		stones = len(pts)
+	}
+	if ha := n.value("HA"); n.prop("HA") != nil {
+		h, err := strconv.Atoi(strings.TrimSpace(ha))
+		if err != nil || h >= 2 && h != stones || h < 2 && stones > 0 {
+			l.report("gogod-ha", 0, nil, 0, "HA[%s] with %d AB stones", ha, stones)
+		}
+	} else if stones >= 2 && n.prop("AW") == nil {
+		l.report("gogod-ha", 0, nil, 0, "HA missing, with %d AB stones", stones)
+	}
+	if n.prop("B") != nil || n.prop("W") != nil {
+		l.report("gogod-root-move", 0, nil, 0, "move in the root node")
+	}
+}
+
+// lintResult checks RE against the end of the main line of t.
+func (l *linter) lintResult(t *sgfTree) {
+	re := t.nodes[0].value("RE")
+	if len(re) < 3 || re[1] != '+' || re[2] != 'R' {
+		return
+	}
+	nodes := t.mainLine()
+	size, _ := boardSize(t.nodes[0].value("SZ"))
+	var last []string // the colors of the last moves, and "" for passes
+	lastNode := 0
+	for i, n := range nodes {
+		for _, id := range []string{"B", "W"} {
+			if p := n.prop(id); p != nil {
+				c := id
+				if len(p.vals) > 0 && isPass(p.vals[0], size) {
+					c = ""
+				}
+				last = append(last, c)
+				lastNode = i
+			}
+		}
+	}
+	switch {
+	case len(last) >= 2 && last[len(last)-1] == "" && last[len(last)-2] == "":
+		l.report("re-mismatch", lastNode, nil, len(last), "RE[%s], but the game ends with two passes", re)
+	case len(last) > 0 && last[len(last)-1] != "" && last[len(last)-1][0] != re[0]:
+		l.report("re-mismatch", lastNode, nil, len(last), "RE[%s], but the last move is by %s", re, last[len(last)-1])
+	}
+}
+
+// lintState is the state of the walk of a variation.
+type lintState struct {
+	size, nRow int
+	move       int
+}
+
+// walk checks the tree t, from the node number node, and its variations.
+// The moves are replayed by sgf.ParseFile, in lintSGF.
+func (l *linter) walk(t *sgfTree, st lintState, variation []int, node int) {
+	for _, n := range t.nodes {
+		l.lintNode(n, &st, variation, node)
+		node++
+	}
+	for i, v := range t.vars {
+		l.walk(v, st, append(variation[:len(variation):len(variation)], i), node)
+	}
+}
+
+// lintNode checks the points of the node n, number node, against the board size.
+func (l *linter) lintNode(n *sgfNode, st *lintState, variation []int, node int) {
+	if node > 0 && n.prop("SZ") != nil {
+		l.report("sz-mismatch", node, variation, st.move, "SZ[%s] not in the root node", n.value("SZ"))
+	}
+	for _, id := range []string{"AE", "AB", "AW"} {
+		p := n.prop(id)
+		if p == nil {
+			continue
+		}
+		pts, _ := pointList(p.vals)
+		for _, pt := range pts {
+			if pt[0] >= st.size || pt[1] >= st.nRow {
+				l.report("sz-mismatch", node, variation, st.move, "%s[%s] outside of the board", id, sgfPointValue(pt[0], pt[1]))
+			}
+		}
+	}
+	for _, id := range []string{"B", "W"} {
+		p := n.prop(id)
+		if p == nil {
+			continue
+		}
+		st.move++
+		v := ""
+		if len(p.vals) > 0 {
+			v = p.vals[0]
+		}
+		if isPass(v, st.size) {
+			continue
+		}
+		if col, row, ok := sgfPoint(v); ok && (col >= st.size || row >= st.nRow) {
+			l.report("sz-mismatch", node, variation, st.move, "%s[%s] outside of the board", id, v)
+		}
+	}
+}
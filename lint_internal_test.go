@@ -0,0 +1,38 @@
+package sgfdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSGFErrorRule(t *testing.T) {
+	// messages of sgf.ParseFile
+	tests := []struct{ msg, id string }{
+		{"repeat position", "ko"},
+		{"x.sgf:1:40: repeat position at move 10", "ko"},
+		{"BAD Property Value: AB[zz]", "bad-point"},
+		{"point occupied", "occupied"},
+		{"suicide", "suicide"},
+		{"a position", "sgf"},
+		{"unexpected EOF", "sgf"},
+	}
+	for _, tst := range tests {
+		if id := sgfErrorRule(tst.msg); id != tst.id {
+			t.Errorf("%q: got %s, expected %s", tst.msg, id, tst.id)
+		}
+	}
+}
+
+func TestCaptureStdout(t *testing.T) {
+	stdout := os.Stdout
+	printed := captureStdout(func() {
+		fmt.Println("BAD Property Value")
+	})
+	if printed != "BAD Property Value\n" {
+		t.Errorf("captured %q", printed)
+	}
+	if os.Stdout != stdout {
+		t.Errorf("os.Stdout not restored")
+	}
+}
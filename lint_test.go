@@ -0,0 +1,142 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	. "github.com/Ken1JF/sgfdb"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	const head = "(;GM[1]FF[4]SZ[19]PB[b]PW[w]DT[2001-02-03]RE[B+2.5]"
+	const resign = "(;GM[1]FF[4]SZ[19]PB[b]PW[w]DT[2001-02-03]RE[B+R]"
+	tests := []struct {
+		sgf   string
+		found string // rule@node/variation, separated by spaces
+	}{
+		{resign + ";B[pd];W[dd])", "re-mismatch@2"},
+		{"(;GM[1]FF[3]SZ[19]PB[b]PW[w]DT[2001-2-3,5]RE[Black wins]KM[6.5 pts]HA[2]AB[dd];B[pd])",
+			"dt-format@0 re-format@0 gogod-header@0 gogod-km@0 gogod-ha@0"},
+		{"(;GM[1]FF[4]SZ[19]DT[1990-12-24,25,1991-01-02]RE[W+R];W[pd])", "root-missing@0 root-missing@0"},
+		// the moves are counted from the branch
+		{head + ";B[pd](;W[pd])(;W[dd];B[tt];W[tt];B[tu]))", "sz-mismatch@5/1"},
+		{resign + ";B[pd];W[tt];B[tt])", "re-mismatch@3"},
+		{"(;GM[1]FF[4]SZ[9]PB[b]PW[w]DT[2001]RE[B+2]B[ee];W[kk];SZ[9])(;GM[1])",
+			"gogod-collection@-1 gogod-root-move@0 sz-mismatch@1 sz-mismatch@2 root-missing@0 root-missing@0 root-missing@0 root-missing@0 root-missing@0 root-missing@0"},
+		{head + ";B[pd];W[", "syntax@-1"},
+		{head + ";B[pd]AddBlack[dd])", "old-ident@-1"},
+	}
+	// the errors of sgf.ParseFile depend on the messages of the sgf package
+	noSGF := []string{"sgf", "bad-point", "occupied", "suicide", "ko"}
+	for _, tst := range tests {
+		findings, err := Lint("x.sgf", []byte(tst.sgf), LintOptions{Disable: noSGF})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range findings {
+			s := f.Rule + "@" + itoa(f.Node)
+			if f.Variation != "" {
+				s += "/" + f.Variation
+			}
+			got = append(got, s)
+		}
+		if g := strings.Join(got, " "); g != tst.found {
+			t.Errorf("%s:\ngot      %s\nexpected %s\n%v", tst.sgf, g, tst.found, findings)
+		}
+	}
+
+	findings, err := Lint("x.sgf", []byte(tests[1].sgf), LintOptions{Enable: []string{"dt-format", "gogod-ha"}, Disable: []string{"gogod-ha"}})
+	if err != nil || len(findings) != 1 || findings[0].Rule != "dt-format" {
+		t.Errorf("enabled rules: %v %v", findings, err)
+	}
+	if _, err := Lint("x.sgf", nil, LintOptions{Disable: []string{"nosuchrule"}}); err == nil {
+		t.Errorf("expected an error for an unknown rule")
+	}
+
+	files := map[string]string{
+		"a/1.sgf": tests[0].sgf,
+		"b/2.sgf": head + ")",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	findings, err = LintDatabase(context.Background(), dbReq, LintOptions{Disable: noSGF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteLintFindings(&buf, findings); err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"file":"a/1.sgf","game":0,"node":2,"move":2,"rule":"re-mismatch","severity":"warning","msg":"RE[B+R], but the last move is by W"}
+`
+	if buf.String() != want {
+		t.Errorf("JSON lines:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+
+	// sgf.ParseFile is called by one file server at a time
+	dbReq = memDB(files, DBProcessRequest{DoMultiCPU: true, MaxAtOnce: 4, NumCPUs: 4, Schedule: ScheduleFiles})
+	if _, err := LintDatabase(context.Background(), dbReq, LintOptions{Enable: []string{"sgf"}}); err != nil {
+		t.Fatal(err)
+	}
+	if dbReq.DoMultiCPU || dbReq.MaxAtOnce != 1 {
+		t.Errorf("sgf rule checked with DoMultiCPU %v, MaxAtOnce %d", dbReq.DoMultiCPU, dbReq.MaxAtOnce)
+	}
+}
+
+// TestLintSGFStdout lints files with errors of sgf.ParseFile: a move on an
+// occupied point, a ko retaken at once, and a point outside of the board in
+// the root node. What sgf prints is not written to os.Stdout, so the output
+// is JSON lines only.
+func TestLintSGFStdout(t *testing.T) {
+	files := map[string]string{
+		"a/occupied.sgf": "(;GM[1]FF[4]SZ[19];B[pd];W[dd];B[dd])",
+		"a/ko.sgf":       "(;GM[1]FF[4]SZ[19];B[cb];W[db];B[bc];W[ec];B[cd];W[dd];B[aa];W[cc];B[dc];W[cc])",
+		"a/root.sgf":     "(;GM[1]FF[4]SZ[19]AB[zz];B[pd];W[dd])",
+	}
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = wr
+	findings, err := LintDatabase(context.Background(), memDB(files, DBProcessRequest{Recursive: true}), LintOptions{})
+	if err == nil {
+		err = WriteLintFindings(os.Stdout, findings)
+	}
+	os.Stdout = stdout
+	wr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line != "" && !json.Valid([]byte(line)) {
+			t.Errorf("not a JSON line: %q", line)
+		}
+	}
+	for _, f := range findings {
+		switch {
+		case f.File == "a/ko.sgf" && (f.Rule == "sgf" || strings.Contains(f.Msg, "repeat position")) && f.Rule != "ko":
+			t.Errorf("%s: rule %s, expected ko", f.Msg, f.Rule)
+		case f.File == "a/root.sgf" && f.Rule == "bad-point" && f.Node != 0:
+			t.Errorf("%s: node %d, expected the root", f.Msg, f.Node)
+		}
+	}
+}
+
+func itoa(n int) string {
+	if n < 0 {
+		return "-" + itoa(-n)
+	}
+	if n < 10 {
+		return string(rune('0' + n))
+	}
+	return itoa(n/10) + string(rune('0'+n%10))
+}
@@ -0,0 +1,263 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/normalize.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Ken1JF/sgf"
+)
+
+// propOrder is the order of the properties of a node in the canonical form.
+// The other properties follow, in alphabetical order, and then C.
+var propOrder = []string{
+	// root
+	"GM", "FF", "CA", "AP", "ST", "SZ",
+	// game info
+	"GN", "EV", "RO", "PC", "DT", "PB", "BR", "BT", "PW", "WR", "WT",
+	"KM", "HA", "RU", "TM", "OT", "RE", "SO", "US", "AN", "CP", "ON", "GC",
+	// setup
+	"AB", "AW", "AE", "PL",
+	// move
+	"B", "W", "KO", "MN", "BL", "WL", "OB", "OW",
+}
+
+var propRank = func() map[string]int {
+	m := make(map[string]int, len(propOrder))
+	for i, id := range propOrder {
+		m[id] = i
+	}
+	return m
+}()
+
+// propLess reports whether the property a is written before b.
+func propLess(a string, b string) bool {
+	ra, oka := propRank[a]
+	rb, okb := propRank[b]
+	switch {
+	case oka && okb:
+		return ra < rb
+	case oka != okb:
+		return oka
+	case a == "C" || b == "C":
+		return b == "C" && a != "C"
+	}
+	return a < b
+}
+
+var reDateParts = regexp.MustCompile(`^([0-9]{4})(?:[-/.]([0-9]{1,2})(?:[-/.]([0-9]{1,2}))?)?$`)
+
+// normalizeDate returns the DT value dt with the dates written YYYY-MM-DD,
+// i.e. "1990/1/2" as "1990-01-02". Values which are not dates are returned trimmed.
+func normalizeDate(dt string) string {
+	parts := strings.Split(dt, ",")
+	for i, d := range parts {
+		d = strings.TrimSpace(d)
+		m := reDateParts.FindStringSubmatch(d)
+		if m == nil {
+			parts[i] = d
+			continue
+		}
+		d = m[1]
+		for _, n := range m[2:] {
+			if n == "" {
+				break
+			}
+			if len(n) == 1 {
+				n = "0" + n
+			}
+			d += "-" + n
+		}
+		parts[i] = d
+	}
+	return strings.Join(parts, ",")
+}
+
+// NormalizeSGF returns the SGF file b in a canonical form:
+//   - the properties of each node in a fixed order (see propOrder),
+//   - numPerLine move nodes on each line, the root node on its own line,
+//     and each variation on a new line,
+//   - UTF-8 text, with CA[UTF-8] (see TranscodeSGF),
+//   - DT dates written YYYY-MM-DD,
+//   - the white space at the ends of text values, and of their lines,
+//     removed, and the lines ended by "\n",
+//   - the points of AB, AW, and AE uncompressed and sorted,
+//   - passes written [].
+//
+// Two files holding the same games, written by different programs,
+// are written the same, so they can be compared with diff, or by a hash.
+func NormalizeSGF(b []byte, numPerLine int) ([]byte, error) {
+	if numPerLine <= 0 {
+		numPerLine = 10
+	}
+	b, _, err := TranscodeSGF(b)
+	if err != nil {
+		return nil, err
+	}
+	trees, err := readSGF(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(trees) == 0 {
+		return nil, ErrNoGame
+	}
+	var buf bytes.Buffer
+	for _, t := range trees {
+		if len(t.nodes) == 0 {
+			continue
+		}
+		root := t.nodes[0]
+		if p := root.prop("CA"); p != nil {
+			p.vals = []string{"UTF-8"}
+		} else {
+			root.props = append(root.props, sgfProp{"CA", []string{"UTF-8"}})
+		}
+		size, _ := boardSize(root.value("SZ"))
+		col := 0
+		writeCanonical(&buf, t, size, numPerLine, &col, true)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeNode writes the properties of n in the canonical order and form.
+func normalizeNode(n *sgfNode, size int) {
+	for i := range n.props {
+		p := &n.props[i]
+		switch {
+		case p.id == "B" || p.id == "W":
+			if len(p.vals) > 0 && isPass(p.vals[0], size) {
+				p.vals[0] = ""
+			}
+		case p.id == "AB" || p.id == "AW" || p.id == "AE":
+			pts, bad := pointList(p.vals)
+			vals := make([]string, 0, len(pts)+len(bad))
+			for _, pt := range pts {
+				vals = append(vals, sgfPointValue(pt[0], pt[1]))
+			}
+			vals = append(vals, bad...)
+			sort.Strings(vals)
+			p.vals = vals[:0]
+			for j, v := range vals {
+				if j == 0 || v != vals[j-1] {
+					p.vals = append(p.vals, v)
+				}
+			}
+		case p.id == "DT":
+			for j, v := range p.vals {
+				p.vals[j] = normalizeDate(v)
+			}
+		case textProps[p.id]:
+			for j, v := range p.vals {
+				v = strings.ReplaceAll(v, "\r\n", "\n")
+				lines := strings.Split(strings.ReplaceAll(v, "\r", "\n"), "\n")
+				for k, l := range lines {
+					lines[k] = strings.TrimRightFunc(l, unicode.IsSpace)
+				}
+				p.vals[j] = strings.TrimSpace(strings.Join(lines, "\n"))
+			}
+		}
+	}
+	sort.SliceStable(n.props, func(i, j int) bool { return propLess(n.props[i].id, n.props[j].id) })
+}
+
+// writeCanonical writes the tree t, with numPerLine move nodes on a line.
+// col is the number of move nodes on the current line.
+func writeCanonical(buf *bytes.Buffer, t *sgfTree, size int, numPerLine int, col *int, game bool) {
+	buf.WriteByte('(')
+	for i, n := range t.nodes {
+		normalizeNode(n, size)
+		if *col == numPerLine {
+			buf.WriteByte('\n')
+			*col = 0
+		}
+		buf.WriteByte(';')
+		for _, p := range n.props {
+			buf.WriteString(p.id)
+			for _, v := range p.vals {
+				buf.WriteByte('[')
+				buf.WriteString(sgfEscape(v))
+				buf.WriteByte(']')
+			}
+		}
+		if game && i == 0 {
+			if len(t.nodes) > 1 || len(t.vars) > 0 {
+				buf.WriteByte('\n')
+			}
+			continue
+		}
+		*col++
+	}
+	for _, v := range t.vars {
+		if b := buf.Bytes(); b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		*col = 0
+		writeCanonical(buf, v, size, numPerLine, col, false)
+	}
+	buf.WriteByte(')')
+}
+
+// writeNormalized writes the file fName, b, in the canonical form.
+// If VerifyOutput is set, the canonical form is normalized again,
+// and a change is recorded as an error.
+func (r *DirectoryProcessRequest) writeNormalized(fName string, b []byte) {
+	out, err := NormalizeSGF(b, r.dbReq.NumPerLine)
+	if err != nil {
+		fmt.Printf("%s Error normalizing: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+		r.FileError(fName, "Normalizing", err)
+		return
+	}
+	if err = r.WriteOutput(fName, out); err != nil {
+		fmt.Printf("%s Error writing: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+		r.FileError(fName, "Writing", err)
+		return
+	}
+	if r.dbReq.VerifyOutput {
+		again, err := NormalizeSGF(out, r.dbReq.NumPerLine)
+		if err == nil && !bytes.Equal(again, out) {
+			err = CompareSGF(out, again)
+			if err == nil {
+				err = errors.New("canonical form changed by normalizing again")
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s Copy differs: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+			r.FileError(fName, "Verifying", err)
+		}
+	}
+}
+
+// ReadAndNormalizeDatabase is ReadAndWriteDatabase in the normalization mode:
+// each file of db_dir is parsed, and written to out_dir in the canonical form
+// of NormalizeSGF, whole, with sgf.DefaultNumPerLine moves on a line.
+// The files are normalized by a fileServer for each CPU.
+func ReadAndNormalizeDatabase(db_dir string, out_dir string, fileLimit int, skipFiles int, pMode sgf.ParserMode) int {
+
+	defer un(trace("ReadAndNormalizeDatabase"), nil)
+
+	var dbReq DBProcessRequest
+
+	fmt.Printf("Normalizing database, db_dir = %v, out_dir = %v\n",
+		db_dir, out_dir)
+
+	dbReq.initDBRequest("ReadAndNormalizeDatabase", db_dir, out_dir, true, false, 0, skipFiles, fileLimit, 0, pMode|sgf.ParserGoGoD|sgf.ParserPlay, WriteSGFFile, WriteSGFDirectory, WriteSGFDatabase, sgf.DefaultNumPerLine)
+	dbReq.Schedule = ScheduleFiles
+	dbReq.OrderedResults = true
+	dbReq.VerifyOutput = true
+	dbReq.Normalize = true
+	ret := ProcessDatabase(&dbReq)
+	return ret
+}
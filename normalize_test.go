@@ -0,0 +1,90 @@
+package sgfdb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestNormalizeSGF(t *testing.T) {
+	tests := []struct {
+		b   string
+		out string
+	}{
+		{"(;SZ[19]FF[3]GM[1]DT[1990/1/2]PB[ Honinbo \n]AB[pd][dp]HA[2]\n;W[qp]  ;B[tt];W[dd])",
+			"(;GM[1]FF[3]CA[UTF-8]SZ[19]DT[1990-01-02]PB[Honinbo]HA[2]AB[dp][pd]\n;W[qp];B[]\n;W[dd])\n"},
+		{"(;GM[1]SZ[9]AW[aa:ab][aa];B[ee](;W[cc];B[gg])(;C[ hi \r\n there ]W[gc]))",
+			"(;GM[1]CA[UTF-8]SZ[9]AW[aa][ab]\n;B[ee]\n(;W[cc];B[gg])\n(;W[gc]C[hi\n there]))\n"},
+		{"(;GM[1]DT[1990-1-2,3]CA[utf-8])(;GM[1]CA[ISO-8859-1]PB[Jos\xe9])",
+			"(;GM[1]CA[UTF-8]DT[1990-01-02,3])\n(;GM[1]CA[UTF-8]PB[José])\n"},
+		{"(;GM[1]PB[Jos\xe9])", "(;GM[1]CA[UTF-8]PB[José])\n"},
+		{"(;GM[1]CA[UTF-8]PB[Jos\xe9])", "(;GM[1]CA[UTF-8]PB[José])\n"},
+	}
+	for _, tst := range tests {
+		out, err := NormalizeSGF([]byte(tst.b), 2)
+		if err != nil {
+			t.Errorf("%q: %s", tst.b, err)
+			continue
+		}
+		if string(out) != tst.out {
+			t.Errorf("%q:\ngot      %q\nexpected %q", tst.b, out, tst.out)
+		}
+		again, err := NormalizeSGF(out, 2)
+		if err != nil || string(again) != string(out) {
+			t.Errorf("%q: normalized again: %q, %v", tst.b, again, err)
+		}
+	}
+	for _, b := range []string{"", "(;GM[1]C[\x81\x7f\xff])"} {
+		if _, err := NormalizeSGF([]byte(b), 2); err == nil {
+			t.Errorf("%q: expected an error", b)
+		}
+	}
+}
+
+func TestWriteSGFFileNormalize(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;SZ[19]GM[1]FF[4];B[pd];W[dd])",
+		"a/2.sgf": "(;GM[1]C[\x81\x7f\xff])",
+	}
+	var sink MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &sink, FileActionFunc: WriteSGFFile, NumPerLine: 10, VerifyOutput: true, Normalize: true})
+	err := ProcessDatabaseContext(context.Background(), dbReq)
+	if err == nil || !errors.Is(err, ErrCharsetUnknown) {
+		t.Errorf("expected an error for 2.sgf, got %v", err)
+	}
+	if got := string(sink.Files["a/1.sgf"]); got != "(;GM[1]FF[4]CA[UTF-8]SZ[19]\n;B[pd];W[dd])\n" {
+		t.Errorf("1.sgf: %q", got)
+	}
+	if _, ok := sink.Files["a/2.sgf"]; ok {
+		t.Errorf("2.sgf written")
+	}
+	if _, _, _, errs := dbReq.Totals(); errs != 1 {
+		t.Errorf("%d errors, expected 1", errs)
+	}
+}
+
+func TestWriteSGFFileNormalizeParallel(t *testing.T) {
+	// run with -race: the files are normalized, and parsed, by several fileServers
+	files := map[string]string{}
+	for d := 0; d < 3; d++ {
+		for f := 0; f < 8; f++ {
+			files[fmt.Sprintf("d%d/%d.sgf", d, f)] = "(;SZ[19]GM[1]FF[4];B[pd];W[dd])"
+		}
+	}
+	var sink MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &sink, DoMultiCPU: true, MaxAtOnce: 4, NumCPUs: 4,
+		Schedule: ScheduleFiles, FileActionFunc: WriteSGFFile, NumPerLine: 10, VerifyOutput: true, Normalize: true})
+	if err := ProcessDatabaseContext(context.Background(), dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.Files) != len(files) {
+		t.Errorf("%d files written, expected %d", len(sink.Files), len(files))
+	}
+	for name, b := range sink.Files {
+		if string(b) != "(;GM[1]FF[4]CA[UTF-8]SZ[19]\n;B[pd];W[dd])\n" {
+			t.Errorf("%s: %q", name, b)
+		}
+	}
+}
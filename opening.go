@@ -0,0 +1,472 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/opening.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Ken1JF/ah"
+)
+
+// OpeningCounts are a number of games, and their winners, taken from RE.
+type OpeningCounts struct {
+	Games     int
+	BlackWins int
+	WhiteWins int
+}
+
+func (c OpeningCounts) add(o OpeningCounts) OpeningCounts {
+	return OpeningCounts{c.Games + o.Games, c.BlackWins + o.BlackWins, c.WhiteWins + o.WhiteWins}
+}
+
+// String returns the counts, on one line.
+func (c OpeningCounts) String() string {
+	return fmt.Sprintf("%d games, Black wins %.1f%%, White wins %.1f%%", c.Games, percent(c.BlackWins, c.Games), percent(c.WhiteWins, c.Games))
+}
+
+// OpeningStats are the statistics of the games reaching a node of an OpeningTree.
+// The winner is taken from RE, the year from DT, and the ranks from BR and WR.
+type OpeningStats struct {
+	OpeningCounts
+	FirstYear  int                      // 0 if no game has a year
+	LastYear   int                      //
+	Years      map[int]OpeningCounts    // by the first year of the range of years of DT, 0 if no year
+	BlackRanks map[string]OpeningCounts // by BR, "" if none
+	WhiteRanks map[string]OpeningCounts // by WR, "" if none
+}
+
+// add adds the statistics of o to st.
+func (st *OpeningStats) add(o *OpeningStats) {
+	st.OpeningCounts = st.OpeningCounts.add(o.OpeningCounts)
+	if o.FirstYear != 0 && (st.FirstYear == 0 || o.FirstYear < st.FirstYear) {
+		st.FirstYear = o.FirstYear
+	}
+	if o.LastYear > st.LastYear {
+		st.LastYear = o.LastYear
+	}
+	st.Years = addCounts(st.Years, o.Years)
+	st.BlackRanks = addCounts(st.BlackRanks, o.BlackRanks)
+	st.WhiteRanks = addCounts(st.WhiteRanks, o.WhiteRanks)
+}
+
+// addCounts adds the counts of o to m, by key, and returns m.
+func addCounts[K comparable](m map[K]OpeningCounts, o map[K]OpeningCounts) map[K]OpeningCounts {
+	if m == nil {
+		m = make(map[K]OpeningCounts, len(o))
+	}
+	for k, c := range o {
+		m[k] = m[k].add(c)
+	}
+	return m
+}
+
+// String returns the statistics, as written at the start of the comments of the SGF tree.
+func (st *OpeningStats) String() string {
+	s := fmt.Sprintf("Games: %d\nBlack wins: %d (%.1f%%)\nWhite wins: %d (%.1f%%)",
+		st.Games, st.BlackWins, percent(st.BlackWins, st.Games), st.WhiteWins, percent(st.WhiteWins, st.Games))
+	if st.FirstYear != 0 {
+		s += fmt.Sprintf("\nYears: %d-%d", st.FirstYear, st.LastYear)
+	}
+	return s
+}
+
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// gameYear returns the year of the DT value dt, or 0.
+func gameYear(dt string) int {
+	dt = strings.TrimSpace(dt)
+	if len(dt) < 4 {
+		return 0
+	}
+	y, err := strconv.Atoi(dt[:4])
+	if err != nil || y <= 0 {
+		return 0
+	}
+	return y
+}
+
+// gameWinner returns the winner of the RE value re, or Empty.
+func gameWinner(re string) Color {
+	re = strings.TrimSpace(re)
+	switch {
+	case strings.HasPrefix(re, "B+"):
+		return Black
+	case strings.HasPrefix(re, "W+"):
+		return White
+	}
+	return Empty
+}
+
+// OpeningNode is a move of an OpeningTree, with the statistics of the games playing it.
+type OpeningNode struct {
+	Move  string // the SGF point of the move, "" for a pass, or the root
+	Color Color
+	OpeningStats
+	Children []*OpeningNode // most played first
+}
+
+// OpeningTree holds the first moves of the games of a database.
+// The moves of each game are transformed by the symmetry giving the
+// smallest sequence of points, so games which are the same up to
+// symmetry share a line of the tree.
+// Transpositions are different lines, as in an SGF tree.
+type OpeningTree struct {
+	Size      int
+	Moves     int
+	YearRange int // years counted together in OpeningStats.Years
+	Root      OpeningNode
+}
+
+// OpeningOptions select the games and moves of an OpeningTree.
+type OpeningOptions struct {
+	Moves     int    // number of moves of each game, 20 if 0
+	Size      int    // board size of the games, 19 if 0
+	Filter    *Query // the games, i.e. DT>=1990 AND DT<=1999 AND BR=*p; all if nil
+	MinGames  int    // moves played in fewer games are dropped
+	YearRange int    // years counted together in OpeningStats.Years, 10 if 0
+}
+
+// OpeningMove is a move which follows a position, with the statistics of its games.
+type OpeningMove struct {
+	Move  string // the SGF point of the move, "" for a pass
+	Color Color
+	OpeningStats
+}
+
+// openingGame holds the first moves of a game.
+type openingGame struct {
+	points []int // row*size+col, or size*size for a pass
+	colors []Color
+	stats  OpeningStats
+}
+
+// openingMoves returns the first limit moves of g, up to its first
+// setup node, and whether there is a setup node. A pass is size*size.
+func openingMoves(g *gameMoves, limit int) (points []int, colors []Color, setup bool) {
+	for _, op := range g.Ops {
+		if len(points) >= limit {
+			break
+		}
+		p := int(op & (1<<opShift - 1))
+		switch gameOp(op >> opShift) {
+		case opMoveB:
+			points, colors = append(points, p), append(colors, Black)
+		case opMoveW:
+			points, colors = append(points, p), append(colors, White)
+		case opPass:
+			points, colors = append(points, g.Size*g.Size), append(colors, Black)
+		case opPassW:
+			points, colors = append(points, g.Size*g.Size), append(colors, White)
+		default:
+			return points, colors, true
+		}
+	}
+	return points, colors, false
+}
+
+// normalizeOpening returns the points transformed by the symmetry which
+// gives the smallest sequence, and the symmetry.
+func normalizeOpening(points []int, size int) ([]int, ah.BoardTrans) {
+	best := make([]int, len(points))
+	bestSym := ah.BoardTrans(0)
+	t := make([]int, len(points))
+	for s := ah.BoardTrans(0); s < numTrans; s++ {
+		for i, p := range points {
+			if p == size*size {
+				t[i] = p
+				continue
+			}
+			c, r := TransPoint(s, p%size, p/size, size)
+			t[i] = r*size + c
+		}
+		if s == 0 || lessPoints(t, best) {
+			copy(best, t)
+			bestSym = s
+		}
+	}
+	return best, bestSym
+}
+
+func lessPoints(a []int, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// BuildOpeningTree returns the OpeningTree of the games of the database of dbrq
+// selected by opts. Games with setup stones, i.e. handicap games, are not included.
+func BuildOpeningTree(ctx context.Context, dbrq *DBProcessRequest, opts OpeningOptions) (*OpeningTree, error) {
+	defer un(trace("BuildOpeningTree"), nil)
+	if opts.Moves <= 0 {
+		opts.Moves = 20
+	}
+	if opts.Size <= 0 {
+		opts.Size = 19
+	}
+	if opts.YearRange <= 0 {
+		opts.YearRange = 10
+	}
+	games, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[*openingGame, []*openingGame, []*openingGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) *openingGame {
+			trees, err := readSGF(b)
+			if len(trees) == 0 {
+				r.FileError(fName, "Reading opening", err)
+				return nil
+			}
+			var rec GameRecord
+			rec.readTree(trees[0])
+			if opts.Filter != nil && !opts.Filter.Match(&rec) {
+				return nil
+			}
+			g, err2 := compileGame(trees[0])
+			if err == nil && err2 != errNotSquare {
+				err = err2
+			}
+			if err != nil {
+				r.FileError(fName, "Reading opening", err)
+			}
+			if g.Size != opts.Size {
+				return nil
+			}
+			points, colors, _ := openingMoves(&g, opts.Moves)
+			if len(points) == 0 {
+				return nil // no moves before the setup stones
+			}
+			og := &openingGame{colors: colors}
+			og.points, _ = normalizeOpening(points, g.Size)
+			c := OpeningCounts{Games: 1}
+			switch gameWinner(rec.RE) {
+			case Black:
+				c.BlackWins = 1
+			case White:
+				c.WhiteWins = 1
+			}
+			year := gameYear(rec.DT)
+			og.stats = OpeningStats{OpeningCounts: c, FirstYear: year, LastYear: year,
+				Years:      map[int]OpeningCounts{year - year%opts.YearRange: c},
+				BlackRanks: map[string]OpeningCounts{strings.TrimSpace(rec.BR): c},
+				WhiteRanks: map[string]OpeningCounts{strings.TrimSpace(rec.WR): c},
+			}
+			return og
+		},
+		Dir: func(d []*openingGame, g *openingGame) []*openingGame {
+			if g == nil {
+				return d
+			}
+			return append(d, g)
+		},
+		DB: func(res []*openingGame, d []*openingGame) []*openingGame {
+			return append(res, d...)
+		},
+	})
+	ot := &OpeningTree{Size: opts.Size, Moves: opts.Moves, YearRange: opts.YearRange}
+	for _, g := range games {
+		ot.add(g)
+	}
+	ot.Root.finish(opts.MinGames)
+	return ot, err
+}
+
+// add adds the moves of a game to the tree.
+func (ot *OpeningTree) add(g *openingGame) {
+	n := &ot.Root
+	n.add(&g.stats)
+	for i, p := range g.points {
+		mv := ""
+		if p < ot.Size*ot.Size {
+			mv = sgfPointValue(p%ot.Size, p/ot.Size)
+		}
+		var child *OpeningNode
+		for _, c := range n.Children {
+			if c.Move == mv && c.Color == g.colors[i] {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &OpeningNode{Move: mv, Color: g.colors[i]}
+			n.Children = append(n.Children, child)
+		}
+		child.add(&g.stats)
+		n = child
+	}
+}
+
+// finish drops the children played in fewer than minGames games,
+// and sorts the others, most played first.
+func (n *OpeningNode) finish(minGames int) {
+	kept := n.Children[:0]
+	for _, c := range n.Children {
+		if c.Games >= minGames {
+			c.finish(minGames)
+			kept = append(kept, c)
+		}
+	}
+	n.Children = kept
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		if a.Color != b.Color {
+			return a.Color < b.Color
+		}
+		return a.Move < b.Move
+	})
+}
+
+// ErrNotInTree is returned by OpeningTree.Next and JosekiTree.Find
+// for moves which are not in the tree.
+var ErrNotInTree = errors.New("moves not in the tree")
+
+// Next returns the moves played after the moves of the SGF fragment,
+// i.e. ";B[pd];W[dd];B[pp]", with their statistics, most played first.
+// The moves are given in the orientation of the fragment.
+// Next returns ErrNotInTree if the fragment is not in the tree.
+func (ot *OpeningTree) Next(fragment []byte) ([]OpeningMove, error) {
+	if bytes.IndexByte(fragment, '(') < 0 {
+		fragment = append(append([]byte("("), fragment...), ')')
+	}
+	trees, err := readSGF(fragment)
+	if err != nil {
+		return nil, err
+	}
+	g, err := compileGame(trees[0])
+	if err != nil {
+		return nil, err
+	}
+	if g.Size != ot.Size {
+		return nil, fmt.Errorf("board size %d, expected %d", g.Size, ot.Size)
+	}
+	points, colors, setup := openingMoves(&g, len(g.Ops))
+	if setup {
+		return nil, errors.New("opening with setup stones")
+	}
+	norm, s := normalizeOpening(points, g.Size)
+	n := &ot.Root
+	for i, p := range norm {
+		mv := ""
+		if p < ot.Size*ot.Size {
+			mv = sgfPointValue(p%ot.Size, p/ot.Size)
+		}
+		var child *OpeningNode
+		for _, c := range n.Children {
+			if c.Move == mv && c.Color == colors[i] {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			return nil, ErrNotInTree
+		}
+		n = child
+	}
+	moves := make([]OpeningMove, len(n.Children))
+	for i, c := range n.Children {
+		moves[i] = OpeningMove{Move: c.Move, Color: c.Color, OpeningStats: c.OpeningStats}
+		if col, row, ok := sgfPoint(c.Move); ok {
+			col, row = TransPoint(InverseTrans(s), col, row, ot.Size)
+			moves[i].Move = sgfPointValue(col, row)
+		}
+	}
+	return moves, nil
+}
+
+// WriteSGF writes the tree to w as an SGF GameTree, with the statistics
+// of each move in its comment: the games, and their winners, in all,
+// by range of years, and by the ranks of Black and White.
+// The tree is written here, not by sgf.GameTree.WriteFile: an sgf.GameTree
+// is only made by sgf.ParseFile, and has no exported nodes to add the
+// moves and comments of an OpeningTree to.
+func (ot *OpeningTree) WriteSGF(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "(;GM[1]FF[4]CA[UTF-8]SZ[%d]GN[Opening tree, %d moves]C[%s]", ot.Size, ot.Moves, sgfEscape(ot.comment(&ot.Root)))
+	ot.writeChildren(&buf, &ot.Root)
+	buf.WriteString(")\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteFile writes the tree to the file fileName, as WriteSGF does,
+// to be browsed in an SGF editor.
+func (ot *OpeningTree) WriteFile(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = ot.WriteSGF(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// comment returns the comment of n in the SGF tree.
+// Games without a year, or a rank, are only in the totals.
+func (ot *OpeningTree) comment(n *OpeningNode) string {
+	s := n.OpeningStats.String()
+	years := make([]int, 0, len(n.Years))
+	for y := range n.Years {
+		if y != 0 {
+			years = append(years, y)
+		}
+	}
+	sort.Ints(years)
+	for _, y := range years {
+		s += fmt.Sprintf("\n%d-%d: %s", y, y+ot.YearRange-1, n.Years[y])
+	}
+	for _, r := range []struct {
+		id    string
+		ranks map[string]OpeningCounts
+	}{{"BR", n.BlackRanks}, {"WR", n.WhiteRanks}} {
+		ranks := make([]string, 0, len(r.ranks))
+		for rank := range r.ranks {
+			if rank != "" {
+				ranks = append(ranks, rank)
+			}
+		}
+		sort.Strings(ranks)
+		for _, rank := range ranks {
+			s += fmt.Sprintf("\n%s %s: %s", r.id, rank, r.ranks[rank])
+		}
+	}
+	return s
+}
+
+func (ot *OpeningTree) writeChildren(buf *bytes.Buffer, n *OpeningNode) {
+	for len(n.Children) == 1 {
+		n = n.Children[0]
+		ot.writeNode(buf, n)
+	}
+	for _, c := range n.Children {
+		buf.WriteString("\n(")
+		ot.writeNode(buf, c)
+		ot.writeChildren(buf, c)
+		buf.WriteString(")")
+	}
+}
+
+func (ot *OpeningTree) writeNode(buf *bytes.Buffer, n *OpeningNode) {
+	fmt.Fprintf(buf, ";%s[%s]C[%s]", n.Color, n.Move, sgfEscape(ot.comment(n)))
+}
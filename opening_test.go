@@ -0,0 +1,129 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestOpeningTree(t *testing.T) {
+	files := map[string]string{
+		"1990/1.sgf": "(;GM[1]SZ[19]DT[1990-01-02]RE[B+R];B[pd];W[dd];B[pp];W[dp])",
+		// 1.sgf, flipped top to bottom
+		"2005/2.sgf": "(;GM[1]SZ[19]DT[2005]RE[W+2.5];B[pp];W[dp];B[pd];W[dd])",
+		"2008/3.sgf": "(;GM[1]SZ[19]DT[2008]BR[9p]WR[9p]RE[B+1.5];B[pd];W[dc];B[pp])",
+		"2008/4.sgf": "(;GM[1]SZ[19]HA[2]AB[pd][dp];W[pp])",
+		"2008/5.sgf": "(;GM[1]SZ[9];B[ee])",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	ot, err := BuildOpeningTree(context.Background(), dbReq, OpeningOptions{Moves: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ot.Root.Games != 3 || ot.Root.BlackWins != 2 || ot.Root.WhiteWins != 1 || ot.Root.FirstYear != 1990 || ot.Root.LastYear != 2008 {
+		t.Errorf("root: %+v", ot.Root.OpeningStats)
+	}
+
+	tests := []struct {
+		fragment string
+		next     string
+	}{
+		{";", "[{dd B {3 2 1 1990 2008}}]"},
+		{";B[pd]", "[{dd W {2 1 1 1990 2005}} {dc W {1 1 0 2008 2008}}]"},
+		{";B[dp]", "[{pp W {2 1 1 1990 2005}} {pq W {1 1 0 2008 2008}}]"},
+		{";B[pd];W[dd]", "[{pp B {2 1 1 1990 2005}}]"},
+	}
+	for _, tst := range tests {
+		next, err := ot.Next([]byte(tst.fragment))
+		if err != nil {
+			t.Errorf("%s: %s", tst.fragment, err)
+			continue
+		}
+		got := make([]string, len(next))
+		for i, mv := range next {
+			got[i] = fmt.Sprintf("{%s %s {%d %d %d %d %d}}", mv.Move, mv.Color, mv.Games, mv.BlackWins, mv.WhiteWins, mv.FirstYear, mv.LastYear)
+		}
+		if fmt.Sprint(got) != tst.next {
+			t.Errorf("%s: got %s, expected %s", tst.fragment, got, tst.next)
+		}
+	}
+	for _, fragment := range []string{";B[qd]", ";B[pd];W[dp];B[dd]"} {
+		if next, err := ot.Next([]byte(fragment)); err != ErrNotInTree {
+			t.Errorf("%s: got %v, %v, expected ErrNotInTree", fragment, next, err)
+		}
+	}
+	next, _ := ot.Next([]byte(";B[pd]"))
+	if len(next) != 2 {
+		t.Fatalf("next: %v", next)
+	}
+	if got := fmt.Sprint(next[0].Years); got != "map[1990:1 games, Black wins 100.0%, White wins 0.0% 2000:1 games, Black wins 0.0%, White wins 100.0%]" {
+		t.Errorf("years: %s", got)
+	}
+	if c := next[1].BlackRanks["9p"]; c.Games != 1 || c.BlackWins != 1 {
+		t.Errorf("ranks: %+v", next[1].BlackRanks)
+	}
+	if _, err := ot.Next([]byte("(;SZ[9];B[ee])")); err == nil {
+		t.Errorf("expected an error for a 9x9 fragment")
+	}
+
+	var buf bytes.Buffer
+	if err := ot.WriteSGF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	const want = `(;GM[1]FF[4]CA[UTF-8]SZ[19]GN[Opening tree, 3 moves]C[Games: 3
+Black wins: 2 (66.7%)
+White wins: 1 (33.3%)
+Years: 1990-2008
+1990-1999: 1 games, Black wins 100.0%, White wins 0.0%
+2000-2009: 2 games, Black wins 50.0%, White wins 50.0%
+BR 9p: 1 games, Black wins 100.0%, White wins 0.0%
+WR 9p: 1 games, Black wins 100.0%, White wins 0.0%];B[dd]C[Games: 3
+Black wins: 2 (66.7%)
+White wins: 1 (33.3%)
+Years: 1990-2008
+1990-1999: 1 games, Black wins 100.0%, White wins 0.0%
+2000-2009: 2 games, Black wins 50.0%, White wins 50.0%
+BR 9p: 1 games, Black wins 100.0%, White wins 0.0%
+WR 9p: 1 games, Black wins 100.0%, White wins 0.0%]
+(;W[pd]C[Games: 2
+Black wins: 1 (50.0%)
+White wins: 1 (50.0%)
+Years: 1990-2005
+1990-1999: 1 games, Black wins 100.0%, White wins 0.0%
+2000-2009: 1 games, Black wins 0.0%, White wins 100.0%];B[dp]C[Games: 2
+Black wins: 1 (50.0%)
+White wins: 1 (50.0%)
+Years: 1990-2005
+1990-1999: 1 games, Black wins 100.0%, White wins 0.0%
+2000-2009: 1 games, Black wins 0.0%, White wins 100.0%])
+(;W[pc]C[Games: 1
+Black wins: 1 (100.0%)
+White wins: 0 (0.0%)
+Years: 2008-2008
+2000-2009: 1 games, Black wins 100.0%, White wins 0.0%
+BR 9p: 1 games, Black wins 100.0%, White wins 0.0%
+WR 9p: 1 games, Black wins 100.0%, White wins 0.0%];B[dp]C[Games: 1
+Black wins: 1 (100.0%)
+White wins: 0 (0.0%)
+Years: 2008-2008
+2000-2009: 1 games, Black wins 100.0%, White wins 0.0%
+BR 9p: 1 games, Black wins 100.0%, White wins 0.0%
+WR 9p: 1 games, Black wins 100.0%, White wins 0.0%]))
+`
+	if buf.String() != want {
+		t.Errorf("SGF:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+
+	// the professional games, and the moves played at least twice
+	q, _ := ParseQuery("BR=*p AND WR=*p")
+	ot, _ = BuildOpeningTree(context.Background(), dbReq, OpeningOptions{Moves: 3, Filter: q})
+	if ot.Root.Games != 1 {
+		t.Errorf("professional games: %d", ot.Root.Games)
+	}
+	ot, _ = BuildOpeningTree(context.Background(), dbReq, OpeningOptions{Moves: 3, MinGames: 2})
+	if next, _ := ot.Next([]byte(";B[pd]")); len(next) != 1 {
+		t.Errorf("moves played twice: %v", next)
+	}
+}
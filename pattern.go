@@ -0,0 +1,672 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/pattern.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Ken1JF/ah"
+)
+
+// A Pattern is a local shape: a rectangle of points which are
+// black (X), white (O), empty (.), or don't care (?).
+// A corner Pattern is placed with its top left point at a corner of the
+// board, so the edges of the board are part of the shape; other patterns
+// may be placed anywhere on the board.
+// A Pattern is not an ah.PatternType: the pattern trees built by
+// AddTeachingPattern hold ah.WHOLE_BOARD_PATTERN positions (see ErrPatternType),
+// which have no don't care points and no placement, so Patterns are
+// matched on Board.
+type Pattern struct {
+	width  int
+	height int
+	cells  []byte // 'X', 'O', '.', or '?', by row
+	corner bool
+}
+
+// ParsePattern returns the Pattern of rows, which are separated by new lines
+// or "/", i.e. "..X/.XO/.?O". In a row, X or B is black, O or W is white,
+// "." is empty, and "?" or "*" don't care. Spaces are ignored.
+// If corner is set, the pattern is placed at a corner of the board.
+func ParsePattern(rows string, corner bool) (*Pattern, error) {
+	p := &Pattern{corner: corner}
+	known := false
+	for _, row := range strings.FieldsFunc(rows, func(r rune) bool { return r == '\n' || r == '/' }) {
+		row = strings.Join(strings.Fields(row), "")
+		if row == "" {
+			continue
+		}
+		if p.height > 0 && len(row) != p.width {
+			return nil, fmt.Errorf("pattern row %d has %d points, expected %d", p.height+1, len(row), p.width)
+		}
+		p.width = len(row)
+		for i := 0; i < len(row); i++ {
+			var c byte
+			switch row[i] {
+			case 'X', 'x', 'B':
+				c = 'X'
+			case 'O', 'o', 'W':
+				c = 'O'
+			case '.':
+				c = '.'
+			case '?', '*':
+				c = '?'
+			default:
+				return nil, fmt.Errorf("pattern row %d: unexpected %q", p.height+1, row[i])
+			}
+			known = known || c != '?'
+			p.cells = append(p.cells, c)
+		}
+		p.height++
+	}
+	if !known {
+		return nil, errors.New("pattern has no black, white or empty points")
+	}
+	if p.width > MaxBoardSize || p.height > MaxBoardSize {
+		return nil, errors.New("pattern is larger than the board")
+	}
+	return p, nil
+}
+
+// String returns the rows of the pattern, separated by "/".
+func (p *Pattern) String() string {
+	rows := make([]string, p.height)
+	for r := range rows {
+		rows[r] = string(p.cells[r*p.width : (r+1)*p.width])
+	}
+	return strings.Join(rows, "/")
+}
+
+// patternVariant is a Pattern transformed by an ah.BoardTrans,
+// with the colors reversed if reversed is set.
+// A variant of a corner pattern has the origin col, row on the board,
+// other variants may be placed with any origin.
+type patternVariant struct {
+	width    int
+	height   int
+	cells    []byte
+	col, row int
+	corner   bool
+	trans    ah.BoardTrans
+	reversed bool
+	need     []patternCell // the cells which are not '?', with the offsets on a board of size size
+}
+
+// patternCell is a point of a patternVariant: dc, dr from the origin,
+// or the offset d on the board, and the color it needs.
+type patternCell struct {
+	dc, dr int
+	d      int
+	c      Color
+}
+
+// variants returns the distinct variants of the pattern on a board of size size.
+// There are none if the pattern does not fit on the board.
+func (p *Pattern) variants(size int) []*patternVariant {
+	if p.width > size || p.height > size {
+		return nil
+	}
+	n := size
+	if !p.corner {
+		n = max(p.width, p.height)
+	}
+	var vs []*patternVariant
+	seen := map[string]bool{}
+	for s := ah.BoardTrans(0); s < numTrans; s++ {
+		minC, minR := n, n
+		for r := 0; r < p.height; r++ {
+			for c := 0; c < p.width; c++ {
+				tc, tr := TransPoint(s, c, r, n)
+				minC, minR = min(minC, tc), min(minR, tr)
+			}
+		}
+		w, h := p.width, p.height
+		if s >= 4 { // transposing symmetries
+			w, h = h, w
+		}
+		for _, reversed := range []bool{false, true} {
+			v := &patternVariant{width: w, height: h, cells: make([]byte, w*h), corner: p.corner, trans: s, reversed: reversed}
+			for r := 0; r < p.height; r++ {
+				for c := 0; c < p.width; c++ {
+					tc, tr := TransPoint(s, c, r, n)
+					cell := p.cells[r*p.width+c]
+					if reversed && cell == 'X' {
+						cell = 'O'
+					} else if reversed && cell == 'O' {
+						cell = 'X'
+					}
+					v.cells[(tr-minR)*w+tc-minC] = cell
+				}
+			}
+			if p.corner {
+				v.col, v.row = minC, minR
+			}
+			for i, cell := range v.cells {
+				pc := patternCell{dc: i % w, dr: i / w, d: (i/w)*size + i%w}
+				switch cell {
+				case 'X':
+					pc.c = Black
+				case 'O':
+					pc.c = White
+				case '.':
+					pc.c = Empty
+				default:
+					continue
+				}
+				v.need = append(v.need, pc)
+			}
+			key := fmt.Sprint(v.width, v.height, v.col, v.row, string(v.cells))
+			if !seen[key] {
+				seen[key] = true
+				vs = append(vs, v)
+			}
+		}
+	}
+	return vs
+}
+
+// matches reports whether the variant matches the board with its origin at point o.
+func (v *patternVariant) matches(b *Board, o int) bool {
+	for _, cell := range v.need {
+		if b.points[o+cell.d] != cell.c {
+			return false
+		}
+	}
+	return true
+}
+
+// accepts reports whether the cell dc, dr of the variant may hold color c.
+func (v *patternVariant) accepts(dc int, dr int, c Color) bool {
+	switch v.cells[dr*v.width+dc] {
+	case 'X':
+		return c == Black
+	case 'O':
+		return c == White
+	case '.':
+		return c == Empty
+	}
+	return true
+}
+
+// PatternMatch is a game, and a move after which a Pattern appears.
+// Col and Row are the top left point of the pattern on the board,
+// after the pattern is transformed by Trans, and its colors are
+// reversed if Reversed is set.
+type PatternMatch struct {
+	Path     string
+	Move     int
+	Col, Row int
+	Trans    ah.BoardTrans
+	Reversed bool
+}
+
+// A region is the 3x3 square of points centered on a point of a board.
+// Its key holds the color of each point, 2 bits a point, by row,
+// with regionOff for a point off the board.
+const regionOff = 3
+
+// regionKey returns the key of the region of b centered on col, row.
+func (b *Board) regionKey(col int, row int) uint32 {
+	var k uint32
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			c := uint32(regionOff)
+			if b.onBoard(col+dc, row+dr) {
+				c = uint32(b.points[(row+dr)*b.size+col+dc])
+			}
+			k = k<<2 | c
+		}
+	}
+	return k
+}
+
+var (
+	canonRegionOnce sync.Once
+	canonRegions    []uint32 // by key, the canonical key
+)
+
+// canonRegion returns the smallest of the keys of the region k under
+// the transformations of ah.BoardTrans, and with the colors reversed,
+// so a region of a pattern is found in any variant.
+func canonRegion(k uint32) uint32 {
+	canonRegionOnce.Do(func() {
+		canonRegions = make([]uint32, 1<<18)
+		var cells [9]uint32
+		for key := range canonRegions {
+			for i := range cells {
+				cells[i] = uint32(key) >> (2 * (8 - i)) & 3
+			}
+			best := uint32(key)
+			for t := ah.BoardTrans(0); t < numTrans; t++ {
+				for _, reversed := range []bool{false, true} {
+					var tk uint32
+					for i := range cells {
+						c, r := TransPoint(t, i%3, i/3, 3)
+						cell := cells[r*3+c]
+						if reversed && (cell == uint32(Black) || cell == uint32(White)) {
+							cell = uint32(Color(cell).Opponent())
+						}
+						tk = tk<<2 | cell
+					}
+					best = min(best, tk)
+				}
+			}
+			canonRegions[key] = best
+		}
+	})
+	return canonRegions[k]
+}
+
+// regionKeys returns the canonical keys of the regions of the pattern
+// whose points are all known: black, white, or empty, or off the board
+// left of, or above, a corner pattern.
+func (p *Pattern) regionKeys() []uint32 {
+	var keys []uint32
+	seen := map[uint32]bool{}
+	for row := 0; row < p.height; row++ {
+	center:
+		for col := 0; col < p.width; col++ {
+			var k uint32
+			for dr := -1; dr <= 1; dr++ {
+				for dc := -1; dc <= 1; dc++ {
+					c, r := col+dc, row+dr
+					var cell uint32
+					switch {
+					case p.corner && (c < 0 || r < 0):
+						cell = regionOff
+					case c < 0 || r < 0 || c >= p.width || r >= p.height:
+						continue center
+					default:
+						switch p.cells[r*p.width+c] {
+						case 'X':
+							cell = uint32(Black)
+						case 'O':
+							cell = uint32(White)
+						case '.':
+							cell = uint32(Empty)
+						default:
+							continue center
+						}
+					}
+					k = k<<2 | cell
+				}
+			}
+			k = canonRegion(k)
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+// regionPosting is a game of a PatternIndex holding a region,
+// and the first move after which the region is on its board.
+type regionPosting struct {
+	Game int32
+	Move int32
+}
+
+// gameRegions replays the game g, and returns the canonical keys of the
+// regions on its board after each move, with the first move they are on
+// the board, sorted by key. Only the regions holding a point changed by
+// a move are read after the move. If a move is to an occupied point,
+// the operations played are returned, with the error, as by play.
+func gameRegions(g *gameMoves) ([]regionFirst, int, error) {
+	first := map[uint32]int32{}
+	var b *Board
+	add := func(move int, col int, row int) {
+		k := canonRegion(b.regionKey(col, row))
+		if _, ok := first[k]; !ok {
+			first[k] = int32(move)
+		}
+	}
+	n, err := g.play(func(move int, bd *Board) bool {
+		if b == nil {
+			b = bd
+			b.track = true
+			for p := range b.points {
+				add(move, p%b.size, p/b.size)
+			}
+			return true
+		}
+		for _, p := range b.changes {
+			pc, pr := p%b.size, p/b.size
+			for r := max(pr-1, 0); r <= min(pr+1, b.size-1); r++ {
+				for c := max(pc-1, 0); c <= min(pc+1, b.size-1); c++ {
+					add(move, c, r)
+				}
+			}
+		}
+		b.changes = b.changes[:0]
+		return true
+	})
+	regions := make([]regionFirst, 0, len(first))
+	for k, m := range first {
+		regions = append(regions, regionFirst{k, m})
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].key < regions[j].key })
+	return regions, n, err
+}
+
+// regionFirst is a region of a game, and the first move it is on the board.
+type regionFirst struct {
+	key  uint32
+	move int32
+}
+
+// PatternIndex holds the main line of every game of a database, compiled
+// to board operations, and, for each region of 3x3 points, the games and
+// moves where it is on the board. Search looks up the regions of a Pattern,
+// and replays only the games holding all of them, to find its matches,
+// without reading the .sgf files again.
+type PatternIndex struct {
+	Paths     []string // the files of the games, sorted
+	MaxAtOnce int      // number of games replayed in parallel by Search, if 0 one
+	games     []gameMoves
+	regions   map[uint32][]regionPosting // by canonical key, the games holding the region, in order
+}
+
+// patternIndexVersion is changed when gameMoves, or the regions, change.
+const patternIndexVersion = 2
+
+// patternIndexFile is the form of a PatternIndex written to disk, gob encoded and gzip compressed.
+type patternIndexFile struct {
+	Version int
+	Paths   []string
+	Games   []gameMoves
+	Regions map[uint32][]regionPosting
+}
+
+type patternGame struct {
+	path    string
+	moves   gameMoves
+	regions []regionFirst
+}
+
+// BuildPatternIndex compiles the main line of the first game of every
+// .sgf file of the database of dbrq into a PatternIndex, and records the
+// regions of each game. A game is kept up to its first error.
+// Games on rectangular boards are kept without moves.
+// Search replays MaxAtOnce games in parallel, as ProcessDatabase
+// processes the files of dbrq.
+//
+// The games are replayed on Board, not by sgf.ParseFile with ParserPlay,
+// as ReadDirectoryAndBuildPatterns does, since sgf reports no position
+// after each move, and AddTeachingPattern only adds the whole board
+// patterns (ah.WHOLE_BOARD_PATTERN) of the opening to a pattern tree.
+func BuildPatternIndex(ctx context.Context, dbrq *DBProcessRequest) (*PatternIndex, error) {
+	defer un(trace("BuildPatternIndex"), nil)
+	games, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[patternGame, []patternGame, []patternGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) patternGame {
+			g := patternGame{path: r.FilePath(fName)}
+			trees, err := readSGF(b)
+			if len(trees) > 0 {
+				var err2 error
+				g.moves, err2 = compileGame(trees[0])
+				if err2 == errNotSquare {
+					err2 = nil
+				}
+				var n int
+				var err3 error
+				g.regions, n, err3 = gameRegions(&g.moves)
+				if err3 != nil {
+					g.moves.Ops = g.moves.Ops[:n]
+					err2 = err3
+				}
+				if err == nil {
+					err = err2
+				}
+			}
+			if err != nil {
+				r.FileError(fName, "Compiling", err)
+			}
+			return g
+		},
+		Dir: func(d []patternGame, g patternGame) []patternGame {
+			return append(d, g)
+		},
+		DB: func(res []patternGame, d []patternGame) []patternGame {
+			return append(res, d...)
+		},
+	})
+	sort.Slice(games, func(i, j int) bool { return games[i].path < games[j].path })
+	px := &PatternIndex{MaxAtOnce: dbrq.MaxAtOnce, regions: map[uint32][]regionPosting{}}
+	for i, g := range games {
+		px.Paths = append(px.Paths, g.path)
+		px.games = append(px.games, g.moves)
+		for _, rf := range g.regions {
+			px.regions[rf.key] = append(px.regions[rf.key], regionPosting{Game: int32(i), Move: rf.move})
+		}
+	}
+	return px, err
+}
+
+// candidates returns the games holding every region of keys, with the
+// first move after which they are all on the board, in order of game.
+// If keys is empty, every game is returned, from the first move.
+func (px *PatternIndex) candidates(keys []uint32) []regionPosting {
+	if len(keys) == 0 {
+		cand := make([]regionPosting, len(px.games))
+		for i := range cand {
+			cand[i].Game = int32(i)
+		}
+		return cand
+	}
+	keys = append([]uint32(nil), keys...)
+	sort.Slice(keys, func(i, j int) bool { return len(px.regions[keys[i]]) < len(px.regions[keys[j]]) })
+	cand := px.regions[keys[0]]
+	for _, k := range keys[1:] {
+		ps := px.regions[k]
+		var both []regionPosting
+		for i, j := 0, 0; i < len(cand) && j < len(ps); {
+			switch {
+			case cand[i].Game < ps[j].Game:
+				i++
+			case cand[i].Game > ps[j].Game:
+				j++
+			default:
+				both = append(both, regionPosting{Game: cand[i].Game, Move: max(cand[i].Move, ps[j].Move)})
+				i++
+				j++
+			}
+		}
+		cand = both
+	}
+	return cand
+}
+
+// Search returns every game and move where the pattern p appears,
+// under any of the eight symmetries, and with the colors reversed,
+// sorted by path and move.
+// A pattern appears when a move (or setup node) makes it match at a place
+// where it did not match before. If several variants of the pattern appear
+// at the same move and place, only the first is returned.
+// The regions of the pattern whose points are all known are looked up in
+// the index, and only the games holding all of them are replayed, MaxAtOnce
+// at a time, from the move they are all on the board. A pattern without
+// such a region, i.e. smaller than 3x3 points, is searched for in every game.
+func (px *PatternIndex) Search(ctx context.Context, p *Pattern) ([]PatternMatch, error) {
+	cand := px.candidates(p.regionKeys())
+	found := make([][]PatternMatch, len(cand))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	variants := map[int][]*patternVariant{}
+	for w := 0; w < max(px.MaxAtOnce, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(cand) {
+					return
+				}
+				gi := cand[i].Game
+				g := &px.games[gi]
+				mu.Lock()
+				vs, ok := variants[g.Size]
+				if !ok {
+					vs = p.variants(g.Size)
+					variants[g.Size] = vs
+				}
+				mu.Unlock()
+				found[i] = searchGame(px.Paths[gi], g, vs, int(cand[i].Move))
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var matches []PatternMatch
+	for _, m := range found {
+		matches = append(matches, m...)
+	}
+	return matches, nil
+}
+
+// searchGame replays the game g, and returns the moves where one of the
+// variants vs appears. Only the places of the variants which hold a point
+// changed by a move are checked after the move. No place is checked
+// before the move from, when the regions of the pattern are not all on
+// the board, so it cannot match.
+func searchGame(path string, g *gameMoves, vs []*patternVariant, from int) []PatternMatch {
+	if len(vs) == 0 {
+		return nil
+	}
+	var matches []PatternMatch
+	n := g.Size
+	// the place of variant vi with origin col, row is (vi*n+row)*n+col
+	active := make([]bool, len(vs)*n*n)   // places where a variant matches
+	checked := make([]int32, len(vs)*n*n) // places checked after the move, stamped with the move
+	reported := make([]int32, n*n)        // origins reported after the move, stamped with the move
+	stamp := int32(0)
+	var b *Board
+	g.play(func(move int, bd *Board) bool {
+		if b == nil {
+			b = bd
+			b.track = true
+			// the first call follows the moves before tracking started
+			b.changes = b.changes[:0]
+			for p := range b.points {
+				if b.points[p] != Empty {
+					b.changes = append(b.changes, p)
+				}
+			}
+		}
+		if move < from {
+			b.changes = b.changes[:0]
+			return true
+		}
+		stamp++
+		for _, p := range b.changes {
+			pc, pr := p%n, p/n
+			color := b.points[p]
+			for vi, v := range vs {
+				for dr := 0; dr < v.height; dr++ {
+					r := pr - dr
+					if v.corner && r != v.row || r < 0 || r > n-v.height {
+						continue
+					}
+					for dc := 0; dc < v.width; dc++ {
+						c := pc - dc
+						if v.corner && c != v.col || c < 0 || c > n-v.width {
+							continue
+						}
+						pl := (vi*n+r)*n + c
+						if !v.accepts(dc, dr, color) {
+							active[pl] = false
+							continue
+						}
+						if checked[pl] == stamp {
+							continue
+						}
+						checked[pl] = stamp
+						m := v.matches(b, r*n+c)
+						if m && !active[pl] && reported[r*n+c] != stamp {
+							reported[r*n+c] = stamp
+							matches = append(matches, PatternMatch{Path: path, Move: move, Col: c, Row: r, Trans: v.trans, Reversed: v.reversed})
+						}
+						active[pl] = m
+					}
+				}
+			}
+		}
+		b.changes = b.changes[:0]
+		return true
+	})
+	return matches
+}
+
+// Write writes the index to w.
+func (px *PatternIndex) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	err := gob.NewEncoder(zw).Encode(patternIndexFile{Version: patternIndexVersion, Paths: px.Paths, Games: px.games, Regions: px.regions})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadPatternIndex reads an index written by PatternIndex.Write.
+func ReadPatternIndex(r io.Reader) (*PatternIndex, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var f patternIndexFile
+	err = gob.NewDecoder(zr).Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+	if f.Version != patternIndexVersion {
+		return nil, fmt.Errorf("pattern index version %d, expected %d", f.Version, patternIndexVersion)
+	}
+	if len(f.Games) != len(f.Paths) {
+		return nil, fmt.Errorf("pattern index has %d games, and %d paths", len(f.Games), len(f.Paths))
+	}
+	if f.Regions == nil {
+		f.Regions = map[uint32][]regionPosting{}
+	}
+	return &PatternIndex{Paths: f.Paths, games: f.Games, regions: f.Regions}, nil
+}
+
+// Save writes the index to the file fileName.
+func (px *PatternIndex) Save(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = px.Write(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// LoadPatternIndex reads the index saved in the file fileName.
+func LoadPatternIndex(fileName string) (*PatternIndex, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPatternIndex(f)
+}
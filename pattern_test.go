@@ -0,0 +1,97 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestPatternSearch(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1]SZ[19];B[cc];W[pp];B[bb])",
+		"a/2.sgf": "(;GM[1]SZ[19];B[pd];W[qq])",
+		"a/3.sgf": "(;GM[1]SZ[19];W[bb];B[cc])",
+		"b/4.sgf": "(;GM[1]SZ[19];B[jj];W[kj];B[kk];W[jk];B[aa])",
+		"b/5.sgf": "(;GM[1]SZ[9];B[gg];W[cc];B[dc];W[dd];B[cd];W[ab])",
+		"b/6.sgf": "(;GM[1]SZ[19];B[cc];W[cc])",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	px, err := BuildPatternIndex(context.Background(), dbReq)
+	if err == nil {
+		t.Errorf("expected an error for b/6.sgf")
+	}
+	var buf bytes.Buffer
+	if err := px.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	px, err = ReadPatternIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rows    string
+		corner  bool
+		matches string
+	}{
+		// the 3-3 point, in an empty corner
+		{"... / ... / ..X", true, "[{a/1.sgf 1 0 0 0 false} {a/2.sgf 2 16 16 3 true} {b/5.sgf 1 6 6 3 false} {b/5.sgf 2 0 0 0 true} {b/6.sgf 1 0 0 0 false}]"},
+		// a cross cut, anywhere
+		{"XO/OX", false, "[{b/4.sgf 4 9 9 0 false} {b/5.sgf 5 2 2 0 true}]"},
+		// the same, with a don't care column: it appears at three places
+		{"XO?/OX?", false, "[{b/4.sgf 4 9 9 0 false} {b/4.sgf 4 8 9 1 true} {b/4.sgf 4 9 8 6 true} {b/5.sgf 5 2 2 0 true} {b/5.sgf 5 1 2 1 false} {b/5.sgf 5 2 1 6 false}]"},
+		{"XX/XX", false, "[]"},
+	}
+	for _, tst := range tests {
+		p, err := ParsePattern(tst.rows, tst.corner)
+		if err != nil {
+			t.Errorf("%s: %s", tst.rows, err)
+			continue
+		}
+		m, err := px.Search(context.Background(), p)
+		if err != nil {
+			t.Errorf("%s: %s", p, err)
+		}
+		if got := fmt.Sprint(m); got != tst.matches {
+			t.Errorf("%s: got %s, expected %s", p, got, tst.matches)
+		}
+	}
+
+	for _, rows := range []string{"", "??/??", "X./X", "XZ"} {
+		if _, err := ParsePattern(rows, false); err == nil {
+			t.Errorf("%q: expected an error", rows)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, _ := ParsePattern("X", false)
+	if _, err := px.Search(ctx, p); err != context.Canceled {
+		t.Errorf("cancelled search: %v", err)
+	}
+}
+
+func TestPatternSearchRegions(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1]SZ[9];B[bb];W[hh];B[db];W[hg];B[cc];W[gh];B[bd];W[gg];B[dd])",
+		"a/2.sgf": "(;GM[1]SZ[9];B[hh];W[bb];B[hg];W[db];B[gh];W[cc];B[gg];W[bd];B[aa];W[dd])",
+		"a/3.sgf": "(;GM[1]SZ[9];B[bb];W[hh];B[db];W[hg];B[ee];W[gh];B[bd];W[gg];B[dd])",
+	}
+	px, err := BuildPatternIndex(context.Background(), memDB(files, DBProcessRequest{DoMultiCPU: true, MaxAtOnce: 2, NumCPUs: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if px.MaxAtOnce != 2 {
+		t.Errorf("MaxAtOnce %d, expected 2", px.MaxAtOnce)
+	}
+	p, _ := ParsePattern("X.X/.X./X.X", false)
+	m, err := px.Search(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "[{a/1.sgf 9 1 1 0 false} {a/2.sgf 10 1 1 0 true}]"
+	if got := fmt.Sprint(m); got != want {
+		t.Errorf("got %s, expected %s", got, want)
+	}
+}
@@ -0,0 +1,350 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/players.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// A Player is a player of the games of a database: its ID, the canonical
+// name, and the other names, in other romanisations or scripts, used in PB and PW.
+type Player struct {
+	ID      string
+	Aliases []string
+}
+
+// A PlayerTable resolves the names of PB and PW to the IDs of the players.
+// Names are compared by nameKeys: without case, diacritics, punctuation,
+// long vowels written "ou", "oo", or "uu", and in any order, or split
+// differently, so "Go Seigen", "Seigen Go", "GO Seigen", and "Goseigen"
+// are the same name.
+type PlayerTable struct {
+	Players   []*Player
+	Ambiguous []*AmbiguousName // names of more than one player, in the order added
+	byKey     map[string]*Player
+	ambiguous map[string]*AmbiguousName // by the keys of the name
+}
+
+// An AmbiguousName is a name of more than one player of a PlayerTable.
+// It is not resolved.
+type AmbiguousName struct {
+	Name    string
+	Players []string // the IDs of the players
+}
+
+// NewPlayerTable returns an empty PlayerTable.
+func NewPlayerTable() *PlayerTable {
+	return &PlayerTable{byKey: make(map[string]*Player), ambiguous: make(map[string]*AmbiguousName)}
+}
+
+// nameKeys returns the forms of name compared by a PlayerTable:
+// its words sorted, and its letters in order, without spaces.
+func nameKeys(name string) [2]string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == ',' || r == '.':
+			b.WriteByte(' ')
+		}
+	}
+	words := strings.Fields(b.String())
+	for i, w := range words {
+		for _, long := range [][2]string{{"ou", "o"}, {"oo", "o"}, {"uu", "u"}} {
+			w = strings.ReplaceAll(w, long[0], long[1])
+		}
+		words[i] = w
+	}
+	compact := strings.Join(words, "")
+	sort.Strings(words)
+	return [2]string{strings.Join(words, " "), compact}
+}
+
+// lookup returns the player of the name with the keys, or nil.
+// The players of ambiguous names are found by lookupAmbiguous.
+func (pt *PlayerTable) lookup(keys [2]string) *Player {
+	if p := pt.byKey[keys[0]]; p != nil {
+		return p
+	}
+	return pt.byKey[keys[1]]
+}
+
+// lookupAmbiguous returns the ambiguous name with the keys, or nil.
+func (pt *PlayerTable) lookupAmbiguous(keys [2]string) *AmbiguousName {
+	if a := pt.ambiguous[keys[0]]; a != nil {
+		return a
+	}
+	return pt.ambiguous[keys[1]]
+}
+
+// addAmbiguous records that the name with the keys is a name of the player p,
+// and of the other players of the name. The name is no longer resolved.
+func (pt *PlayerTable) addAmbiguous(name string, keys [2]string, p *Player) {
+	a := pt.lookupAmbiguous(keys)
+	if a == nil {
+		a = &AmbiguousName{Name: name}
+		pt.Ambiguous = append(pt.Ambiguous, a)
+		if q := pt.lookup(keys); q != nil {
+			a.Players = append(a.Players, q.ID)
+		}
+	}
+	if !containsString(a.Players, p.ID) {
+		a.Players = append(a.Players, p.ID)
+	}
+	for _, key := range keys {
+		delete(pt.byKey, key)
+		pt.ambiguous[key] = a
+	}
+}
+
+// Add adds the player id, with its names. The id is a name of the player.
+// If the player is already in the table, the names are added to its aliases.
+// A name which is also a name of another player is ambiguous: it is added
+// to Ambiguous, and no longer resolved. Add fails only for an id without
+// a name.
+func (pt *PlayerTable) Add(id string, names ...string) error {
+	id = strings.TrimSpace(id)
+	if nameKeys(id)[0] == "" {
+		return fmt.Errorf("player %q: no name", id)
+	}
+	p := pt.lookup(nameKeys(id))
+	if p == nil {
+		p = &Player{ID: id}
+		pt.Players = append(pt.Players, p)
+	}
+	for _, name := range append([]string{id}, names...) {
+		name = strings.TrimSpace(name)
+		keys := nameKeys(name)
+		if keys[0] == "" {
+			continue
+		}
+		if q := pt.lookup(keys); q != nil && q != p || pt.lookupAmbiguous(keys) != nil {
+			pt.addAmbiguous(name, keys, p)
+		} else {
+			for _, key := range keys {
+				if pt.byKey[key] == nil {
+					pt.byKey[key] = p
+				}
+			}
+		}
+		if name != p.ID && !containsString(p.Aliases, name) {
+			p.Aliases = append(p.Aliases, name)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPlayerTable reads a table of aliases: a player on each line, its ID
+// followed by its other names, separated by tabs or "|", i.e.
+//
+//	Go Seigen | Wu Qingyuan | 吳清源 | 呉清源 | 吴清源
+//
+// Blank lines, and lines starting with "#", are ignored.
+// A name list, such as GoGoD's Onomasticon, is easily written in this form.
+func ReadPlayerTable(r io.Reader) (*PlayerTable, error) {
+	pt := NewPlayerTable()
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		names := strings.FieldsFunc(s, func(r rune) bool { return r == '\t' || r == '|' })
+		if len(names) == 0 {
+			continue
+		}
+		if err := pt.Add(names[0], names[1:]...); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return pt, sc.Err()
+}
+
+// LoadPlayerTable reads the table of aliases of the file fileName.
+func LoadPlayerTable(fileName string) (*PlayerTable, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPlayerTable(f)
+}
+
+// Resolve returns the ID of the player named name, i.e. the value of PB or PW.
+func (pt *PlayerTable) Resolve(name string) (id string, ok bool) {
+	if p := pt.lookup(nameKeys(name)); p != nil {
+		return p.ID, true
+	}
+	return "", false
+}
+
+// AmbiguousPlayers returns the IDs of the players named name, if the name
+// is ambiguous, and nil otherwise.
+func (pt *PlayerTable) AmbiguousPlayers(name string) []string {
+	if a := pt.lookupAmbiguous(nameKeys(name)); a != nil {
+		return a.Players
+	}
+	return nil
+}
+
+// ResolveRecord returns the IDs of the players of the game rec,
+// or their names, if not in the table.
+func (pt *PlayerTable) ResolveRecord(rec *GameRecord) (black string, white string) {
+	black, white = strings.TrimSpace(rec.PB), strings.TrimSpace(rec.PW)
+	if id, ok := pt.Resolve(black); ok {
+		black = id
+	}
+	if id, ok := pt.Resolve(white); ok {
+		white = id
+	}
+	return black, white
+}
+
+// A PlayerMatch is a name of the table close to a name not resolved.
+type PlayerMatch struct {
+	ID       string // the player
+	Name     string // the name of the player matched
+	Distance int    // edit distance between the names, compared as by Resolve
+}
+
+// levenshtein returns the number of runes to insert, delete, or replace,
+// to change a into b.
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Suggest returns the names of the table within maxDist edits of name,
+// the closest first, at most one for each player.
+func (pt *PlayerTable) Suggest(name string, maxDist int) []PlayerMatch {
+	keys := nameKeys(name)
+	best := make(map[*Player]PlayerMatch)
+	for _, p := range pt.Players {
+		for _, n := range append([]string{p.ID}, p.Aliases...) {
+			nk := nameKeys(n)
+			d := min(levenshtein(keys[0], nk[0]), levenshtein(keys[1], nk[1]))
+			if m, ok := best[p]; d <= maxDist && (!ok || d < m.Distance) {
+				best[p] = PlayerMatch{ID: p.ID, Name: n, Distance: d}
+			}
+		}
+	}
+	matches := make([]PlayerMatch, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+// An UnresolvedName is a name of PB or PW which is not in a PlayerTable,
+// or which is ambiguous.
+type UnresolvedName struct {
+	Name        string
+	Games       int           // number of games played under the name
+	Suggestions []PlayerMatch // if not ambiguous
+	Players     []string      // the IDs of the players of the name, if ambiguous
+}
+
+// Unresolved returns the names of the players of the games of ix which
+// are not in the table, and the ambiguous names of the table, played or
+// not, the most frequent first, with the names of the table within
+// maxDist edits, or the players of the ambiguous name.
+func (pt *PlayerTable) Unresolved(ix *GameIndex, maxDist int) []UnresolvedName {
+	games := make(map[string]int)
+	for _, a := range pt.Ambiguous {
+		games[a.Name] = 0
+	}
+	for i := range ix.Records {
+		for _, name := range []string{ix.Records[i].PB, ix.Records[i].PW} {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := pt.Resolve(name); !ok {
+				games[name]++
+			}
+		}
+	}
+	names := make([]UnresolvedName, 0, len(games))
+	for name, n := range games {
+		u := UnresolvedName{Name: name, Games: n}
+		if u.Players = pt.AmbiguousPlayers(name); u.Players == nil {
+			u.Suggestions = pt.Suggest(name, maxDist)
+		}
+		names = append(names, u)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Games != names[j].Games {
+			return names[i].Games > names[j].Games
+		}
+		return names[i].Name < names[j].Name
+	})
+	return names
+}
+
+// WriteUnresolved writes the names, one on each line, with the number of
+// games, and the suggested players, or the players of an ambiguous name, i.e.
+//
+//	12	Wu Qingyan	Go Seigen (Wu Qingyuan, 1)
+//	3	Jo Chihun	ambiguous	Cho Chikun	Cho Hunhyun
+func WriteUnresolved(w io.Writer, names []UnresolvedName) error {
+	bw := bufio.NewWriter(w)
+	for _, u := range names {
+		fmt.Fprintf(bw, "%d\t%s", u.Games, u.Name)
+		if len(u.Players) > 0 {
+			fmt.Fprintf(bw, "\tambiguous\t%s", strings.Join(u.Players, "\t"))
+		}
+		for _, m := range u.Suggestions {
+			fmt.Fprintf(bw, "\t%s (%s, %d)", m.ID, m.Name, m.Distance)
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,115 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	. "github.com/Ken1JF/sgfdb"
+	"strings"
+	"testing"
+)
+
+const aliases = `# players
+Go Seigen | Wu Qingyuan | 吳清源 | 呉清源 | 吴清源
+Honinbo Shusaku	Hon'inbo Shūsaku	本因坊秀策
+
+Cho Chikun | Jo Chihun | 趙治勲
+`
+
+func TestPlayerTable(t *testing.T) {
+	pt, err := ReadPlayerTable(strings.NewReader(aliases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.Players) != 3 || len(pt.Players[0].Aliases) != 4 {
+		t.Errorf("players: %v", pt.Players)
+	}
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"Go Seigen", "Go Seigen"},
+		{"Seigen Go", "Go Seigen"},
+		{" GO SEIGEN ", "Go Seigen"},
+		{"Wu Qing Yuan", "Go Seigen"},
+		{"吴清源", "Go Seigen"},
+		{"Honinbo Shuusaku", "Honinbo Shusaku"},
+		{"Hon-inbou Shusaku", "Honinbo Shusaku"},
+		{"Shusaku", ""},
+		{"Kitani Minoru", ""},
+	}
+	for _, tst := range tests {
+		id, ok := pt.Resolve(tst.name)
+		if id != tst.id || ok != (tst.id != "") {
+			t.Errorf("%q: got %q %v, expected %q", tst.name, id, ok, tst.id)
+		}
+	}
+	if _, err := ReadPlayerTable(strings.NewReader("Go Seigen\n.. | Go Seigen\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error at line 2, got %v", err)
+	}
+
+	ms := pt.Suggest("Wu Qingyan", 2)
+	if len(ms) != 1 || ms[0].ID != "Go Seigen" || ms[0].Name != "Wu Qingyuan" || ms[0].Distance != 1 {
+		t.Errorf("suggestions: %v", ms)
+	}
+	if ms := pt.Suggest("Kitani Minoru", 2); len(ms) != 0 {
+		t.Errorf("suggestions: %v", ms)
+	}
+}
+
+func TestAmbiguousPlayers(t *testing.T) {
+	pt, err := ReadPlayerTable(strings.NewReader(aliases + "Cho Hunhyun | Jo Chihun | Jo Hunhyeon\nJo Hunhyeon | Chihun Jo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pt.Players) != 4 {
+		t.Errorf("players: %v", pt.Players)
+	}
+	for _, name := range []string{"Jo Chihun", "Chihun Jo"} {
+		if id, ok := pt.Resolve(name); ok {
+			t.Errorf("%q: resolved to %q", name, id)
+		}
+	}
+	for _, name := range []string{"Cho Chikun", "Jo Hunhyeon"} {
+		if _, ok := pt.Resolve(name); !ok {
+			t.Errorf("%q: not resolved", name)
+		}
+	}
+	if len(pt.Ambiguous) != 1 || pt.Ambiguous[0].Name != "Jo Chihun" {
+		t.Fatalf("ambiguous: %v", pt.Ambiguous)
+	}
+	if ids := pt.AmbiguousPlayers("jo chihun"); strings.Join(ids, ",") != "Cho Chikun,Cho Hunhyun" {
+		t.Errorf("players of Jo Chihun: %v", ids)
+	}
+
+	ix := &GameIndex{Records: []GameRecord{{Path: "1.sgf", PB: "Jo Chihun", PW: "Cho Hunhyun"}}}
+	var buf bytes.Buffer
+	if err := WriteUnresolved(&buf, pt.Unresolved(ix, 2)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1\tJo Chihun\tambiguous\tCho Chikun\tCho Hunhyun\n"
+	if buf.String() != expected {
+		t.Errorf("got\n%sexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestUnresolvedPlayers(t *testing.T) {
+	pt, err := ReadPlayerTable(strings.NewReader(aliases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix := &GameIndex{Records: []GameRecord{
+		{Path: "1.sgf", PB: "Go Seigen", PW: "Kitani Minoru"},
+		{Path: "2.sgf", PB: "Kitani Minoru", PW: "Wu Qingyan"},
+		{Path: "3.sgf", PB: "Cho Chikun", PW: ""},
+	}}
+	if b, w := pt.ResolveRecord(&ix.Records[1]); b != "Kitani Minoru" || w != "Wu Qingyan" {
+		t.Errorf("ResolveRecord: %q %q", b, w)
+	}
+	var buf bytes.Buffer
+	if err := WriteUnresolved(&buf, pt.Unresolved(ix, 2)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2\tKitani Minoru\n1\tWu Qingyan\tGo Seigen (Wu Qingyuan, 1)\n"
+	if buf.String() != expected {
+		t.Errorf("got\n%sexpected\n%s", buf.String(), expected)
+	}
+}
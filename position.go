@@ -0,0 +1,219 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/position.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// PositionEntry records that the game File of a PositionIndex
+// reaches the position with hash Hash after Move moves.
+type PositionEntry struct {
+	Hash uint64
+	File int32
+	Move int32
+}
+
+// PositionMatch is a game, and the move after which it reaches a position.
+type PositionMatch struct {
+	Path string
+	Move int
+}
+
+// PositionIndex holds the positions of the main line of every game of a
+// database, by their Zobrist hash under the eight symmetries (Board.Hash).
+// Equal hashes are taken as equal positions; with 64 bit hashes,
+// a false match is very unlikely.
+type PositionIndex struct {
+	Paths   []string        // the files of the games, sorted
+	Entries []PositionEntry // sorted by Hash, File, and Move
+}
+
+// positionGame holds the hashes of the positions of one game.
+type positionGame struct {
+	path   string
+	hashes []uint64
+	moves  []int32
+}
+
+// positionIndexVersion is changed when the hashes or the PositionEntry change.
+const positionIndexVersion = 1
+
+// positionIndexFile is the form of a PositionIndex written to disk, gob encoded and gzip compressed.
+type positionIndexFile struct {
+	Version int
+	Paths   []string
+	Entries []PositionEntry
+}
+
+// BuildPositionIndex replays the main line of the first game of every
+// .sgf file of the database of dbrq, and returns a PositionIndex of the
+// positions after each move, and after setup stones.
+// Games on rectangular boards are not indexed.
+func BuildPositionIndex(ctx context.Context, dbrq *DBProcessRequest) (*PositionIndex, error) {
+	defer un(trace("BuildPositionIndex"), nil)
+	games, err := ProcessDatabaseReduce(ctx, dbrq, Reducer[positionGame, []positionGame, []positionGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) positionGame {
+			g := positionGame{path: r.FilePath(fName)}
+			trees, err := readSGF(b)
+			if len(trees) > 0 {
+				err2 := replay(trees[0], func(move int, bd *Board) bool {
+					g.hashes = append(g.hashes, bd.Hash())
+					g.moves = append(g.moves, int32(move))
+					return true
+				})
+				if err == nil && err2 != errNotSquare {
+					err = err2
+				}
+			}
+			if err != nil {
+				r.FileError(fName, "Replaying", err)
+			}
+			return g
+		},
+		Dir: func(d []positionGame, g positionGame) []positionGame {
+			return append(d, g)
+		},
+		DB: func(res []positionGame, d []positionGame) []positionGame {
+			return append(res, d...)
+		},
+	})
+	sort.Slice(games, func(i, j int) bool { return games[i].path < games[j].path })
+	px := new(PositionIndex)
+	for i, g := range games {
+		px.Paths = append(px.Paths, g.path)
+		for j, h := range g.hashes {
+			px.Entries = append(px.Entries, PositionEntry{Hash: h, File: int32(i), Move: g.moves[j]})
+		}
+	}
+	sort.Slice(px.Entries, func(i, j int) bool {
+		a, b := &px.Entries[i], &px.Entries[j]
+		if a.Hash != b.Hash {
+			return a.Hash < b.Hash
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Move < b.Move
+	})
+	return px, err
+}
+
+// LookupHash returns the games reaching the position with hash h,
+// sorted by path and move.
+// A game reaching the position more than once, i.e. after a pass,
+// is returned once for each move.
+func (px *PositionIndex) LookupHash(h uint64) []PositionMatch {
+	var matches []PositionMatch
+	i := sort.Search(len(px.Entries), func(i int) bool { return px.Entries[i].Hash >= h })
+	for ; i < len(px.Entries) && px.Entries[i].Hash == h; i++ {
+		e := &px.Entries[i]
+		matches = append(matches, PositionMatch{Path: px.Paths[e.File], Move: int(e.Move)})
+	}
+	return matches
+}
+
+// Lookup returns the games reaching the position of b,
+// under any of the eight symmetries.
+func (px *PositionIndex) Lookup(b *Board) []PositionMatch {
+	return px.LookupHash(b.Hash())
+}
+
+// LookupSGF returns the games reaching the position at the end of the
+// main line of the SGF fragment, i.e. "(;SZ[19]AB[pd][dp]AW[dd])" or
+// ";B[pd];W[dd];B[pp]". The board size is 19 if SZ is missing.
+func (px *PositionIndex) LookupSGF(fragment []byte) ([]PositionMatch, error) {
+	b, err := BoardFromSGF(fragment)
+	if err != nil {
+		return nil, err
+	}
+	return px.Lookup(b), nil
+}
+
+// BoardFromSGF returns the board at the end of the main line of the SGF
+// fragment, which may omit the parentheses of a GameTree.
+func BoardFromSGF(fragment []byte) (*Board, error) {
+	if bytes.IndexByte(fragment, '(') < 0 {
+		fragment = append(append([]byte("("), fragment...), ')')
+	}
+	trees, err := readSGF(fragment)
+	if err != nil {
+		return nil, err
+	}
+	nodes := trees[0].mainLine()
+	size, _ := boardSize(nodes[0].value("SZ"))
+	last := NewBoard(size)
+	err = replay(trees[0], func(move int, b *Board) bool {
+		last = b
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, fmt.Errorf("bad board size SZ[%s]", nodes[0].value("SZ"))
+	}
+	return last, nil
+}
+
+// Write writes the index to w.
+func (px *PositionIndex) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	err := gob.NewEncoder(zw).Encode(positionIndexFile{Version: positionIndexVersion, Paths: px.Paths, Entries: px.Entries})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadPositionIndex reads an index written by PositionIndex.Write.
+func ReadPositionIndex(r io.Reader) (*PositionIndex, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var f positionIndexFile
+	err = gob.NewDecoder(zr).Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+	if f.Version != positionIndexVersion {
+		return nil, fmt.Errorf("position index version %d, expected %d", f.Version, positionIndexVersion)
+	}
+	return &PositionIndex{Paths: f.Paths, Entries: f.Entries}, nil
+}
+
+// Save writes the index to the file fileName.
+func (px *PositionIndex) Save(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = px.Write(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// LoadPositionIndex reads the index saved in the file fileName.
+func LoadPositionIndex(fileName string) (*PositionIndex, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadPositionIndex(f)
+}
@@ -0,0 +1,63 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestPositionIndex(t *testing.T) {
+	files := map[string]string{
+		// the same opening, in a different move order and orientation
+		"a/1.sgf": "(;GM[1]SZ[19];B[pd];W[dp];B[pp];W[dd];B[fc])",
+		"a/2.sgf": "(;GM[1]SZ[19];B[dp];W[pd];B[dd];W[pp];B[qn])",
+		"b/3.sgf": "(;GM[1]SZ[19]HA[2]AB[pd][dp];W[pp];B[qn])",
+		"b/4.sgf": "(;GM[1]SZ[13:19];B[aa])",
+		"b/5.sgf": "(;GM[1]SZ[19];B[pd];W[pd])",
+	}
+	dbReq := memDB(files, DBProcessRequest{})
+	px, err := BuildPositionIndex(context.Background(), dbReq)
+	if err == nil {
+		t.Errorf("expected an error for b/5.sgf")
+	}
+
+	tests := []struct {
+		fragment string
+		matches  string
+	}{
+		{";B[pd];W[dp];B[pp];W[dd]", "[{a/1.sgf 4} {a/2.sgf 4}]"},
+		{"(;SZ[19]AB[pd][pp]AW[dp][dd])", "[{a/1.sgf 4} {a/2.sgf 4}]"},
+		{";B[dd]", "[{a/1.sgf 1} {a/2.sgf 1} {b/5.sgf 1}]"},
+		{";B[pd];W[dp];B[pp]", "[{a/1.sgf 3} {a/2.sgf 3}]"},
+		{"(;AB[dp][pd];W[pp])", "[{b/3.sgf 1}]"},
+		{";B[qd]", "[]"},
+		{"(;SZ[9];B[ee])", "[]"},
+	}
+	for _, tst := range tests {
+		m, err := px.LookupSGF([]byte(tst.fragment))
+		if err != nil {
+			t.Errorf("%s: %s", tst.fragment, err)
+			continue
+		}
+		if got := fmt.Sprint(m); got != tst.matches {
+			t.Errorf("%s: got %s, expected %s", tst.fragment, got, tst.matches)
+		}
+	}
+	if _, err := px.LookupSGF([]byte(";B[zz")); err == nil {
+		t.Errorf("expected an error for a bad fragment")
+	}
+
+	var buf bytes.Buffer
+	if err := px.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	px2, err := ReadPositionIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(px2.Entries) != len(px.Entries) || len(px2.Paths) != 5 {
+		t.Errorf("read back %d entries, %d paths", len(px2.Entries), len(px2.Paths))
+	}
+}
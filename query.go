@@ -0,0 +1,358 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/query.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Query is a filter expression over the records of a GameIndex, i.e.
+//
+//	PB~"Honinbo Shusaku" AND DT>=1840 AND RE=B+*
+//	HA=0 AND BR=9p AND WR=9p AND KM=6.5 AND DT>=2005 AND DT<=2010
+//
+// A comparison is a field, an operator and a value.
+// The fields are the IndexedProperties, MV for the number of moves,
+// and PATH for the path of the file.
+// The operators are:
+//
+//	=  !=          equal, ignoring case; * and ? in the value match any text, or one character
+//	~  !~          contains the value, ignoring case
+//	<  <=  >  >=   numeric if both sides are numbers, else text;
+//	               DT is compared on the length of the value, so DT<=2010 includes 2010-12-31
+//
+// A value with spaces or parentheses is written in double quotes.
+// A missing HA is taken as 0.
+// Comparisons are combined with NOT, AND, OR (binding in that order) and parentheses.
+type Query struct {
+	src  string
+	root queryExpr
+}
+
+// queryExpr is a node of a parsed Query.
+type queryExpr interface {
+	match(rec *GameRecord) bool
+}
+
+type queryAnd struct{ l, r queryExpr }
+type queryOr struct{ l, r queryExpr }
+type queryNot struct{ e queryExpr }
+
+type queryCompare struct {
+	field string
+	op    string
+	value string
+}
+
+func (q queryAnd) match(rec *GameRecord) bool { return q.l.match(rec) && q.r.match(rec) }
+func (q queryOr) match(rec *GameRecord) bool  { return q.l.match(rec) || q.r.match(rec) }
+func (q queryNot) match(rec *GameRecord) bool { return !q.e.match(rec) }
+
+// QueryError reports an error in a Query, at byte Offset of the expression.
+type QueryError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// ParseQuery parses a filter expression.
+func ParseQuery(s string) (*Query, error) {
+	p := queryParser{s: s}
+	p.next()
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected " + strconv.Quote(p.tok))
+	}
+	return &Query{src: s, root: e}, nil
+}
+
+// String returns the expression of the Query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether the record is selected by the Query.
+func (q *Query) Match(rec *GameRecord) bool {
+	return q.root.match(rec)
+}
+
+// Select returns the records of the index selected by q, in index order.
+func (ix *GameIndex) Select(q *Query) []*GameRecord {
+	var recs []*GameRecord
+	for i := range ix.Records {
+		if q.Match(&ix.Records[i]) {
+			recs = append(recs, &ix.Records[i])
+		}
+	}
+	return recs
+}
+
+// queryField returns the value of a query field of the record.
+func queryField(rec *GameRecord, field string) string {
+	switch field {
+	case "MV":
+		return strconv.Itoa(rec.Moves)
+	case "PATH":
+		return rec.Path
+	case "HA":
+		if rec.HA == "" {
+			return "0"
+		}
+	}
+	return rec.Property(field)
+}
+
+func isQueryField(field string) bool {
+	if field == "MV" || field == "PATH" {
+		return true
+	}
+	for _, id := range IndexedProperties {
+		if id == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (q queryCompare) match(rec *GameRecord) bool {
+	v := queryField(rec, q.field)
+	switch q.op {
+	case "=":
+		return globMatch(strings.ToLower(q.value), strings.ToLower(v))
+	case "!=":
+		return !globMatch(strings.ToLower(q.value), strings.ToLower(v))
+	case "~":
+		return strings.Contains(strings.ToLower(v), strings.ToLower(q.value))
+	case "!~":
+		return !strings.Contains(strings.ToLower(v), strings.ToLower(q.value))
+	}
+	var c int
+	x, err1 := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	y, err2 := strconv.ParseFloat(q.value, 64)
+	if err1 == nil && err2 == nil && q.field != "DT" {
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	} else {
+		if v == "" {
+			return false // a missing value is not ordered
+		}
+		if q.field == "DT" && len(v) > len(q.value) {
+			v = v[:len(q.value)]
+		}
+		c = strings.Compare(v, q.value)
+	}
+	switch q.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// globMatch reports whether s matches pattern, where * matches any text,
+// and ? matches one character.
+func globMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			_, n := utf8.DecodeRuneInString(s)
+			pattern, s = pattern[1:], s[n:]
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// queryParser holds the state of parsing a Query.
+// tok is the current token, "" at the end, and quoted is set for a quoted value.
+type queryParser struct {
+	s      string
+	pos    int
+	start  int
+	tok    string
+	quoted bool
+	err    error
+}
+
+func (p *queryParser) errorf(msg string) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &QueryError{Offset: p.start, Msg: msg}
+}
+
+// next reads the next token: a parenthesis, an operator,
+// a quoted value, or a word.
+func (p *queryParser) next() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	p.start = p.pos
+	p.quoted = false
+	if p.pos >= len(p.s) {
+		p.tok = ""
+		return
+	}
+	c := p.s[p.pos]
+	switch {
+	case c == '(' || c == ')' || c == '=' || c == '~':
+		p.pos++
+	case c == '<' || c == '>' || c == '!':
+		p.pos++
+		if p.pos < len(p.s) && (p.s[p.pos] == '=' || (c == '!' && p.s[p.pos] == '~')) {
+			p.pos++
+		}
+	case c == '"':
+		p.quoted = true
+		var v []byte
+		p.pos++
+		for p.pos < len(p.s) && p.s[p.pos] != '"' {
+			if p.s[p.pos] == '\\' && p.pos+1 < len(p.s) {
+				p.pos++
+			}
+			v = append(v, p.s[p.pos])
+			p.pos++
+		}
+		if p.pos >= len(p.s) {
+			p.err = &QueryError{Offset: p.start, Msg: "missing \" at end of value"}
+			p.tok = ""
+			return
+		}
+		p.pos++
+		p.tok = string(v)
+		return
+	default:
+		for p.pos < len(p.s) && !unicode.IsSpace(rune(p.s[p.pos])) && !strings.ContainsRune("()=~<>!\"", rune(p.s[p.pos])) {
+			p.pos++
+		}
+	}
+	p.tok = p.s[p.start:p.pos]
+}
+
+// keyword reports whether the current token is the unquoted keyword kw.
+func (p *queryParser) keyword(kw string) bool {
+	return !p.quoted && strings.EqualFold(p.tok, kw)
+}
+
+func (p *queryParser) or() (queryExpr, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = queryOr{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) and() (queryExpr, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		p.next()
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = queryAnd{l, r}
+	}
+	return l, nil
+}
+
+func (p *queryParser) not() (queryExpr, error) {
+	if p.keyword("NOT") {
+		p.next()
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{e}, nil
+	}
+	if !p.quoted && p.tok == "(" {
+		p.next()
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.quoted || p.tok != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.next()
+		return e, nil
+	}
+	return p.compare()
+}
+
+func (p *queryParser) compare() (queryExpr, error) {
+	if p.tok == "" || p.quoted {
+		return nil, p.errorf("expected a field")
+	}
+	field := strings.ToUpper(p.tok)
+	if !isQueryField(field) {
+		return nil, p.errorf("unknown field " + strconv.Quote(p.tok))
+	}
+	p.next()
+	op := p.tok
+	switch {
+	case p.quoted:
+		return nil, p.errorf("expected an operator after " + field)
+	case op == "=" || op == "!=" || op == "~" || op == "!~" || op == "<" || op == "<=" || op == ">" || op == ">=":
+	default:
+		return nil, p.errorf("expected an operator after " + field)
+	}
+	p.next()
+	if p.err != nil {
+		return nil, p.err
+	}
+	if !p.quoted && (p.tok == "" || p.tok == "(" || p.tok == ")") {
+		return nil, p.errorf("expected a value after " + field + op)
+	}
+	e := queryCompare{field: field, op: op, value: p.tok}
+	p.next()
+	return e, p.err
+}
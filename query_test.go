@@ -0,0 +1,51 @@
+package sgfdb_test
+
+import (
+	. "github.com/Ken1JF/sgfdb"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	ix := GameIndex{Records: []GameRecord{
+		{Path: "1846/a.sgf", PB: "Honinbo Shusaku", PW: "Gennan Inseki", DT: "1846-09-11", RE: "B+2", Moves: 325},
+		{Path: "2007/b.sgf", PB: "Lee Sedol", BR: "9p", PW: "Cho Hunhyun", WR: "9p", DT: "2007-03-01", RE: "W+R", KM: "6.5", Moves: 180},
+		{Path: "2007/c.sgf", PB: "Lee Sedol", BR: "9p", PW: "Cho Chikun", WR: "9p", DT: "2010-12-31", RE: "B+0.5", KM: "6.5", HA: "0", Moves: 250},
+		{Path: "2011/d.sgf", PB: "Amateur", BR: "1d", PW: "Cho Chikun", WR: "9p", DT: "2011", RE: "B+R", HA: "3", Moves: 120},
+	}}
+	tests := []struct {
+		expr  string
+		paths string
+	}{
+		{`PB~"Honinbo Shusaku" AND DT>=1840 AND RE=B+*`, "1846/a.sgf"},
+		{`HA=0 AND BR=9p AND WR=9p AND KM=6.5 AND DT>=2005 AND DT<=2010`, "2007/b.sgf 2007/c.sgf"},
+		{`pw~cho and not (re=w+* or mv<200)`, "2007/c.sgf"},
+		{`HA>=2 OR PB="honinbo shusaku"`, "1846/a.sgf 2011/d.sgf"},
+		{`KM>6`, "2007/b.sgf 2007/c.sgf"},
+		{`KM<6`, ""},
+		{`RE!=B+? AND PATH=2007/*`, "2007/b.sgf 2007/c.sgf"},
+		{`PW!~Cho`, "1846/a.sgf"},
+	}
+	for _, tst := range tests {
+		q, err := ParseQuery(tst.expr)
+		if err != nil {
+			t.Errorf("%s: %s", tst.expr, err)
+			continue
+		}
+		paths := ""
+		for _, rec := range ix.Select(q) {
+			if paths != "" {
+				paths += " "
+			}
+			paths += rec.Path
+		}
+		if paths != tst.paths {
+			t.Errorf("%s: got %q, expected %q", tst.expr, paths, tst.paths)
+		}
+	}
+
+	for _, expr := range []string{``, `XX=1`, `PB`, `PB=`, `PB="open`, `(PB=a`, `PB=a PW=b`, `PB=a AND`} {
+		if _, err := ParseQuery(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
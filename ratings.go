@@ -0,0 +1,395 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/ratings.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// RatingMethod is the method of BuildRatings.
+type RatingMethod int
+
+const (
+	// Elo rates the games one at a time, in the order of their dates.
+	Elo RatingMethod = iota
+	// BradleyTerry fits a rating for each player and year to all the games
+	// at once, the ratings of a player changing little from year to year,
+	// as in Whole-History Rating.
+	BradleyTerry
+)
+
+func (m RatingMethod) String() string {
+	switch m {
+	case Elo:
+		return "Elo"
+	case BradleyTerry:
+		return "Bradley-Terry"
+	}
+	return "RatingMethod(" + strconv.Itoa(int(m)) + ")"
+}
+
+// RatingOptions select the games, and the method, of BuildRatings.
+// The advantage of Black in a game, in points, is the FairKomi less the
+// komi, plus PointsPerStone for each handicap stone after the first,
+// and is worth EloPerStone for PointsPerStone points.
+// Games without KM are taken as played with the FairKomi, or with 0.5
+// in handicap games.
+type RatingOptions struct {
+	Method  RatingMethod
+	Players *PlayerTable // resolves PB and PW to the IDs of the players; the names are the IDs if nil
+	Filter  *Query       // the games, i.e. BR=*p AND WR=*p; all if nil
+
+	Initial        float64 // rating of a new player, 1500 if 0
+	K              float64 // Elo: change of rating for a result against expectation, 16 if 0
+	FairKomi       float64 // komi of an even game, 7 if 0
+	PointsPerStone float64 // value of a handicap stone in points, 14 if 0
+	EloPerStone    float64 // value of a handicap stone in rating, 100 if 0
+	Drift          float64 // Bradley-Terry: standard deviation of the change of a rating in a year, 70 if 0
+	Prior          float64 // Bradley-Terry: standard deviation of the first rating of a player about Initial, 400 if 0
+	Iterations     int     // Bradley-Terry: maximum number of iterations, 100 if 0
+	MinGames       int     // players of fewer rated games are dropped from the Ratings
+}
+
+func (opts *RatingOptions) setDefaults() {
+	def := func(v *float64, d float64) {
+		if *v == 0 {
+			*v = d
+		}
+	}
+	def(&opts.Initial, 1500)
+	def(&opts.K, 16)
+	def(&opts.FairKomi, 7)
+	def(&opts.PointsPerStone, 14)
+	def(&opts.EloPerStone, 100)
+	def(&opts.Drift, 70)
+	def(&opts.Prior, 400)
+	if opts.Iterations <= 0 {
+		opts.Iterations = 100
+	}
+}
+
+// advantage returns the advantage of Black in the game g, in rating.
+func (opts *RatingOptions) advantage(g *playerGame) float64 {
+	komi := opts.FairKomi
+	if g.handicap >= 2 {
+		komi = 0.5
+	}
+	if g.komi != "" {
+		komi, _ = strconv.ParseFloat(g.komi, 64)
+	}
+	points := opts.FairKomi - komi
+	if g.handicap >= 2 {
+		points += float64(g.handicap-1) * opts.PointsPerStone
+	}
+	return points * opts.EloPerStone / opts.PointsPerStone
+}
+
+// RatingPoint is the rating of a player at a date.
+type RatingPoint struct {
+	Date   string  // the date of the games, or the year, for BradleyTerry
+	Rating float64 // the rating after the games of the date
+	Games  int     // the number of games of the player, up to the date
+}
+
+// PlayerRating is the rating of a player, and its history.
+type PlayerRating struct {
+	ID      string
+	Rating  float64 // the last rating
+	Games   int     // the number of games rated
+	History []RatingPoint
+}
+
+// At returns the rating of the player at the date, i.e. of a game:
+// the rating of the last point of its history not after the date.
+func (pr *PlayerRating) At(date string) (float64, bool) {
+	i := sort.Search(len(pr.History), func(i int) bool { return pr.History[i].Date > date })
+	if i == 0 {
+		return 0, false
+	}
+	return pr.History[i-1].Rating, true
+}
+
+// Ratings are the ratings of the players of a database, the highest rated first.
+type Ratings struct {
+	Method  RatingMethod
+	Players []*PlayerRating
+	Games   int // games rated
+	Skipped int // games without a date, both players, or a result
+	byID    map[string]*PlayerRating
+}
+
+// Player returns the rating of the player id, or nil.
+func (rt *Ratings) Player(id string) *PlayerRating {
+	return rt.byID[id]
+}
+
+// ratedGame is a game rated by BuildRatings.
+type ratedGame struct {
+	black, white string
+	date         string
+	score        float64 // 1 if Black won, 0.5 for a draw, 0 if White won
+	adv          float64 // advantage of Black
+}
+
+// BuildRatings reads the games of the database of dbrq, and returns the
+// ratings of their players, by Elo or BradleyTerry.
+// The games are those with both players, a date, and a winner, or a draw.
+func BuildRatings(ctx context.Context, dbrq *DBProcessRequest, opts RatingOptions) (*Ratings, error) {
+	defer un(trace("BuildRatings"), nil)
+	games, err := readPlayerGames(ctx, dbrq, opts.Filter, opts.Players)
+	return rateGames(games, opts), err
+}
+
+// rateGames returns the ratings of the players of games.
+func rateGames(games []*playerGame, opts RatingOptions) *Ratings {
+	opts.setDefaults()
+	rt := &Ratings{Method: opts.Method}
+	var rgs []ratedGame
+	for _, g := range games {
+		rg := ratedGame{black: g.black, white: g.white, date: g.date, adv: opts.advantage(g)}
+		switch {
+		case g.black == "" || g.white == "" || g.black == g.white || g.date == "":
+			rt.Skipped++
+			continue
+		case g.draw:
+			rg.score = 0.5
+		case g.winner == Black:
+			rg.score = 1
+		case g.winner == White:
+			rg.score = 0
+		default:
+			rt.Skipped++
+			continue
+		}
+		rgs = append(rgs, rg)
+	}
+	sort.SliceStable(rgs, func(i, j int) bool {
+		a, b := &rgs[i], &rgs[j]
+		if a.date != b.date {
+			return a.date < b.date
+		}
+		if a.black != b.black {
+			return a.black < b.black
+		}
+		return a.white < b.white
+	})
+	rt.Games = len(rgs)
+	switch opts.Method {
+	case BradleyTerry:
+		rt.Players = rateBradleyTerry(rgs, &opts)
+	default:
+		rt.Players = rateElo(rgs, &opts)
+	}
+	rt.byID = make(map[string]*PlayerRating)
+	players := rt.Players[:0]
+	for _, pr := range rt.Players {
+		if pr.Games >= opts.MinGames {
+			players = append(players, pr)
+			rt.byID[pr.ID] = pr
+		}
+	}
+	rt.Players = players
+	sort.Slice(rt.Players, func(i, j int) bool {
+		if rt.Players[i].Rating != rt.Players[j].Rating {
+			return rt.Players[i].Rating > rt.Players[j].Rating
+		}
+		return rt.Players[i].ID < rt.Players[j].ID
+	})
+	return rt
+}
+
+// expected returns the expected score of a player rated d above the opponent.
+func expected(d float64) float64 {
+	return 1 / (1 + math.Pow(10, -d/400))
+}
+
+// rateElo rates the games, in order.
+func rateElo(games []ratedGame, opts *RatingOptions) []*PlayerRating {
+	players := make(map[string]*PlayerRating)
+	var list []*PlayerRating
+	player := func(id string) *PlayerRating {
+		pr := players[id]
+		if pr == nil {
+			pr = &PlayerRating{ID: id, Rating: opts.Initial}
+			players[id] = pr
+			list = append(list, pr)
+		}
+		return pr
+	}
+	record := func(pr *PlayerRating, date string) {
+		pr.Games++
+		if n := len(pr.History); n > 0 && pr.History[n-1].Date == date {
+			pr.History[n-1] = RatingPoint{date, pr.Rating, pr.Games}
+			return
+		}
+		pr.History = append(pr.History, RatingPoint{date, pr.Rating, pr.Games})
+	}
+	for i := range games {
+		g := &games[i]
+		b, w := player(g.black), player(g.white)
+		e := expected(b.Rating - w.Rating + g.adv)
+		d := opts.K * (g.score - e)
+		b.Rating += d
+		w.Rating -= d
+		record(b, g.date)
+		record(w, g.date)
+	}
+	return list
+}
+
+// rateBradleyTerry fits a rating for each player and year of its games,
+// maximizing the likelihood of the results, with a prior of the
+// first rating of each player about Initial, and of the changes from
+// year to year, by Newton's method, one rating at a time.
+// The ratings are in natural units, 400/ln(10) Elo, during the fit.
+func rateBradleyTerry(games []ratedGame, opts *RatingOptions) []*PlayerRating {
+	const unit = 400 / math.Ln10
+	type param struct {
+		player  *PlayerRating
+		year    int
+		games   []int // indices of the games
+		prev    int   // index of the year before, of the same player, or -1
+		next    int
+		varPrev float64 // variance of the change from the year before
+		varNext float64
+	}
+	var params []param
+	index := make(map[string]map[int]int) // player, year -> param
+	var list []*PlayerRating
+	for _, g := range games {
+		for _, id := range []string{g.black, g.white} {
+			if index[id] == nil {
+				index[id] = make(map[int]int)
+				list = append(list, &PlayerRating{ID: id})
+			}
+		}
+	}
+	byID := make(map[string]*PlayerRating, len(list))
+	for _, pr := range list {
+		byID[pr.ID] = pr
+	}
+	gameParams := make([][2]int, len(games))
+	for i, g := range games {
+		y := gameYear(g.date)
+		for c, id := range []string{g.black, g.white} {
+			k, ok := index[id][y]
+			if !ok {
+				k = len(params)
+				params = append(params, param{player: byID[id], year: y, prev: -1, next: -1})
+				index[id][y] = k
+			}
+			params[k].games = append(params[k].games, i)
+			gameParams[i][c] = k
+		}
+	}
+	// link the years of each player
+	drift := opts.Drift / unit
+	for _, pr := range list {
+		years := make([]int, 0, len(index[pr.ID]))
+		for y := range index[pr.ID] {
+			years = append(years, y)
+		}
+		sort.Ints(years)
+		for i := 1; i < len(years); i++ {
+			a, b := index[pr.ID][years[i-1]], index[pr.ID][years[i]]
+			v := drift * drift * float64(years[i]-years[i-1])
+			params[a].next, params[a].varNext = b, v
+			params[b].prev, params[b].varPrev = a, v
+		}
+	}
+	prior := (opts.Prior / unit) * (opts.Prior / unit)
+	r := make([]float64, len(params))
+	for it := 0; it < opts.Iterations; it++ {
+		change := 0.0
+		for k := range params {
+			p := &params[k]
+			g, h := 0.0, 0.0
+			for _, i := range p.games {
+				gm := &games[i]
+				bk, wk := gameParams[i][0], gameParams[i][1]
+				e := 1 / (1 + math.Exp(-(r[bk] - r[wk] + gm.adv/unit)))
+				if bk == k {
+					g += gm.score - e
+				} else {
+					g -= gm.score - e
+				}
+				h -= e * (1 - e)
+			}
+			if p.prev >= 0 {
+				g -= (r[k] - r[p.prev]) / p.varPrev
+				h -= 1 / p.varPrev
+			} else {
+				g -= r[k] / prior
+				h -= 1 / prior
+			}
+			if p.next >= 0 {
+				g -= (r[k] - r[p.next]) / p.varNext
+				h -= 1 / p.varNext
+			}
+			d := g / h
+			r[k] -= d
+			change = max(change, math.Abs(d))
+		}
+		if change < 1e-6 {
+			break
+		}
+	}
+	for k := range params {
+		p := &params[k]
+		p.player.History = append(p.player.History, RatingPoint{
+			Date:   strconv.Itoa(p.year),
+			Rating: opts.Initial + r[k]*unit,
+			Games:  len(p.games),
+		})
+	}
+	for _, pr := range list {
+		sort.Slice(pr.History, func(i, j int) bool { return pr.History[i].Date < pr.History[j].Date })
+		for i := range pr.History {
+			pr.Games += pr.History[i].Games
+			pr.History[i].Games = pr.Games
+		}
+		pr.Rating = pr.History[len(pr.History)-1].Rating
+	}
+	return list
+}
+
+// WriteText writes the rating of each player, highest first, with its history if history is set.
+func (rt *Ratings) WriteText(w io.Writer, history bool) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s ratings of %d players, %d games rated, %d skipped\n", rt.Method, len(rt.Players), rt.Games, rt.Skipped)
+	for i, pr := range rt.Players {
+		fmt.Fprintf(bw, "%4d %6.0f %6d  %s\n", i+1, pr.Rating, pr.Games, pr.ID)
+		if history {
+			for _, pt := range pr.History {
+				fmt.Fprintf(bw, "            %s %6.0f %6d\n", pt.Date, pt.Rating, pt.Games)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteCSV writes the rating histories of the players, a line for each point,
+// after a line of column names.
+func (rt *Ratings) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "date", "rating", "games"})
+	for _, pr := range rt.Players {
+		for _, pt := range pr.History {
+			cw.Write([]string{pr.ID, pt.Date, strconv.FormatFloat(pt.Rating, 'f', 1, 64), strconv.Itoa(pt.Games)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
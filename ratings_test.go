@@ -0,0 +1,113 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"math"
+	"strings"
+	"testing"
+)
+
+// ratingsDB returns a request to process the games of A, B, and C, from 2000 to 2002:
+// A beats B three games in four, and B beats C three games in four.
+func ratingsDB() *DBProcessRequest {
+	files := map[string]string{}
+	n := 0
+	add := func(pb, pw, dt, re string) {
+		n++
+		files[fmt.Sprintf("g/%03d.sgf", n)] = fmt.Sprintf("(;GM[1]SZ[19]PB[%s]PW[%s]DT[%s]RE[%s]KM[7];B[pd])", pb, pw, dt, re)
+	}
+	for y := 2000; y <= 2002; y++ {
+		for i := 0; i < 4; i++ {
+			dt := fmt.Sprintf("%d-%02d-01", y, i+1)
+			pair := func(p1, p2 string) {
+				re := "B+R"
+				if i == 3 {
+					re = "W+R"
+				}
+				if i%2 == 0 {
+					add(p1, p2, dt, re)
+				} else {
+					add(p2, p1, dt, strings.NewReplacer("B", "W", "W", "B").Replace(re))
+				}
+			}
+			pair("A", "B")
+			pair("B", "C")
+		}
+	}
+	add("A", "C", "", "B+R") // no date
+	add("A", "C", "2003", "?")
+	return memDB(files, DBProcessRequest{})
+}
+
+func TestBuildRatings(t *testing.T) {
+	for _, method := range []RatingMethod{Elo, BradleyTerry} {
+		dbrq := ratingsDB()
+		rt, err := BuildRatings(context.Background(), dbrq, RatingOptions{Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.Games != 24 || rt.Skipped != 2 || len(rt.Players) != 3 {
+			t.Fatalf("%s: %d games, %d skipped, %d players", method, rt.Games, rt.Skipped, len(rt.Players))
+		}
+		a, b, c := rt.Player("A"), rt.Player("B"), rt.Player("C")
+		if rt.Players[0] != a || rt.Players[2] != c || a.Rating <= b.Rating || b.Rating <= c.Rating {
+			t.Errorf("%s: A %.0f, B %.0f, C %.0f", method, a.Rating, b.Rating, c.Rating)
+		}
+		if b.Games != 24 || a.Games != 12 {
+			t.Errorf("%s: games A %d, B %d", method, a.Games, b.Games)
+		}
+		last := a.History[len(a.History)-1]
+		if last.Games != 12 || last.Rating != a.Rating || !strings.HasPrefix(last.Date, "2002") {
+			t.Errorf("%s: history %+v", method, a.History)
+		}
+		if r, ok := a.At("2001-06-30"); !ok || r == a.Rating {
+			t.Errorf("%s: rating at 2001-06-30 %.0f %v", method, r, ok)
+		}
+		if _, ok := a.At("1999"); ok {
+			t.Errorf("%s: rating at 1999", method)
+		}
+	}
+	dbrq := ratingsDB()
+	rt, _ := BuildRatings(context.Background(), dbrq, RatingOptions{Method: BradleyTerry})
+	// A wins three games in four, about 190 points over B
+	if d := rt.Player("A").Rating - rt.Player("B").Rating; d < 100 || d > 250 {
+		t.Errorf("A - B: %.0f", d)
+	}
+}
+
+func TestRatingsHandicap(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1]PB[A]PW[B]DT[2000-01-01]RE[B+R]KM[7])",
+		"a/2.sgf": "(;GM[1]PB[C]PW[D]DT[2000-01-01]RE[B+R]HA[3]KM[0.5])",
+		"a/3.sgf": "(;GM[1]PB[E]PW[F]DT[2000-01-01]RE[B+R]KM[0])",
+	}
+	dbrq := memDB(files, DBProcessRequest{})
+	rt, err := BuildRatings(context.Background(), dbrq, RatingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	even := rt.Player("A").Rating - 1500
+	handicap := rt.Player("C").Rating - 1500
+	noKomi := rt.Player("E").Rating - 1500
+	if math.Abs(even-8) > 1e-9 || handicap >= noKomi || noKomi >= even || handicap <= 0 {
+		t.Errorf("even %.2f, no komi %.2f, handicap %.2f", even, noKomi, handicap)
+	}
+
+	var buf bytes.Buffer
+	if err := rt.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "id,date,rating,games\nA,2000-01-01,1508.0,1\n") {
+		t.Errorf("CSV:\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := rt.WriteText(&buf, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "Elo ratings of 6 players, 3 games rated, 0 skipped\n   1   1508      1  A\n            2000-01-01   1508      1\n") {
+		t.Errorf("text:\n%s", buf.String())
+	}
+}
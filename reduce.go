@@ -0,0 +1,67 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/reduce.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"context"
+)
+
+// Reducer holds the functions used by ProcessDatabaseReduce to compute
+// a result of type R from a database.
+// File computes a value of type F for each non-empty .sgf file.
+// Dir merges the file values of a directory, starting from the zero value of D.
+// DB merges the directory values, starting from the zero value of R.
+// Dir is called by one go-routine per directory, in file order.
+// DB is called by the resultServer, in the order directories complete,
+// or in directory order if OrderedResults is set.
+type Reducer[F, D, R any] struct {
+	File func(r *DirectoryProcessRequest, fName string, b []byte) F
+	Dir  func(d D, f F) D
+	DB   func(res R, d D) R
+}
+
+// ProcessDatabaseReduce calls ProcessDatabaseContext, using red to compute
+// a result for the database, which it returns with the error.
+// The FileActionFunc, EndDirActionFunc and EndDBActionFunc of dbrq,
+// if any, are also called.
+// A directory which stops with an error is not merged into the result.
+func ProcessDatabaseReduce[F, D, R any](ctx context.Context, dbrq *DBProcessRequest, red Reducer[F, D, R]) (R, error) {
+	dbrq.fileResult = func(r *DirectoryProcessRequest, fName string, b []byte) any {
+		return red.File(r, fName, b)
+	}
+	dbrq.mergeFile = func(d any, f any) any {
+		dv, _ := d.(D)
+		fv, _ := f.(F)
+		return red.Dir(dv, fv)
+	}
+	dbrq.mergeDir = func(res any, d any) any {
+		rv, _ := res.(R)
+		dv, _ := d.(D)
+		return red.DB(rv, dv)
+	}
+	defer func() {
+		dbrq.fileResult = nil
+		dbrq.mergeFile = nil
+		dbrq.mergeDir = nil
+	}()
+	err := ProcessDatabaseContext(ctx, dbrq)
+	res, _ := dbrq.result.(R)
+	return res, err
+}
+
+// Result returns the value computed for the directory by ProcessDatabaseReduce,
+// i.e. for use by an EndDirActionFunc.
+func (req *DirectoryProcessRequest) Result() any {
+	return req.result
+}
+
+// Result returns the value computed for the database by ProcessDatabaseReduce,
+// i.e. for use by an EndDBActionFunc.
+func (dbReq *DBProcessRequest) Result() any {
+	return dbReq.result
+}
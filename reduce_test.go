@@ -0,0 +1,54 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"sort"
+)
+
+// ExampleProcessDatabaseReduce computes a histogram of the number of nodes per game.
+func ExampleProcessDatabaseReduce() {
+	files := map[string]string{
+		"1990/a.sgf": "(;GM[1];B[aa];W[bb])",
+		"1990/b.sgf": "(;GM[1];B[cc])",
+		"1991/c.sgf": "(;GM[1];B[dd];W[ee])",
+	}
+	dbReq := memDB(files, DBProcessRequest{Schedule: ScheduleFiles, DoMultiCPU: true, MaxAtOnce: 2, NumCPUs: 2})
+	hist, err := ProcessDatabaseReduce(context.Background(), dbReq, Reducer[int, map[int]int, map[int]int]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) int {
+			return bytes.Count(b, []byte(";"))
+		},
+		Dir: func(d map[int]int, nodes int) map[int]int {
+			if d == nil {
+				d = make(map[int]int)
+			}
+			d[nodes]++
+			return d
+		},
+		DB: func(res map[int]int, d map[int]int) map[int]int {
+			if res == nil {
+				res = make(map[int]int)
+			}
+			for nodes, n := range d {
+				res[nodes] += n
+			}
+			return res
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	var keys []int
+	for nodes := range hist {
+		keys = append(keys, nodes)
+	}
+	sort.Ints(keys)
+	for _, nodes := range keys {
+		fmt.Printf("%d nodes: %d games\n", nodes, hist[nodes])
+	}
+	// Output:
+	// 2 nodes: 1 games
+	// 3 nodes: 2 games
+}
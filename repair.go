@@ -0,0 +1,286 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/repair.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/Ken1JF/sgf"
+)
+
+// ErrNoGame is returned by RepairSGF for a file without a game.
+var ErrNoGame = errors.New("no game in the file")
+
+// textProps are the properties with Text or SimpleText values,
+// where a "]" may be left unescaped, or a "\" be left before the closing "]".
+var textProps = map[string]bool{
+	"C": true, "GC": true, "N": true, "GN": true, "EV": true, "PC": true, "RO": true,
+	"SO": true, "US": true, "AN": true, "CP": true, "ON": true, "BT": true, "WT": true,
+	"PB": true, "PW": true, "BR": true, "WR": true, "RU": true, "DT": true, "RE": true,
+	"OT": true, "LB": true,
+}
+
+// listProps are the properties with a list of values,
+// whose values are joined when they are repeated in a node.
+var listProps = map[string]bool{
+	"AB": true, "AW": true, "AE": true, "TR": true, "CR": true, "SQ": true, "MA": true,
+	"SL": true, "DD": true, "VW": true, "TB": true, "TW": true, "LB": true, "AR": true, "LN": true,
+}
+
+// repeated joins the property p, read at offset start, to the property
+// of the same PropIdent of n, if there is one, and reports whether there is.
+// The values of a list are joined, C and GC are joined by a newline,
+// and the other properties are removed.
+func (r *sgfReader) repeated(n *sgfNode, p sgfProp, start int) bool {
+	old := n.prop(p.id)
+	if old == nil {
+		return false
+	}
+	switch {
+	case listProps[p.id]:
+		old.vals = append(old.vals, p.vals...)
+		r.change(start, "repeated %s joined", p.id)
+	case p.id == "C" || p.id == "GC":
+		old.vals[0] += "\n" + p.vals[0]
+		r.change(start, "repeated %s joined", p.id)
+	default:
+		r.change(start, "repeated %s[%s] removed", p.id, strings.Join(p.vals, "]["))
+	}
+	return true
+}
+
+// structural reports whether the characters from i start a node,
+// a game tree, a value, or a property, or end a game tree.
+func (r *sgfReader) structural(i int) bool {
+	for i < len(r.b) && isSpace(r.b[i]) {
+		i++
+	}
+	if i >= len(r.b) || strings.IndexByte("[;()", r.b[i]) >= 0 {
+		return true
+	}
+	j := i
+	for j < len(r.b) && 'A' <= r.b[j] && r.b[j] <= 'Z' {
+		j++
+	}
+	for j < len(r.b) && isSpace(r.b[j]) {
+		j++
+	}
+	return j > i && j < len(r.b) && r.b[j] == '['
+}
+
+// repairValue reads a value of the property id, from its "[".
+// In the values of text properties, a "]" which is not followed by a
+// node, a property, or a value, is kept in the value, and a "\" before
+// a "]" which is followed by one of them is kept, so the "]" ends the value.
+func (r *sgfReader) repairValue(id string) string {
+	start := r.pos
+	v, ok := r.value(func(end int, escaped bool) bool {
+		if !textProps[id] {
+			return !escaped
+		}
+		closes := r.structural(end)
+		if escaped && closes {
+			r.change(end-2, "\\ at the end of %s kept", id)
+		} else if !escaped && !closes {
+			r.change(end-1, "unescaped ] kept in %s", id)
+		}
+		return closes
+	})
+	if !ok {
+		r.change(start, "missing ] added to %s at end of file", id)
+	}
+	return v
+}
+
+// RepairSGF reads the SGF file b, repairing the defects it finds:
+// characters outside of the nodes and properties, lower case letters in
+// property identifiers, unescaped "]" and "\" in text values, repeated
+// properties, missing ], ), GM, FF, and SZ, and passes written "tt".
+// It returns the repaired file, and the list of changes.
+// Files without a game are not repaired, and ErrNoGame is returned.
+func RepairSGF(b []byte) ([]byte, []string, error) {
+	r := &sgfReader{sgfScanner: sgfScanner{b: b}, repair: true}
+	trees, _ := r.collection()
+	if len(trees) == 0 {
+		return nil, r.changes, ErrNoGame
+	}
+	for _, t := range trees {
+		r.repairRoot(t)
+		size, _ := boardSize(t.nodes[0].value("SZ"))
+		if size <= 19 {
+			if n := repairPasses(t); n > 0 {
+				r.change(0, "%d passes written tt changed to []", n)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	writeSGF(&buf, trees)
+	return buf.Bytes(), r.changes, nil
+}
+
+// repairRoot adds the missing GM, FF, and SZ to the root node of t,
+// and then writes GM, FF, and SZ first.
+func (r *sgfReader) repairRoot(t *sgfTree) {
+	root := t.nodes[0]
+	head := []sgfProp{{"GM", []string{"1"}}, {"FF", []string{"4"}}, {"SZ", nil}}
+	added := false
+	for i := range head {
+		if p := root.prop(head[i].id); p != nil {
+			head[i] = *p
+			continue
+		}
+		if head[i].id == "SZ" {
+			head[i].vals = []string{fmt.Sprint(inferSize(t))}
+		}
+		r.change(0, "%s[%s] added", head[i].id, head[i].vals[0])
+		added = true
+	}
+	if !added {
+		return
+	}
+	for _, p := range root.props {
+		if p.id != "GM" && p.id != "FF" && p.id != "SZ" {
+			head = append(head, p)
+		}
+	}
+	root.props = head
+}
+
+// inferSize returns the smallest of 9, 13, and 19, or larger board size
+// holding the points of the moves and setup stones of t.
+func inferSize(t *sgfTree) int {
+	most := -1
+	var walk func(t *sgfTree)
+	walk = func(t *sgfTree) {
+		for _, n := range t.nodes {
+			for _, p := range n.props {
+				switch p.id {
+				case "B", "W":
+					if len(p.vals) > 0 && p.vals[0] == "tt" {
+						continue
+					}
+				case "AB", "AW", "AE":
+				default:
+					continue
+				}
+				pts, _ := pointList(p.vals)
+				for _, pt := range pts {
+					most = max(most, pt[0], pt[1])
+				}
+			}
+		}
+		for _, v := range t.vars {
+			walk(v)
+		}
+	}
+	walk(t)
+	for _, size := range []int{9, 13, 19} {
+		if most < size {
+			return size
+		}
+	}
+	return min(most+1, MaxBoardSize)
+}
+
+// repairPasses writes the moves "tt" of t as [], and returns their number.
+func repairPasses(t *sgfTree) int {
+	n := 0
+	for _, nd := range t.nodes {
+		for i := range nd.props {
+			p := &nd.props[i]
+			if (p.id == "B" || p.id == "W") && len(p.vals) == 1 && p.vals[0] == "tt" {
+				p.vals[0] = ""
+				n++
+			}
+		}
+	}
+	for _, v := range t.vars {
+		n += repairPasses(v)
+	}
+	return n
+}
+
+// RepairSGFFile repairs the file, with RepairSGF, and writes the repaired
+// copy to the output directory (or the Output sink), with the list of
+// changes in a file named fName+".log", if there are changes.
+// If the file cannot be repaired, or the repaired file still has errors
+// when parsed by sgf.ParseFile with the parser mode of the request,
+// the original file, and the log, are written to the Quarantine sink.
+func RepairSGFFile(r *DirectoryProcessRequest, fName string, b []byte) {
+	r.cntf += 1
+	out, changes, err := RepairSGF(b)
+	if err == nil {
+		_, errL := parseSGF(r.FilePath(fName), out, r.dbReq.PModeReq, r.dbReq.MoveLimit)
+		if len(errL) != 0 {
+			err = errL
+		}
+	}
+	r.cntm += len(changes)
+	log := strings.Join(changes, "\n")
+	if err != nil {
+		log += fmt.Sprintf("\nnot repaired: %s", err)
+		log = strings.TrimPrefix(log, "\n")
+		r.FileError(fName, "Repairing", err)
+		if r.dbReq.Quarantine == nil {
+			return
+		}
+		rel := path.Join(r.rel, fName)
+		if err := r.dbReq.Quarantine.WriteFile(rel, b); err != nil {
+			r.FileError(fName, "Quarantining", err)
+			return
+		}
+		if err := r.dbReq.Quarantine.WriteFile(rel+".log", []byte(log+"\n")); err != nil {
+			r.FileError(fName, "Quarantining", err)
+		}
+		return
+	}
+	if err := r.WriteOutput(fName, out); err != nil {
+		r.FileError(fName, "Writing", err)
+		return
+	}
+	if len(changes) > 0 {
+		if err := r.WriteOutput(fName+".log", []byte(log+"\n")); err != nil {
+			r.FileError(fName, "Writing", err)
+		}
+	}
+}
+
+// RepairSGFDirectory reports the files repaired in a directory.
+func RepairSGFDirectory(r *DirectoryProcessRequest, fName string, b []byte) {
+	fmt.Printf("%3d:%s, files: %d, changes: %d, not repaired: %d\n", r.i, r.rel, r.cntf, r.cntm, len(r.errs))
+}
+
+// RepairSGFDatabase reports the files repaired in the database.
+func RepairSGFDatabase(r *DirectoryProcessRequest, fName string, b []byte) {
+	fmt.Printf("Total SGF files = %d, changes = %d, not repaired = %d\n", r.dbReq.totalF, r.dbReq.totalM, r.dbReq.totalE)
+}
+
+// ReadAndRepairDatabase repairs the files of the database db_dir, writing
+// the repaired files to out_dir, and the files which cannot be repaired
+// to quarantine_dir. The files are repaired by a fileServer for each CPU;
+// the repaired files are parsed one at a time, by parseSGF.
+func ReadAndRepairDatabase(db_dir string, out_dir string, quarantine_dir string, fileLimit int, moveLimit int, skipFiles int) int {
+
+	defer un(trace("ReadAndRepairDatabase"), nil)
+
+	var dbReq DBProcessRequest
+
+	fmt.Printf("Repairing database, db_dir = %v, out_dir = %v, quarantine_dir = %v\n",
+		db_dir, out_dir, quarantine_dir)
+
+	dbReq.initDBRequest("ReadAndRepairDatabase", db_dir, out_dir, true, false, 0, skipFiles, fileLimit, moveLimit, sgf.ParserGoGoD|sgf.ParserPlay, RepairSGFFile, RepairSGFDirectory, RepairSGFDatabase, sgf.DefaultNumPerLine)
+	dbReq.Schedule = ScheduleFiles
+	dbReq.OrderedResults = true
+	dbReq.Quarantine = DirSink(quarantine_dir)
+	ret := ProcessDatabase(&dbReq)
+	return ret
+}
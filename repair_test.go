@@ -0,0 +1,86 @@
+package sgfdb_test
+
+import (
+	"context"
+	"fmt"
+	. "github.com/Ken1JF/sgfdb"
+	"strings"
+	"testing"
+)
+
+func TestRepairSGF(t *testing.T) {
+	tests := []struct {
+		in, out string
+		changes int
+	}{
+		{"(;GM[1]FF[4]SZ[19];B[pd];W[dd])", "(;GM[1]FF[4]SZ[19]\n;B[pd];W[dd])\n", 0},
+		{"(;PB[a];B[pd];W[tt])", "(;GM[1]FF[4]SZ[19]PB[a]\n;B[pd];W[])\n", 4},
+		{"(;GM[1]FF[4];B[ee])", "(;GM[1]FF[4]SZ[9]\n;B[ee])\n", 1},
+		{"garbage(;GM[1]FF[4]SZ[19]\n%%;b[pd]; AddWhite[dd] ;W[pp]})\ntrailer",
+			"(;GM[1]FF[4]SZ[19]\n;B[pd];AW[dd];W[pp])\n", 6},
+		{"(;GM[1]FF[4]SZ[19]C[see [1] here];B[pd]C[c:\\];W[dd])",
+			"(;GM[1]FF[4]SZ[19]C[see [1\\] here]\n;B[pd]C[c:\\\\];W[dd])\n", 2},
+		{"(;GM[1]FF[4]SZ[19]AB[dd]AB[pp]KM[6.5]KM[5.5];B[pd]C[a]C[b])",
+			"(;GM[1]FF[4]SZ[19]AB[dd][pp]KM[6.5]\n;B[pd]C[a\nb])\n", 3},
+		{"(;GM[1]FF[4]SZ[19];B[pd];W[dd]C[unfinished", "(;GM[1]FF[4]SZ[19]\n;B[pd];W[dd]C[unfinished])\n", 2},
+	}
+	for _, tst := range tests {
+		out, changes, err := RepairSGF([]byte(tst.in))
+		if err != nil {
+			t.Errorf("%q: %s", tst.in, err)
+			continue
+		}
+		if string(out) != tst.out || len(changes) != tst.changes {
+			t.Errorf("%q:\ngot      %q %d changes\n%s\nexpected %q %d changes", tst.in, out, len(changes), strings.Join(changes, "\n"), tst.out, tst.changes)
+		}
+	}
+	if _, _, err := RepairSGF([]byte("not a game")); err != ErrNoGame {
+		t.Errorf("expected ErrNoGame, got %v", err)
+	}
+}
+
+func TestRepairSGFFile(t *testing.T) {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1]FF[4]SZ[19];B[pd])",
+		"a/2.sgf": "(;b[pd])",
+		"a/3.sgf": "empty",
+	}
+	var out, quarantine MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &out, Quarantine: &quarantine, FileActionFunc: RepairSGFFile})
+	err := ProcessDatabaseContext(context.Background(), dbReq)
+	if err == nil || !strings.Contains(err.Error(), "3.sgf") {
+		t.Errorf("expected an error for 3.sgf, got %v", err)
+	}
+	if len(out.Files) != 3 || string(out.Files["a/2.sgf"]) != "(;GM[1]FF[4]SZ[19]B[pd])\n" || out.Files["a/1.sgf.log"] != nil {
+		t.Errorf("output: %q", out.Files)
+	}
+	if !strings.Contains(string(out.Files["a/2.sgf.log"]), "property b written B") {
+		t.Errorf("log: %s", out.Files["a/2.sgf.log"])
+	}
+	if string(quarantine.Files["a/3.sgf"]) != "empty" || !strings.Contains(string(quarantine.Files["a/3.sgf.log"]), "not repaired: "+ErrNoGame.Error()) {
+		t.Errorf("quarantine: %q", quarantine.Files)
+	}
+}
+
+func TestRepairSGFFileParallel(t *testing.T) {
+	// run with -race: the files are repaired, and parsed, by several fileServers
+	files := map[string]string{}
+	for d := 0; d < 3; d++ {
+		for f := 0; f < 8; f++ {
+			files[fmt.Sprintf("d%d/%d.sgf", d, f)] = "(;b[pd];w[dd])"
+		}
+	}
+	var out MapSink
+	dbReq := memDB(files, DBProcessRequest{Output: &out, DoMultiCPU: true, MaxAtOnce: 4, NumCPUs: 4,
+		Schedule: ScheduleFiles, FileActionFunc: RepairSGFFile})
+	if err := ProcessDatabaseContext(context.Background(), dbReq); err != nil {
+		t.Fatal(err)
+	}
+	// each file, and its log
+	if len(out.Files) != 2*len(files) {
+		t.Errorf("%d files written, expected %d", len(out.Files), 2*len(files))
+	}
+	if _, f, m, _ := dbReq.Totals(); f != len(files) || m == 0 {
+		t.Errorf("totals %d files, %d changes", f, m)
+	}
+}
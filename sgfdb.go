@@ -10,12 +10,20 @@
 // held in a two level directory hierarchy.
 // The Index of the data base is a directory of directories.
 // Each directory in the Index can hold multiple .sgf files.
+// Optionally, the Index can be a directory tree of any depth,
+// where each directory holding .sgf files is processed.
 package sgfdb
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"time"
 	//    "syscall"
 	"fmt"
@@ -24,39 +32,135 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"unsafe"
 )
 
 type ActionFunction func(r *DirectoryProcessRequest, fName string, b []byte)
 
-type DBProcessRequest struct {
-	// input parameters
-	Requester   string // name of function making request
-	DBIndexName string // name of Databse root directory
-	DBOutName   string // name of Output root directory
+// ScheduleMode selects the unit of work executed in parallel by ProcessDatabase.
+type ScheduleMode int
 
-	DoMultiCPU bool // run parallel go routines
-	ReportCPUs bool // report changing of CPUs
-	MaxAtOnce  int  // number of parallel executions, if 0 use NumCPUs
+const (
+	// ScheduleDirectories processes each directory in one go-routine.
+	ScheduleDirectories ScheduleMode = iota
+	// ScheduleFiles sends the files of each directory to a pool of
+	// MaxAtOnce fileServers, shared by all directories, so that large
+	// directories are spread over all CPUs.
+	ScheduleFiles
+)
+
+// LimitScope selects how SkipFiles and FileLimit count the .sgf files.
+// Files are counted in directory order, and in name order within a directory.
+// Empty files are not processed, so they are not counted.
+type LimitScope int
+
+const (
+	// LimitPerDirectory skips the first SkipFiles files of each directory,
+	// and processes at most FileLimit files of each directory.
+	LimitPerDirectory LimitScope = iota
+	// LimitPerDatabase counts the files across the whole database,
+	// e.g. SkipFiles 10000 and FileLimit 10000 processes files 10000-19999,
+	// so that a run can be split over machines, or resumed.
+	LimitPerDatabase
+)
 
-	SkipFiles int
-	FileLimit int
-	MoveLimit int
+type DBProcessRequest struct {
+	// input parameters
+	Requester   string     // name of function making request
+	DBIndexName string     // name of Databse root directory
+	DBOutName   string     // name of Output root directory
+	DBFS        fs.FS      // if not nil, the Database is read from DBFS, and DBIndexName only names it
+	Output      OutputSink // if not nil, output files are written to Output, instead of DBOutName
+	Quarantine  OutputSink // if not nil, RepairSGFFile writes the files it cannot repair to Quarantine
+	Transcode   bool       // the files are converted to UTF-8 by TranscodeSGF, before the FileActionFunc
+
+	DoMultiCPU bool         // run parallel go routines
+	ReportCPUs bool         // report changing of CPUs
+	MaxAtOnce  int          // number of parallel executions, if 0 use NumCPUs
+	Schedule   ScheduleMode // parallel execution of directories or files
+	Recursive  bool         // process every directory below DBIndexName holding .sgf files
+
+	OrderedResults bool // call EndDirActionFunc in directory order, not in order of completion
+
+	SkipFiles  int        // number of .sgf files to skip
+	FileLimit  int        // maximum number of .sgf files to process, if 0 no limit
+	MoveLimit  int        // maximum number of moves to parse
+	LimitScope LimitScope // SkipFiles and FileLimit per directory or per database
 
 	PModeReq         sgf.ParserMode
 	FileActionFunc   ActionFunction
 	EndDirActionFunc ActionFunction
 	EndDBActionFunc  ActionFunction
 
-	NumPerLine int // Number of moves per line for output .sgf files
+	NumPerLine   int  // Number of moves per line for output .sgf files
+	VerifyOutput bool // WriteSGFFile parses each copy written, and compares its sgf.GameTree with the one of the file
+	Normalize    bool // WriteSGFFile writes each file in the canonical form of NormalizeSGF
 
-	// output results
-	totalD   int // can be used by Action Functions, i.e. to count directories
-	totalF   int // can be used by Action Functions, i.e. to count files
-	totalM   int // can be used by Action Functions, i.e. to count moves or tokens
-	totalE   int // can be used by Action Functions, i.e. to count errors
+	// output results, added up by the resultServer
+	totalD   int // number of directories processed
+	totalF   int // sum of the directory cntf, i.e. count of files
+	totalM   int // sum of the directory cntm, i.e. count of moves or tokens
+	totalE   int // number of errors recorded
 	NumCPUs  int
 	DBErrors []error // errors accummulated from Index
+
+	ctx      context.Context   // set by ProcessDatabaseContext, to stop processing
+	fsys     fs.FS             // DBFS, or the DBIndexName directory
+	fileChan chan *fileRequest // to send files to the fileServers, if Schedule is ScheduleFiles
+
+	// set by ProcessDatabaseReduce, to compute a result
+	fileResult func(r *DirectoryProcessRequest, fName string, b []byte) any
+	mergeFile  func(d any, f any) any
+	mergeDir   func(res any, d any) any
+	result     any
+}
+
+// dbFS returns the file system holding the Database.
+func (dbReq *DBProcessRequest) dbFS() fs.FS {
+	if dbReq.fsys == nil {
+		if dbReq.DBFS != nil {
+			dbReq.fsys = dbReq.DBFS
+		} else {
+			dbReq.fsys = os.DirFS(dbReq.DBIndexName)
+		}
+	}
+	return dbReq.fsys
+}
+
+// Totals returns the number of directories processed, and the totals
+// of files, moves (or tokens), and errors counted by the Action Functions.
+func (dbReq *DBProcessRequest) Totals() (dirs int, files int, moves int, errs int) {
+	return dbReq.totalD, dbReq.totalF, dbReq.totalM, dbReq.totalE
+}
+
+// cancelled returns the error of the request's context,
+// or nil if the request has no context or it has not been cancelled.
+func (dbReq *DBProcessRequest) cancelled() error {
+	if dbReq.ctx == nil {
+		return nil
+	}
+	return dbReq.ctx.Err()
+}
+
+// DirectoryError records an error found while processing a directory
+// of the database. File is empty if the error is not specific to a file.
+type DirectoryError struct {
+	Dir    string // directory name
+	File   string // file name, if any
+	Action string // action causing the error
+	Err    error
+}
+
+func (e *DirectoryError) Error() string {
+	if e.File != "" {
+		return e.Action + ": " + e.Dir + "/" + e.File + ": " + e.Err.Error()
+	}
+	return e.Action + ": " + e.Dir + ": " + e.Err.Error()
+}
+
+func (e *DirectoryError) Unwrap() error {
+	return e.Err
 }
 
 // temporary Hack
@@ -200,43 +304,103 @@ type DirectoryProcessRequest struct {
 	// normal parameters
 	i     int    // order in Database directory
 	dir   string // dir name, if == "", then i == -1 and fileLimit == count of dirs
+	rel   string // dir name relative to DBIndexName, "." for DBIndexName
 	dbReq *DBProcessRequest
+	skip  int // number of .sgf files to skip in dir
+	limit int // number of .sgf files to process after skip, if < 0 no limit
+
+	// the .sgf files of dir, if listed when the request was built, for LimitPerDatabase
+	listed  bool
+	names   []string
+	listErr error
 
 	// return values
 	cntf int // can be used by Action Functions, i.e. to count files
 	cntm int // can be used by Action Functions, i.e. to count moves or tokens
 
-	errAct string // action causing error, if any
-	err    error  // error if any
+	errAct string  // action causing error, if any
+	err    error   // error if any
+	errs   []error // errors recorded by ProcessDirectory and Action Functions
+	result any     // directory result, for ProcessDatabaseReduce
 	// communication channels
 	checkCount int                           // only used by last request
 	reply      chan *DirectoryProcessRequest // to send back results
 	done       chan bool                     // to signal completion, through first defer
 }
 
+// Dir returns the name of the directory being processed.
+func (req *DirectoryProcessRequest) Dir() string {
+	return req.dir
+}
+
+// RelDir returns the name of the directory being processed,
+// relative to the Index directory, "." for the Index directory itself.
+func (req *DirectoryProcessRequest) RelDir() string {
+	return req.rel
+}
+
+// FilePath returns the path of the file fName of the directory being processed,
+// relative to the Index directory.
+func (req *DirectoryProcessRequest) FilePath(fName string) string {
+	return path.Join(req.rel, fName)
+}
+
+// FileInfo returns the fs.FileInfo of the file fName of the directory being processed.
+func (req *DirectoryProcessRequest) FileInfo(fName string) (fs.FileInfo, error) {
+	return fs.Stat(req.dbReq.dbFS(), req.FilePath(fName))
+}
+
+// FileError records an error found by an Action Function while
+// processing the file fName. Processing of the directory continues.
+// The errors are returned by ProcessDatabaseContext.
+func (req *DirectoryProcessRequest) FileError(fName string, action string, err error) {
+	req.errs = append(req.errs, &DirectoryError{Dir: req.dir, File: fName, Action: action, Err: err})
+}
+
+// setError records an error that stops the processing of the directory.
+func (req *DirectoryProcessRequest) setError(errAct string, fName string, action string, err error) {
+	req.err = err
+	req.errAct = errAct
+	req.errs = append(req.errs, &DirectoryError{Dir: req.dir, File: fName, Action: action, Err: err})
+}
+
+// abort records an error that stops the processing of the directory,
+// and sends the request back to the resultServer.
+func (req *DirectoryProcessRequest) abort(errAct string, fName string, action string, err error) {
+	req.setError(errAct, fName, action, err)
+	req.reply <- req
+}
+
+// CountMoves is the fast, approximate, action function of CountFilesAndMoves.
+// It counts the ';' bytes of each file as moves, so the root and setup nodes,
+// and semi-colons in text values, i.e. C[], are counted too.
+// As it always has, it stops at a ';' which starts the file, or follows
+// another ';', so the counts of the goldens of CountFilesAndMoves hold.
+// CountNodesAndMoves reads the games, and counts only B and W moves.
 func CountMoves(req *DirectoryProcessRequest, fName string, b []byte) {
 	req.cntf++ // TODO: decide: empty files are not SGF files. so don't count?
-	idx := strings.Index(string(b), ";")
+	idx := bytes.IndexByte(b, ';')
 	for idx > 0 {
 		req.cntm++
 		b = b[idx+1:]
-		idx = strings.Index(string(b), ";")
+		idx = bytes.IndexByte(b, ';')
 	}
 }
 
+// ReportDirCounts reports the counts of CountMoves for a directory.
+// The moves are approximate: they are the ';' bytes of the files.
 func ReportDirCounts(req *DirectoryProcessRequest, fName string, b []byte) {
-	req.dbReq.totalF += req.cntf
-	req.dbReq.totalM += req.cntm
 	if req.errAct != "" {
 		fmt.Printf("%3d:%s:%s\n", req.i, req.errAct, req.err)
 	} else {
-		idx := strings.LastIndex(req.dir, "/")
-		fmt.Printf("%3d:%s, files: %d, moves: %d\n", req.i, req.dir[idx+1:], req.cntf, req.cntm)
+		fmt.Printf("%3d:%s, files: %d, approximate moves: %d\n", req.i, req.rel, req.cntf, req.cntm)
 	}
 }
 
+// ReportDBCounts reports the counts of CountMoves for the database,
+// with the approximate moves of ReportDirCounts.
 func ReportDBCounts(req *DirectoryProcessRequest, fName string, b []byte) {
-	fmt.Printf("Total SGF files = %d, total moves = %d\n",
+	fmt.Printf("Total SGF files = %d, total approximate moves = %d\n",
 		req.dbReq.totalF, req.dbReq.totalM)
 }
 
@@ -250,58 +414,201 @@ func ReportDBCounts(req *DirectoryProcessRequest, fName string, b []byte) {
 func ProcessDirectory(req *DirectoryProcessRequest) {
 	defer un(trace("ProcessDirectory"), req.done)
 
-	// read the subDirectory to process
-	dirFiles, e := ioutil.ReadDir(req.dir)
-	if e != nil && e != io.EOF {
+	// read the subDirectory to process, unless it was listed to set the limits
+	names, e := req.names, req.listErr
+	if !req.listed {
+		names, e = sgfFileNames(req.dbReq.dbFS(), req.rel)
+	}
+	if e != nil {
 		// if there is an error record it, send reply, and return
-		req.err = e
-		s := "Reading directory: " + req.dir
-		req.errAct = s
-		req.reply <- req
+		req.abort("Reading directory: "+req.dir, "", "Reading directory", e)
 		return
 	}
-	// process the files in the subdirectory
-	for _, f := range dirFiles {
-		// skip entries that are not .sgf files
-		if strings.Index(f.Name(), ".sgf") >= 0 {
-			// read the SGF file
-			b, e := ioutil.ReadFile(req.dir + "/" + f.Name())
-			if e != nil && e != io.EOF {
-				// if there is an error record it, send reply, and return
-				req.err = e
-				s := "Reading file: " + req.dir + "/" + f.Name()
-				req.errAct = s
-				req.reply <- req
+	// select the files to process
+	if req.skip >= len(names) {
+		names = nil
+	} else {
+		names = names[req.skip:]
+	}
+	if req.limit >= 0 && req.limit < len(names) {
+		names = names[:req.limit]
+	}
+	if req.dbReq.Schedule == ScheduleFiles {
+		// the files are processed by the fileServers
+		if !req.scheduleFiles(names) {
+			req.stopped()
+			return
+		}
+	} else {
+		// process the files in the subdirectory
+		for _, fName := range names {
+			val, hasVal, ok := req.processFile(fName)
+			if !ok {
+				req.stopped()
 				return
 			}
-			// if the file is not empty
-			if len(b) > 0 {
-				// call the action funtion
-				if req.dbReq.FileActionFunc != nil {
-					idx := strings.LastIndex(f.Name(), "/")
-					if idx >= 0 {
-						req.dbReq.FileActionFunc(req, f.Name()[idx+1:], b)
-					} else {
-						req.dbReq.FileActionFunc(req, f.Name(), b)
-					}
-				}
-				// check if fileLimit is set
-				if req.dbReq.FileLimit > 0 {
-					// check if filelimit has been reached
-					if req.cntf >= req.dbReq.FileLimit {
-						break
-					}
-				}
+			if hasVal {
+				req.result = req.dbReq.mergeFile(req.result, val)
 			}
 		}
 	}
-	if req.dbReq.EndDirActionFunc != nil {
-		req.dbReq.EndDirActionFunc(req, "", nil)
-	}
+	// the EndDirActionFunc is called by the resultServer
 	req.reply <- req
 	return
 }
 
+// stopped records a cancellation of the directory, once, not for each file
+// which was not processed, and sends the request back to the resultServer.
+func (req *DirectoryProcessRequest) stopped() {
+	if req.errAct == "Cancelled" {
+		req.errs = append(req.errs, &DirectoryError{Dir: req.dir, Action: "Cancelled", Err: req.err})
+	}
+	req.reply <- req
+}
+
+// sgfFileNames reads the directory dir of fsys, and returns the names of the .sgf files, sorted.
+// Empty files are skipped, as ProcessDirectory does not process them.
+func sgfFileNames(fsys fs.FS, dir string) (names []string, err error) {
+	dirFiles, err := fs.ReadDir(fsys, dir)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	// skip entries that are not .sgf files
+	for _, f := range dirFiles {
+		if strings.Index(f.Name(), ".sgf") >= 0 {
+			if info, err := f.Info(); err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+				continue
+			}
+			names = append(names, f.Name())
+		}
+	}
+	return names, nil
+}
+
+// setLimits sets the files to skip and process in the directory of req.
+// For LimitPerDatabase, first is the number of .sgf files in the directories
+// before req.dir.
+func (req *DirectoryProcessRequest) setLimits(first int) {
+	dbReq := req.dbReq
+	if dbReq.LimitScope == LimitPerDirectory {
+		req.skip = dbReq.SkipFiles
+		req.limit = -1
+		if dbReq.FileLimit > 0 {
+			req.limit = dbReq.FileLimit
+		}
+		return
+	}
+	req.skip = dbReq.SkipFiles - first
+	if req.skip < 0 {
+		req.skip = 0
+	}
+	req.limit = -1
+	if dbReq.FileLimit > 0 {
+		req.limit = dbReq.SkipFiles + dbReq.FileLimit - (first + req.skip)
+		if req.limit < 0 {
+			req.limit = 0
+		}
+	}
+}
+
+// processFile reads the file fName in the request's directory,
+// and if the file is not empty, calls the FileActionFunc,
+// and computes the file result, for ProcessDatabaseReduce.
+// If the file cannot be processed, it records the error and returns ok false.
+func (req *DirectoryProcessRequest) processFile(fName string) (val any, hasVal bool, ok bool) {
+	// stop at a file boundary, if the request has been cancelled;
+	// the cancellation is recorded for the directory, by stopped
+	if e := req.dbReq.cancelled(); e != nil {
+		req.err = e
+		req.errAct = "Cancelled"
+		return nil, false, false
+	}
+	// read the SGF file
+	b, e := fs.ReadFile(req.dbReq.dbFS(), req.FilePath(fName))
+	if e != nil && e != io.EOF {
+		req.setError("Reading file: "+req.dir+"/"+fName, fName, "Reading file", e)
+		return nil, false, false
+	}
+	// convert the file to UTF-8, or skip it
+	if req.dbReq.Transcode && len(b) > 0 {
+		if b, _, e = TranscodeSGF(b); e != nil {
+			req.FileError(fName, "Transcoding", e)
+			return nil, false, true
+		}
+	}
+	// if the file is not empty
+	if len(b) > 0 {
+		// call the action funtion
+		if req.dbReq.FileActionFunc != nil {
+			req.dbReq.FileActionFunc(req, fName, b)
+		}
+		if req.dbReq.fileResult != nil {
+			return req.dbReq.fileResult(req, fName, b), true, true
+		}
+	}
+	return nil, false, true
+}
+
+// fileRequest holds a single file of a directory,
+// to be processed by a fileServer.
+type fileRequest struct {
+	n      int                      // order in the directory
+	fName  string                   // file name
+	req    *DirectoryProcessRequest // copy of the directory request, for this file
+	val    any                      // file result, for ProcessDatabaseReduce
+	hasVal bool                     // val was computed
+	reply  chan *fileRequest        // to send back results
+}
+
+// fileServer runs as an independent go-routine, when Schedule is ScheduleFiles.
+// It receives fileRequest records from the fileChan until it is closed,
+// processes the file, and sends the request back to the ProcessDirectory which sent it.
+func fileServer(fileChan chan *fileRequest) {
+	defer un(trace("fileServer"), nil)
+	for freq := range fileChan {
+		freq.val, freq.hasVal, _ = freq.req.processFile(freq.fName)
+		freq.reply <- freq
+	}
+}
+
+// scheduleFiles sends the files of the directory to the fileServers,
+// waits for all of them, and adds their results to req, in file order.
+// It returns false if an error stopped the processing of a file.
+func (req *DirectoryProcessRequest) scheduleFiles(names []string) bool {
+	reply := make(chan *fileRequest, len(names))
+	sent := 0
+	for n, fName := range names {
+		// do not queue the rest of the files, if the request has been cancelled
+		if e := req.dbReq.cancelled(); e != nil {
+			req.err = e
+			req.errAct = "Cancelled"
+			break
+		}
+		freq := fileRequest{n: n, fName: fName, reply: reply,
+			req: &DirectoryProcessRequest{i: req.i, dir: req.dir, rel: req.rel, dbReq: req.dbReq}}
+		req.dbReq.fileChan <- &freq
+		sent++
+	}
+	results := make([]*fileRequest, sent)
+	for i := 0; i < sent; i++ {
+		freq := <-reply
+		results[freq.n] = freq
+	}
+	for _, freq := range results {
+		req.cntf += freq.req.cntf
+		req.cntm += freq.req.cntm
+		req.errs = append(req.errs, freq.req.errs...)
+		if freq.hasVal {
+			req.result = req.dbReq.mergeFile(req.result, freq.val)
+		}
+		if freq.req.err != nil && req.err == nil {
+			req.err = freq.req.err
+			req.errAct = freq.req.errAct
+		}
+	}
+	return req.err == nil
+}
+
 // requestServer runs as an independent go-routine.
 // It receives DirectoryProcessRequest records from a reqChan,
 // and dispatches them to ProcessDirectory,
@@ -323,6 +630,14 @@ func requestServer(reqChan chan *DirectoryProcessRequest, replyChan chan *Direct
 			break
 		}
 		nRequests++
+		if e := req.dbReq.cancelled(); e != nil {
+			// do not dispatch after cancellation, but reply so the resultServer count is kept
+			req.err = e
+			req.errAct = "Cancelled"
+			replyChan <- req
+			doneChan <- true
+			continue
+		}
 		go ProcessDirectory(req)
 	}
 	return
@@ -332,17 +647,33 @@ func requestServer(reqChan chan *DirectoryProcessRequest, replyChan chan *Direct
 // It receives DirectoryProcessRequest records from ProcessDirectory,
 // and tallies them,
 // except for a special final request, with dir == "", which is sent directly from requestServer.
+// As the only go-routine updating the totals of the DBProcessRequest,
+// it also calls the EndDirActionFunc for each directory completed.
+// If OrderedResults is set, results which arrive early are held
+// until the results of all previous directories have arrived.
 func resultServer(replyChan chan *DirectoryProcessRequest, doneChan chan bool, finishChan chan bool) {
 	defer un(trace("resultServer"), finishChan)
 	var req *DirectoryProcessRequest
 	expected := -1 // the number of requests counted by generator and request server
 	counted := 0
+	next := 0                                      // next directory to end, if OrderedResults
+	held := make(map[int]*DirectoryProcessRequest) // results waiting for previous directories
 	for {
 		req = <-replyChan
 		if req.i == -1 && req.dir == "" { // special request passing end info
 			expected = req.checkCount
 		} else { // normal result
 			counted++
+			if req.dbReq.OrderedResults {
+				held[req.i] = req
+				for held[next] != nil {
+					endDirectory(held[next])
+					delete(held, next)
+					next++
+				}
+			} else {
+				endDirectory(req)
+			}
 		}
 		if 0 <= expected && expected <= counted {
 			break
@@ -353,6 +684,26 @@ func resultServer(replyChan chan *DirectoryProcessRequest, doneChan chan bool, f
 	}
 }
 
+// endDirectory adds the results of a directory to the totals of the DBProcessRequest,
+// and if the directory was completed, calls the EndDirActionFunc.
+func endDirectory(req *DirectoryProcessRequest) {
+	dbReq := req.dbReq
+	dbReq.DBErrors = append(dbReq.DBErrors, req.errs...)
+	dbReq.totalE += len(req.errs)
+	if req.err != nil {
+		return
+	}
+	dbReq.totalD += 1
+	dbReq.totalF += req.cntf
+	dbReq.totalM += req.cntm
+	if dbReq.mergeDir != nil {
+		dbReq.result = dbReq.mergeDir(dbReq.result, req.result)
+	}
+	if dbReq.EndDirActionFunc != nil {
+		dbReq.EndDirActionFunc(req, "", nil)
+	}
+}
+
 // startServers creates the channels needed for communication,
 // and launches the request and result Servers.
 // It also "primes" the doneChan with enough completion notices to allow the indicated
@@ -374,39 +725,153 @@ func startServers(dbReq *DBProcessRequest) (reqChan chan *DirectoryProcessReques
 		doneChan <- true // signal completions to get parallel execution started
 	}
 
+	if dbReq.Schedule == ScheduleFiles {
+		dbReq.fileChan = make(chan *fileRequest, dbReq.MaxAtOnce)
+		for i := 1; i <= dbReq.MaxAtOnce; i++ {
+			go fileServer(dbReq.fileChan)
+		}
+	}
+
 	go resultServer(replyChan, doneChan, finishChan)
 	go requestServer(reqChan, replyChan, doneChan)
 
 	return reqChan, replyChan, doneChan, finishChan
 }
 
+// dirPath returns the name of the directory rel, relative to DBIndexName.
+func (dbrq *DBProcessRequest) dirPath(rel string) string {
+	if rel == "." {
+		return path.Clean(dbrq.DBIndexName)
+	}
+	return dbrq.DBIndexName + rel
+}
+
+// databaseDirs reads the Database directory, and returns the names
+// of the directories to process, relative to DBIndexName.
+// If Recursive is not set, these are the directories in DBIndexName,
+// otherwise they are all of the directories holding .sgf files,
+// in name order, DBIndexName itself first.
+func (dbrq *DBProcessRequest) databaseDirs() (rels []string, err error) {
+	fsys := dbrq.dbFS()
+	dirs, err := fs.ReadDir(fsys, ".")
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if dbrq.Recursive {
+		return dbrq.walkDirs(".", dirs, nil, map[string]bool{}), nil
+	}
+	for _, d := range dirs {
+		if len(d.Name()) > 0 && d.Name()[0] != '.' {
+			fileInfo, err := fs.Stat(fsys, d.Name())
+			if err == nil {
+				if fileInfo.IsDir() {
+					rels = append(rels, d.Name())
+				}
+			}
+		}
+	}
+	return rels, nil
+}
+
+// walkDirs appends the directory rel, with entries dirs, to rels, if it holds .sgf files,
+// followed by the directories below it.
+// A directory reached twice, through symbolic links, is only processed once.
+func (dbrq *DBProcessRequest) walkDirs(rel string, dirs []fs.DirEntry, rels []string, visited map[string]bool) []string {
+	fsys := dbrq.dbFS()
+	if dbrq.DBFS == nil {
+		if real, err := filepath.EvalSymlinks(dbrq.dirPath(rel)); err == nil {
+			if visited[real] {
+				return rels
+			}
+			visited[real] = true
+		}
+	}
+	hasSGF := false
+	var subDirs []string
+	for _, d := range dirs {
+		if len(d.Name()) > 0 && d.Name()[0] != '.' {
+			fileInfo, err := fs.Stat(fsys, path.Join(rel, d.Name()))
+			if err == nil {
+				if fileInfo.IsDir() {
+					subDirs = append(subDirs, d.Name())
+				} else if strings.Index(d.Name(), ".sgf") >= 0 {
+					hasSGF = true
+				}
+			}
+		}
+	}
+	if hasSGF {
+		rels = append(rels, rel)
+	}
+	for _, sub := range subDirs {
+		subRel := path.Join(rel, sub)
+		subDirs, err := fs.ReadDir(fsys, subRel)
+		if err != nil && err != io.EOF {
+			// let ProcessDirectory report the error
+			rels = append(rels, subRel)
+			continue
+		}
+		rels = dbrq.walkDirs(subRel, subDirs, rels, visited)
+	}
+	return rels
+}
+
 // ProcessDatabase reads the Database directory, starts the servers,
 // builds the requests, and sends them to the requestServer.
 // After sending a special final request, it waits for the finishChan to signal completion.
+// It returns 2 if the Database directory cannot be read, and 0 otherwise.
+// The errors recorded while processing the files are kept in dbrq.DBErrors,
+// and returned by ProcessDatabaseContext.
 func ProcessDatabase(dbrq *DBProcessRequest) int {
-	// Read the sgfdb directories:
-	dirs, err := ioutil.ReadDir(dbrq.DBIndexName)
-	if err != nil && err != io.EOF {
-		fmt.Printf("Error reading sgfdb directory: %s, %s\n", dbrq.DBIndexName, err)
+	err := ProcessDatabaseContext(context.Background(), dbrq)
+	if de, ok := err.(*DirectoryError); ok && de.Dir == dbrq.DBIndexName {
+		fmt.Printf("Error reading sgfdb directory: %s, %s\n", dbrq.DBIndexName, de.Err)
 		return 2
 	}
+	return 0
+}
+
+// ProcessDatabaseContext is ProcessDatabase with a Context.
+// When ctx is cancelled, no further directories are dispatched,
+// and directories being processed stop at the next file boundary.
+// The errors recorded while processing are kept in dbrq.DBErrors,
+// and returned joined, with ctx.Err() if ctx was cancelled.
+// Each recorded error is a *DirectoryError.
+func ProcessDatabaseContext(ctx context.Context, dbrq *DBProcessRequest) error {
+	// Read the sgfdb directories:
+	dbrq.fsys = nil
+	dirs, err := dbrq.databaseDirs()
+	if err != nil {
+		return &DirectoryError{Dir: dbrq.DBIndexName, Action: "Reading sgfdb directory", Err: err}
+	}
+	dbrq.ctx = ctx
+	dbrq.DBErrors = nil
+	dbrq.result = nil
+	dbrq.totalD, dbrq.totalF, dbrq.totalM, dbrq.totalE = 0, 0, 0, 0
 	reqChan, replyChan, doneChan, finishChan := startServers(dbrq)
 	nRequests := 0
+	nFiles := 0 // number of .sgf files before the current directory, for LimitPerDatabase
 	//	errCount := 0;
 	// Loop:
-	for _, d := range dirs {
-		if len(d.Name()) > 0 && d.Name()[0] != '.' {
-			fileInfo, err := os.Stat(dbrq.DBIndexName + d.Name())
-			if err == nil {
-				if fileInfo.IsDir() {
-					req := DirectoryProcessRequest{i: nRequests, dir: dbrq.DBIndexName + d.Name(), dbReq: dbrq,
-						cntf: 0, cntm: 0,
-						errAct: "", err: nil, reply: replyChan, done: doneChan}
-					reqChan <- &req
-					nRequests++
-				}
-			}
+	for _, rel := range dirs {
+		if ctx.Err() != nil {
+			break
 		}
+		req := DirectoryProcessRequest{i: nRequests, dir: dbrq.dirPath(rel), rel: rel, dbReq: dbrq,
+			cntf: 0, cntm: 0,
+			errAct: "", err: nil, reply: replyChan, done: doneChan}
+		if dbrq.LimitScope == LimitPerDatabase {
+			// the directory is listed once, here, so the limits match the files processed;
+			// errors are reported by ProcessDirectory
+			req.names, req.listErr = sgfFileNames(dbrq.dbFS(), rel)
+			req.listed = true
+			req.setLimits(nFiles)
+			nFiles += len(req.names)
+		} else {
+			req.setLimits(0)
+		}
+		reqChan <- &req
+		nRequests++
 	}
 	// send end packet
 	req := DirectoryProcessRequest{i: -1, dir: "", dbReq: dbrq, checkCount: nRequests}
@@ -414,66 +879,196 @@ func ProcessDatabase(dbrq *DBProcessRequest) int {
 	reqChan <- &req
 	// wait for finished signal from resultServer
 	<-finishChan
-	return 0
+	if dbrq.fileChan != nil {
+		close(dbrq.fileChan) // stop the fileServers
+		dbrq.fileChan = nil
+	}
+	if ctx.Err() != nil {
+		dbrq.DBErrors = append(dbrq.DBErrors, ctx.Err())
+	}
+	return errors.Join(dbrq.DBErrors...)
 }
 
 // CountFilesAndMoves calls ProcessDatabase with CountMoves as the action function.
+// The move counts are approximate: see CountDatabaseMoves for accurate counts.
+// If runParalParallel is set, the files are counted by a fileServer for each CPU.
+// The directories are reported in order.
 func CountFilesAndMoves(db_dir string, fileLimit int, runParalParallel bool, pmode sgf.ParserMode) int {
 	defer un(trace("CountFilesAndMoves"), nil)
 	var dbReq DBProcessRequest
 
-	dbReq.initDBRequest("CountFilesAndMoves", db_dir, "", runParalParallel, false, 1, 0, fileLimit, 0, pmode, CountMoves, ReportDirCounts, ReportDBCounts, sgf.DefaultNumPerLine)
+	dbReq.initDBRequest("CountFilesAndMoves", db_dir, "", runParalParallel, false, 0, 0, fileLimit, 0, pmode, CountMoves, ReportDirCounts, ReportDBCounts, sgf.DefaultNumPerLine)
+	dbReq.Schedule = ScheduleFiles
+	dbReq.OrderedResults = true
 	ret := ProcessDatabase(&dbReq)
 	return ret
 }
 
 func WriteSGFDirectory(r *DirectoryProcessRequest, fName string, b []byte) {
-	d := r.dir
-	idx := strings.LastIndex(d, "/")
-	if idx >= 0 {
-		d = d[idx+1:]
-	}
-	fmt.Printf("%3d:%s, files: %d, tokens: %d", r.i, d, r.cntf, r.cntm)
+	fmt.Printf("%3d:%s, files: %d, tokens: %d", r.i, r.rel, r.cntf, r.cntm)
 	if r.err != nil {
-		fmt.Printf("error: %s%s\n", r.errAct, r.err)
+		fmt.Printf(", error: %s: %s\n", r.errAct, r.err)
 	} else {
 		fmt.Printf("\n")
 	}
-
-	r.dbReq.totalD += 1
-	r.dbReq.totalF += r.cntf
-	r.dbReq.totalM += r.cntm
-	if r.err != nil {
-		r.dbReq.totalE += 1
-	}
 }
 
 func WriteSGFDatabase(r *DirectoryProcessRequest, fName string, b []byte) {
 	fmt.Printf("Total SGF files = %d, tokens = %d", r.dbReq.totalF, r.dbReq.totalM)
 	if r.dbReq.totalE > 0 {
-		fmt.Printf("errors: %d\n", r.dbReq.totalE)
+		fmt.Printf(", errors: %d\n", r.dbReq.totalE)
 	} else {
 		fmt.Printf("\n")
 	}
 }
 
-//
+// An OutputSink receives the files written by Action Functions, such as WriteSGFFile.
+// Names are slash separated paths, relative to the output root.
+type OutputSink interface {
+	WriteFile(name string, data []byte) error
+}
+
+// DirSink is an OutputSink which writes into the operating system directory it names,
+// creating sub-directories as needed.
+type DirSink string
+
+func (d DirSink) WriteFile(name string, data []byte) error {
+	fileName := filepath.Join(string(d), filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(fileName), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0666)
+}
+
+// MapSink is an OutputSink which keeps the files in memory, i.e. for testing.
+type MapSink struct {
+	mu    sync.Mutex
+	Files map[string][]byte
+}
+
+func (m *MapSink) WriteFile(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Files == nil {
+		m.Files = make(map[string][]byte)
+	}
+	m.Files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// WriteOutput writes data as the file fName of the output directory
+// corresponding to the directory being processed.
+// It uses the Output sink, if set, else the DBOutName directory.
+func (req *DirectoryProcessRequest) WriteOutput(fName string, data []byte) error {
+	sink := req.dbReq.Output
+	if sink == nil {
+		sink = DirSink(req.dbReq.DBOutName)
+	}
+	return sink.WriteFile(path.Join(req.rel, fName), data)
+}
+
+// parseMu serialises the calls of sgf.ParseFile.
+var parseMu sync.Mutex
+
+// parseSGF calls sgf.ParseFile, with parseMu held. sgf.ParseFile is not safe
+// for concurrent use: it adds up the counts reported by sgf.ReportSGFCounts
+// in package variables. The Action Functions call parseSGF, so the rest of
+// their work is done in parallel.
+func parseSGF(fileName string, b []byte, pMode sgf.ParserMode, moveLimit int) (*sgf.Parser, ah.ErrorList) {
+	parseMu.Lock()
+	defer parseMu.Unlock()
+	return sgf.ParseFile(fileName, b, pMode, moveLimit)
+}
+
+// stdoutMu serializes the redirections of os.Stdout by captureStdout.
+var stdoutMu sync.Mutex
+
+// captureStdout calls f with os.Stdout redirected to a pipe, and returns
+// what was written to it. sgf.ParseFile prints its errors, as well as
+// returning them, and would mix them with the output of a command,
+// i.e. the JSON lines of sgfdb lint. What other goroutines print
+// meanwhile is captured too.
+func captureStdout(f func()) string {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		f()
+		return ""
+	}
+	done := make(chan string, 1)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, rd)
+		rd.Close()
+		done <- buf.String()
+	}()
+	stdout := os.Stdout
+	os.Stdout = wr
+	func() {
+		defer func() {
+			os.Stdout = stdout
+			wr.Close()
+		}()
+		f()
+	}()
+	return <-done
+}
+
+// gameTreeBytes returns the .sgf file written by gt.WriteFile.
+// sgf.GameTree is only written by WriteFile, to a named file, not to an io.Writer,
+// so the copy goes through a temporary file, which is removed.
+func gameTreeBytes(gt *sgf.GameTree, numPerLine int) ([]byte, error) {
+	f, err := ioutil.TempFile("", "sgfdb*.sgf")
+	if err != nil {
+		return nil, err
+	}
+	tmpName := f.Name()
+	f.Close()
+	defer os.Remove(tmpName)
+	err = gt.WriteFile(tmpName, numPerLine)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(tmpName)
+}
+
+// WriteSGFFile parses the file, and writes a copy of it in the output directory,
+// or to the Output sink, if set.
+// If VerifyOutput is set, and there is no MoveLimit, the copy is parsed,
+// and its tree compared with the tree of the file, and a difference is
+// recorded as an error.
+// If Normalize is set, the copy is the file in the canonical form of NormalizeSGF.
 func WriteSGFFile(r *DirectoryProcessRequest, fName string, b []byte) {
 
 	fullFileName := r.dir + "/" + fName
-	prsr, errL := sgf.ParseFile(fullFileName, b, r.dbReq.PModeReq, r.dbReq.MoveLimit)
+	prsr, errL := parseSGF(fullFileName, b, r.dbReq.PModeReq, r.dbReq.MoveLimit)
 	r.cntf += 1
 	if len(errL) != 0 {
 		fmt.Printf("%s Error(s) during parsing: %s\n", r.dbReq.Requester, fullFileName)
 		ah.PrintError(os.Stdout, errL)
+		r.FileError(fName, "Parsing", errL)
 		return // cntF, cntT, cntE, errL // stop on first error?
 	}
-	inDir := r.dir
-	idx := strings.LastIndex(inDir, "/")
-	if idx >= 0 {
-		inDir = inDir[idx+1:]
+	if r.dbReq.Normalize {
+		r.writeNormalized(fName, b)
+		return
 	}
-	outDir := r.dbReq.DBOutName + inDir
+	if r.dbReq.Output != nil {
+		out, err := gameTreeBytes(&prsr.GameTree, r.dbReq.NumPerLine)
+		if err == nil {
+			err = r.WriteOutput(fName, out)
+		}
+		if err != nil {
+			fmt.Printf("%s Error writing: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+			r.FileError(fName, "Writing", err)
+			return
+		}
+		r.verifyOutput(fName, &prsr.GameTree, out)
+		return
+	}
+	outDir := r.dbReq.DBOutName + r.rel
 	outFileName := outDir + "/" + fName
 	// Check the output directory. If missing, create it.
 	_, errS := os.Stat(outDir)
@@ -482,26 +1077,232 @@ func WriteSGFFile(r *DirectoryProcessRequest, fName string, b []byte) {
 		if err2 != nil {
 			fmt.Println(r.dbReq.Requester, "Error:", err2, "trying to create test output directory:", outDir)
 			fmt.Println("Original Error:", errS, "trying os.Stat")
+			r.FileError(fName, "Creating output directory", err2)
 			return // cntF, cntT, cntE, err2 // stop on first error?
 		}
 	}
 	err := prsr.GameTree.WriteFile(outFileName, r.dbReq.NumPerLine)
 	if err != nil {
 		fmt.Printf("%s Error writing: %s, %s\n", r.dbReq.Requester, outFileName, err)
+		r.FileError(fName, "Writing", err)
 		return // cntF, cntT, cntE, err
 	}
+	if r.dbReq.VerifyOutput && r.dbReq.MoveLimit == 0 {
+		out, err := ioutil.ReadFile(outFileName)
+		if err != nil {
+			r.FileError(fName, "Verifying", err)
+			return
+		}
+		r.verifyOutput(fName, &prsr.GameTree, out)
+	}
+}
+
+// verifyOutput parses the copy written, out, of the file fName, and compares
+// its tree with gt, the tree of the file, if VerifyOutput is set, and there
+// is no MoveLimit.
+func (r *DirectoryProcessRequest) verifyOutput(fName string, gt *sgf.GameTree, out []byte) {
+	if !r.dbReq.VerifyOutput || r.dbReq.MoveLimit != 0 {
+		return
+	}
+	if err := r.compareGameTrees(fName, gt, out); err != nil {
+		fmt.Printf("%s Copy differs: %s, %s\n", r.dbReq.Requester, path.Join(r.rel, fName), err)
+		r.FileError(fName, "Verifying", err)
+	}
 }
 
-// ReadDirectoryAndBuildPatterns
+// compareGameTrees parses out, the copy of the file fName, with the parser
+// mode of the request, and compares its tree with gt. sgf.GameTree exports
+// neither its nodes nor its properties, so the files WriteFile writes for
+// the two trees are read by readSGF, and compared by CompareSGF, node by
+// node and property by property; the first difference is returned, as an
+// *SGFDiff giving the node and the property.
+func (r *DirectoryProcessRequest) compareGameTrees(fName string, gt *sgf.GameTree, out []byte) error {
+	prsr, errL := parseSGF(path.Join(r.dir, fName), out, r.dbReq.PModeReq, 0)
+	if len(errL) != 0 {
+		return errL
+	}
+	want, err := gameTreeBytes(gt, r.dbReq.NumPerLine)
+	if err != nil {
+		return err
+	}
+	got, err := gameTreeBytes(&prsr.GameTree, r.dbReq.NumPerLine)
+	if err != nil {
+		return err
+	}
+	return CompareSGF(want, got)
+}
+
+// PatternTrees are the pattern trees of the games of a database, by handicap,
+// as ReadTeachingDirectory builds the whole board patterns of teaching games.
+type PatternTrees [10]*sgf.GameTree
+
+// ErrPatternType is returned for a pattern type other than ah.WHOLE_BOARD_PATTERN,
+// the patterns sgf's AddTeachingPattern builds.
+var ErrPatternType = errors.New("only whole board patterns can be built")
+
+// patternTreeBuilder adds the games of a database to the pattern trees
+// of their handicaps. The files are parsed, and added, one at a time.
+type patternTreeBuilder struct {
+	trees  PatternTrees
+	typ    ah.PatternType
+	nGames [10]int // games added, by handicap
+}
+
+// patternTreeFile returns the name of the file holding the pattern tree
+// of type typ, of the games with handicap ha, in the directory dir.
+func patternTreeFile(dir string, typ ah.PatternType, ha int) string {
+	return filepath.Join(dir, fmt.Sprintf("Patterns_%v_HA_%d.sgf", typ, ha))
+}
+
+// loadPatternTree reads the pattern tree written to fileName.
+// If there is no such file, it returns nil, and no error.
+func loadPatternTree(fileName string) (*sgf.GameTree, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prsr, errL := parseSGF(fileName, b, sgf.ParseComments, 0)
+	if len(errL) != 0 {
+		return nil, errL
+	}
+	return &prsr.GameTree, nil
+}
+
+// loadPatternTrees reads the pattern trees of type typ saved in dir.
+func loadPatternTrees(dir string, typ ah.PatternType) (trees PatternTrees, err error) {
+	for ha := range trees {
+		trees[ha], err = loadPatternTree(patternTreeFile(dir, typ, ha))
+		if err != nil {
+			return trees, err
+		}
+	}
+	return trees, nil
+}
+
+// savePatternTree writes the pattern tree to fileName, creating its directory.
+func savePatternTree(fileName string, tree *sgf.GameTree) error {
+	err := os.MkdirAll(filepath.Dir(fileName), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return tree.WriteFile(fileName, sgf.DefaultNumPerLine)
+}
+
+// save writes the pattern trees built to dir, and reports the files written.
+func (pb *patternTreeBuilder) save(dir string) error {
+	var errs []error
+	for ha, tree := range pb.trees {
+		if tree == nil {
+			continue
+		}
+		fileName := patternTreeFile(dir, pb.typ, ha)
+		if err := savePatternTree(fileName, tree); err != nil {
+			fmt.Printf("Error writing pattern tree: %s, %s\n", fileName, err)
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("Patterns of %d games with handicap %d written to: %s\n", pb.nGames[ha], ha, fileName)
+	}
+	return errors.Join(errs...)
+}
+
+// add parses the file, named fileName, and adds its patterns to the tree
+// of its handicap.
+func (pb *patternTreeBuilder) add(fileName string, b []byte, pMode sgf.ParserMode, moveLimit int) error {
+	prsr, errL := parseSGF(fileName, b, pMode, moveLimit)
+	if len(errL) != 0 {
+		fmt.Printf("Error(s) during parsing: %s\n", fileName)
+		ah.PrintError(os.Stdout, errL)
+		return errL
+	}
+	ha := prsr.GetHA()
+	if ha < 0 || ha >= len(pb.trees) {
+		return fmt.Errorf("handicap %d out of range", ha)
+	}
+	nCol, nRow := prsr.GetSize()
+	errL, _, newTree := prsr.AddTeachingPattern(nCol, nRow, ha, pb.trees[ha], pb.typ, moveLimit, 0, 0)
+	if len(errL) != 0 {
+		fmt.Printf("Error adding patterns: %s, %s\n", fileName, errL.Error())
+		return errL
+	}
+	pb.trees[ha] = newTree
+	pb.nGames[ha] += 1
+	return nil
+}
+
+// addPatterns is the Action Function adding the patterns of a file.
+func (pb *patternTreeBuilder) addPatterns(r *DirectoryProcessRequest, fName string, b []byte) {
+	r.cntf += 1
+	err := pb.add(r.dir+"/"+fName, b, r.dbReq.PModeReq, r.dbReq.MoveLimit)
+	if err != nil {
+		r.FileError(fName, "Adding patterns", err)
+	}
+}
+
+// ReadDirectoryAndBuildPatterns is ReadDirectoryAndBuildPatternTrees, with
+// patternTree the tree of the games without handicap, which is returned.
+// The games with a handicap are added to the trees saved in Pattern_dir,
+// if it is not empty.
 func ReadDirectoryAndBuildPatterns(dir_Name string, subDir_Name string, Pattern_dir string, patternTree *sgf.GameTree, pattern_typ ah.PatternType, fileLimit int, moveLimit int, skipFiles int) (*sgf.GameTree, error) {
+	var trees *PatternTrees
+	if patternTree != nil {
+		trees = new(PatternTrees)
+		if Pattern_dir != "" && pattern_typ == ah.WHOLE_BOARD_PATTERN {
+			var err error
+			*trees, err = loadPatternTrees(Pattern_dir, pattern_typ)
+			if err != nil {
+				fmt.Printf("Error reading pattern trees: %s, %s\n", Pattern_dir, err)
+				return patternTree, err
+			}
+		}
+		trees[0] = patternTree
+	}
+	trees, err := ReadDirectoryAndBuildPatternTrees(dir_Name, subDir_Name, Pattern_dir, trees, pattern_typ, fileLimit, moveLimit, skipFiles)
+	if trees == nil {
+		return patternTree, err
+	}
+	return trees[0], err
+}
+
+// ReadDirectoryAndBuildPatternTrees adds the patterns of type pattern_typ of the
+// games in the directory subDir_Name of dir_Name to the patternTrees of
+// their handicaps, and returns the trees.
+// If patternTrees is nil, and Pattern_dir is not empty, the trees saved in
+// Pattern_dir are extended, if there are any.
+// If Pattern_dir is not empty, the trees are saved in Pattern_dir.
+// Only whole board patterns can be built, else ErrPatternType is returned.
+func ReadDirectoryAndBuildPatternTrees(dir_Name string, subDir_Name string, Pattern_dir string, patternTrees *PatternTrees, pattern_typ ah.PatternType, fileLimit int, moveLimit int, skipFiles int) (*PatternTrees, error) {
+	return readDirectoryAndBuildPatterns(os.DirFS(dir_Name), dir_Name, subDir_Name, Pattern_dir, patternTrees, pattern_typ, fileLimit, moveLimit, skipFiles)
+}
+
+// readDirectoryAndBuildPatterns reads the directory subDir_Name of fsys,
+// which is named dir_Name.
+func readDirectoryAndBuildPatterns(fsys fs.FS, dir_Name string, subDir_Name string, Pattern_dir string, patternTrees *PatternTrees, pattern_typ ah.PatternType, fileLimit int, moveLimit int, skipFiles int) (*PatternTrees, error) {
 	defer un(trace("ReadDirectoryAndBuildPatterns"), nil)
+	if pattern_typ != ah.WHOLE_BOARD_PATTERN {
+		return patternTrees, ErrPatternType
+	}
+	pb := patternTreeBuilder{typ: pattern_typ}
 	var err error
+	if patternTrees != nil {
+		pb.trees = *patternTrees
+	} else if Pattern_dir != "" {
+		pb.trees, err = loadPatternTrees(Pattern_dir, pattern_typ)
+		if err != nil {
+			fmt.Printf("Error reading pattern trees: %s, %s\n", Pattern_dir, err)
+			return nil, err
+		}
+	}
 	fmt.Printf("Reading directory %s\n", subDir_Name)
-	fils, err := ioutil.ReadDir(dir_Name + subDir_Name)
+	fils, err := fs.ReadDir(fsys, subDir_Name)
 	if err != nil && err != io.EOF {
 		fmt.Printf("Error reading Database directory: %s, %s\n", dir_Name+subDir_Name, err)
-		return patternTree, err
+		return &pb.trees, err
 	}
+	var errs []error
 	filesRead := 0
 	for i, fil := range fils {
 		if strings.Index(fil.Name(), ".sgf") >= 0 {
@@ -513,33 +1314,20 @@ func ReadDirectoryAndBuildPatterns(dir_Name string, subDir_Name string, Pattern_
 					filesRead += 1
 					fmt.Printf("Reading file %d, index %d: %s\n", filesRead, i, fil.Name())
 					fileName := dir_Name + subDir_Name + "/" + fil.Name()
-					b, err := ioutil.ReadFile(fileName)
+					b, err := fs.ReadFile(fsys, subDir_Name+"/"+fil.Name())
 					if err != nil && err != io.EOF {
 						fmt.Printf("Error reading File: %s, %s\n", fileName, err)
-						return patternTree, err
+						return &pb.trees, err
 					}
-					// Use first call to turn on tracing, second to play while reading, third for GoGoD checking:
+					// Use first mode to turn on tracing, second to play while reading, third for GoGoD checking:
 					// and combinations.
-					//			prsr,errL := sgf.ParseFile(fileName, b, sgf.ParseComments+sgf.TraceParser, moveLimit)
-					//			prsr,errL := sgf.ParseFile(fileName, b, sgf.ParseComments+sgf.ParserPlay+sgf.ParserDbStat, moveLimit)
-					//			prsr,errL := sgf.ParseFile(fileName, b, sgf.ParseComments+sgf.ParserGoGoD, moveLimit)
-					/* prsr */ _, errL := sgf.ParseFile(fileName, b, sgf.ParseComments+sgf.ParserGoGoD+sgf.ParserPlay, moveLimit)
-					if len(errL) != 0 {
-						fmt.Printf("Error(s) during parsing: %s\n", fileName)
-						ah.PrintError(os.Stdout, errL)
-						return patternTree, err // stop on first error?
+					//			sgf.ParseComments+sgf.TraceParser
+					//			sgf.ParseComments+sgf.ParserPlay+sgf.ParserDbStat
+					//			sgf.ParseComments+sgf.ParserGoGoD
+					err = pb.add(fileName, b, sgf.ParseComments+sgf.ParserGoGoD+sgf.ParserPlay, moveLimit)
+					if err != nil {
+						errs = append(errs, &DirectoryError{Dir: dir_Name + subDir_Name, File: fil.Name(), Action: "Adding patterns", Err: err})
 					}
-					/* TODO: replace this "write output" logic with pattern add logic
-					   if outDir != "" {
-					       outFileName := outDir + "/" + f.Name()
-					       err = prsr.GameTree.WriteFile(outFileName, dbReq.NumPerLine)
-					       if err != nil {
-					           fmt.Printf("Error writing: %s, %s\n", outFileName, err)
-					           return patternTree, err
-					       }
-					   }
-					*/
-
 				} else {
 					fmt.Printf("file limit reached: %d\n", filesRead)
 					break
@@ -547,37 +1335,65 @@ func ReadDirectoryAndBuildPatterns(dir_Name string, subDir_Name string, Pattern_
 			}
 		}
 	}
-
-	return patternTree, err
+	if Pattern_dir != "" {
+		if err = pb.save(Pattern_dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return &pb.trees, errors.Join(errs...)
 }
 
-// ReadDatabaseAndBuildPatterns
+// ReadDatabaseAndBuildPatterns builds the pattern trees of type pattern_typ
+// of the games in the directories of db_dir, one for each handicap,
+// and saves them in pattern_dir. If trees of that type were saved in
+// pattern_dir, they are extended.
+// It returns 2 if db_dir cannot be read, 3 if a tree cannot be read or written,
+// 4 if pattern_typ is not ah.WHOLE_BOARD_PATTERN, and 0 otherwise.
 func ReadDatabaseAndBuildPatterns(db_dir string, pattern_dir string, pattern_typ ah.PatternType, fileLimit int, moveLimit int, skipFiles int) (status int) {
+	return ReadDatabaseAndBuildPatternsFS(os.DirFS(db_dir), db_dir, pattern_dir, pattern_typ, fileLimit, moveLimit, skipFiles)
+}
+
+// ReadDatabaseAndBuildPatternsFS is ReadDatabaseAndBuildPatterns for a database
+// held in fsys, which is named db_dir in messages.
+// The directories are read one at a time, and their files parsed, and added,
+// one at a time, with fileLimit, moveLimit, and skipFiles applied to each directory.
+// The trees of the directories are not built in parallel, to be merged:
+// sgf adds a parsed game to a tree, with AddTeachingPattern, but cannot merge
+// two trees, and sgf.ParseFile is called one file at a time, by parseSGF.
+func ReadDatabaseAndBuildPatternsFS(fsys fs.FS, db_dir string, pattern_dir string, pattern_typ ah.PatternType, fileLimit int, moveLimit int, skipFiles int) (status int) {
 	defer un(trace("ReadDatabaseAndBuildPatterns"), nil)
-	dirs, err := ioutil.ReadDir(db_dir)
-	if err != nil && err != io.EOF {
-		fmt.Printf("Error reading sgfdb directory: %s, %s\n", db_dir, err)
-		return 2
+	if pattern_typ != ah.WHOLE_BOARD_PATTERN {
+		fmt.Printf("Error building patterns of type %v: %s\n", pattern_typ, ErrPatternType)
+		return 4
 	}
-	fmt.Printf("Reading database directory: %s for %s\n", db_dir, pattern_dir)
-	var patternTree *sgf.GameTree
-	// TODO: logic to see if there is one on disk, and read it?
-	for i, dir := range dirs {
-		if dir.IsDir() && dir.Name()[0] != '.' {
-			fmt.Printf("Reading directory %d. %s \n", i, dir.Name())
-			patternTree, err = ReadDirectoryAndBuildPatterns(db_dir, dir.Name(), pattern_dir, patternTree, pattern_typ, fileLimit, moveLimit, skipFiles)
-			if err != nil {
-				fmt.Printf("Error reading directory: %s, %s\n", dir.Name(), err)
-			}
-		} else {
-			fmt.Printf("Not a subdirectory: %d. %s \n", i, dir.Name())
+	trees, err := loadPatternTrees(pattern_dir, pattern_typ)
+	if err != nil {
+		fmt.Printf("Error reading pattern trees: %s, %s\n", pattern_dir, err)
+		return 3
+	}
+	for ha, tree := range trees {
+		if tree != nil {
+			fmt.Printf("Extending pattern tree: %s\n", patternTreeFile(pattern_dir, pattern_typ, ha))
 		}
 	}
+	fmt.Printf("Reading database directory: %s for %s\n", db_dir, pattern_dir)
+	pb := patternTreeBuilder{trees: trees, typ: pattern_typ}
+	var dbReq DBProcessRequest
+	dbReq.initDBRequest("ReadDatabaseAndBuildPatterns", db_dir, "", false, false, 1, skipFiles, fileLimit, moveLimit, sgf.ParseComments+sgf.ParserGoGoD+sgf.ParserPlay, pb.addPatterns, nil, nil, sgf.DefaultNumPerLine)
+	dbReq.DBFS = fsys
+	status = ProcessDatabase(&dbReq)
+	if status == 2 {
+		return status
+	}
+	if pb.save(pattern_dir) != nil {
+		return 3
+	}
 	return status
 }
 
 // ReadAndWriteDatabase builds a DBProcessRequest
-// and passes it to ProcessDataBase.
+// and passes it to ProcessDataBase. The files are written by a fileServer
+// for each CPU, and parsed one at a time, by parseSGF.
 func ReadAndWriteDatabase(db_dir string, testout_dir string, fileLimit int, moveLimit int, skipFiles int, pMode sgf.ParserMode) int {
 
 	defer un(trace("ReadAndWriteDatabase"), nil)
@@ -587,17 +1403,25 @@ func ReadAndWriteDatabase(db_dir string, testout_dir string, fileLimit int, move
 	fmt.Printf("Reading and writing database, db_dir = %v, testout_dir = %v\n",
 		db_dir, testout_dir)
 
-	dbReq.initDBRequest("ReadAndWriteDatabase", db_dir, testout_dir, false, false, 1, skipFiles, fileLimit, moveLimit, pMode|sgf.ParserGoGoD|sgf.ParserPlay, WriteSGFFile, WriteSGFDirectory, WriteSGFDatabase, sgf.DefaultNumPerLine)
+	dbReq.initDBRequest("ReadAndWriteDatabase", db_dir, testout_dir, true, false, 0, skipFiles, fileLimit, moveLimit, pMode|sgf.ParserGoGoD|sgf.ParserPlay, WriteSGFFile, WriteSGFDirectory, WriteSGFDatabase, sgf.DefaultNumPerLine)
+	dbReq.Schedule = ScheduleFiles
+	dbReq.OrderedResults = true
 	ret := ProcessDatabase(&dbReq)
 	return ret
 }
 
 func ReadTeachingDirectory(teachDir string, teachPatsDir string, fileLimit int, moveLimit int, patternLimit int, skipFiles int) (status int) {
+	return ReadTeachingDirectoryFS(os.DirFS(teachDir), teachDir, teachPatsDir, fileLimit, moveLimit, patternLimit, skipFiles)
+}
+
+// ReadTeachingDirectoryFS is ReadTeachingDirectory for teaching games
+// held in fsys, which is named teachDir in messages.
+func ReadTeachingDirectoryFS(fsys fs.FS, teachDir string, teachPatsDir string, fileLimit int, moveLimit int, patternLimit int, skipFiles int) (status int) {
 	defer un(trace("ReadTeachingDirectory"), nil)
 	var haCounts [10]int
 	var haWholeBoards [10]*sgf.GameTree
 
-	fils, err := ioutil.ReadDir(teachDir)
+	fils, err := fs.ReadDir(fsys, ".")
 	if err != nil && err != io.EOF {
 		fmt.Printf("Error reading Teaching directory: %s, %s\n", teachDir, err)
 		return 2
@@ -609,12 +1433,12 @@ func ReadTeachingDirectory(teachDir string, teachPatsDir string, fileLimit int,
 				skipFiles -= 1
 			} else {
 				fileName := teachDir + fil.Name()
-				b, err := ioutil.ReadFile(fileName)
+				b, err := fs.ReadFile(fsys, fil.Name())
 				if err != nil && err != io.EOF {
 					fmt.Printf("Error reading teaching File %d: %s, %s\n", i, fileName, err)
 					return 3
 				}
-				prsr, errL := sgf.ParseFile(fileName, b, sgf.ParseComments+sgf.ParserGoGoD+sgf.ParserPlay, moveLimit)
+				prsr, errL := parseSGF(fileName, b, sgf.ParseComments+sgf.ParserGoGoD+sgf.ParserPlay, moveLimit)
 				if len(errL) != 0 {
 					fmt.Printf("Error %s during parsing: %s\n", errL.Error(), fileName)
 					return 4
@@ -1,12 +1,22 @@
 package sgfdb_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/Ken1JF/ah"
 	"github.com/Ken1JF/sgf"
 	. "github.com/Ken1JF/sgfdb"
+	"io/fs"
 	"os"
-	"runtime"
-	//	"testing"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
 )
 
 const gogod_dir = "/usr/local/GoGoD"
@@ -18,7 +28,7 @@ func ExampleSgfDbTypeSizes() {
 	PrintSgfDbTypeSizes()
 	// Output:
 	// Type TraceRec size 40 alignment 8
-	// Type DirectoryProcessRequest size 104 alignment 8
+	// Type DirectoryProcessRequest size 224 alignment 8
 }
 
 // Expected output when the link in /usr/local is in place: GoGoD -> /Users/ken/Documents/GO/GoGoD
@@ -61,22 +71,15 @@ func ExampleReadDatabaseCountMoves() {
 
 	fmt.Println("running ExampleReadDatabaseCountMoves, OK accessing:", useDir)
 
-	// Need to run this with 1 CPU to get reproducible results
-	oldMaxProcs := 0
-	nCPUsOnMachine := runtime.NumCPU()
-	if nCPUsOnMachine > 1 {
-		oldMaxProcs = runtime.GOMAXPROCS(1)
-		fmt.Printf("num CPUs on Machine = %d, default max Procs was %d, now set to 1\n", nCPUsOnMachine, oldMaxProcs)
-	} else {
-		fmt.Printf("num CPUs on Machine = %d.\n", nCPUsOnMachine)
-	}
+	// CountFilesAndMoves reports the directories in order,
+	// so the results are reproducible with any number of CPUs.
 
 	// do not ask for verification of SGF Specification file,
 	// or ask for verbose output. These are done in sgf_test.go
 	// If that test is ok, then the file is ok.
 	errN := sgf.SetupSGFProperties(SGFSpecFile, false, false)
 	if errN == 0 {
-		CountFilesAndMoves(useDir, 0, false, sgf.DefaultParserMode)
+		CountFilesAndMoves(useDir, 0, true, sgf.DefaultParserMode)
 	}
 
 	// If testout was linked, unlink it.
@@ -86,19 +89,12 @@ func ExampleReadDatabaseCountMoves() {
 			fmt.Printf("ExampleReadDatabaseCountMoves, Error: %s attempting to remove symbolic link to:%s\n", err, useDir+"testout")
 		}
 	}
-	// if GOMAXPROCS was changed, set it back
-	if oldMaxProcs != 0 {
-		fmt.Printf(" max Procs set back to %d.\n", oldMaxProcs)
-		runtime.GOMAXPROCS(oldMaxProcs)
-	}
 	// Output:
 	// Not doing GoGoD ExampleReadDatabaseCountMoves: stat /usr/local/GoGoD: no such file or directory accessing: /usr/local/GoGoD
 	// running ExampleReadDatabaseCountMoves, OK accessing: ../sgf/
-	// num CPUs on Machine = 4, default max Procs was 1, now set to 1
-	//   0:testdata, files: 55, moves: 9127
-	//   1:testout, files: 55, moves: 9127
-	// Total SGF files = 110, total moves = 18254
-	//  max Procs set back to 1.
+	//   0:testdata, files: 55, approximate moves: 9127
+	//   1:testout, files: 55, approximate moves: 9127
+	// Total SGF files = 110, total approximate moves = 18254
 }
 
 // This Output: is the expected output when /usr/local/GoGoD does not exist:
@@ -107,57 +103,55 @@ func ExampleReadDatabaseCountMoves() {
 
 // Output:
 // running ExampleReadDatabaseCountMoves, OK accessing: /usr/local/GoGoD/Go/Database/
-// num CPUs on Machine = 4, default max Procs was 1, now set to 1
-//   0:0196-1699, files: 784, moves: 149431
-//   1:1700-99, files: 1195, moves: 248166
-//   2:1800-49, files: 1254, moves: 232289
-//   3:1850-99, files: 1618, moves: 290802
-//   4:1900-09, files: 412, moves: 81389
-//   5:1910-19, files: 379, moves: 73195
-//   6:1920-29, files: 895, moves: 178732
-//   7:1930-39, files: 2224, moves: 469415
-//   8:1940-49, files: 743, moves: 155292
-//   9:1950-59, files: 1636, moves: 343773
-//  10:1960-69, files: 3178, moves: 647853
-//  11:1970-75, files: 2201, moves: 442334
-//  12:1976-79, files: 2126, moves: 429100
-//  13:1980, files: 804, moves: 156099
-//  14:1981, files: 651, moves: 129838
-//  15:1982, files: 718, moves: 140165
-//  16:1983, files: 862, moves: 174136
-//  17:1984, files: 744, moves: 154795
-//  18:1985, files: 978, moves: 202844
-//  19:1986, files: 1006, moves: 211331
-//  20:1987, files: 963, moves: 194109
-//  21:1988, files: 1144, moves: 236411
-//  22:1989, files: 1414, moves: 288660
-//  23:1990, files: 1377, moves: 283506
-//  24:1991, files: 1279, moves: 265952
-//  25:1992, files: 1374, moves: 286559
-//  26:1993, files: 1383, moves: 294227
-//  27:1994, files: 1326, moves: 278442
-//  28:1995, files: 1620, moves: 343337
-//  29:1996, files: 1873, moves: 393597
-//  30:1997, files: 1691, moves: 353836
-//  31:1998, files: 1734, moves: 361426
-//  32:1999, files: 1407, moves: 293231
-//  33:2000, files: 1659, moves: 343388
-//  34:2001, files: 2007, moves: 421291
-//  35:2002, files: 1728, moves: 363739
-//  36:2003, files: 2013, moves: 429808
-//  37:2004, files: 1949, moves: 414287
-//  38:2005, files: 1875, moves: 401196
-//  39:2006, files: 2778, moves: 598914
-//  40:2007, files: 2716, moves: 578552
-//  41:2008, files: 2985, moves: 641211
-//  42:2009, files: 2622, moves: 563081
-//  43:2010, files: 2635, moves: 569596
-//  44:2011, files: 2219, moves: 473040
-//  45:2012, files: 0, moves: 0
-//  46:GoLibrary2, files: 0, moves: 0
-//  47:Onomasticon2, files: 0, moves: 0
-// Total SGF files = 70179, total moves = 14582375
-//  max Procs set back to 1.
+//   0:0196-1699, files: 784, approximate moves: 149431
+//   1:1700-99, files: 1195, approximate moves: 248166
+//   2:1800-49, files: 1254, approximate moves: 232289
+//   3:1850-99, files: 1618, approximate moves: 290802
+//   4:1900-09, files: 412, approximate moves: 81389
+//   5:1910-19, files: 379, approximate moves: 73195
+//   6:1920-29, files: 895, approximate moves: 178732
+//   7:1930-39, files: 2224, approximate moves: 469415
+//   8:1940-49, files: 743, approximate moves: 155292
+//   9:1950-59, files: 1636, approximate moves: 343773
+//  10:1960-69, files: 3178, approximate moves: 647853
+//  11:1970-75, files: 2201, approximate moves: 442334
+//  12:1976-79, files: 2126, approximate moves: 429100
+//  13:1980, files: 804, approximate moves: 156099
+//  14:1981, files: 651, approximate moves: 129838
+//  15:1982, files: 718, approximate moves: 140165
+//  16:1983, files: 862, approximate moves: 174136
+//  17:1984, files: 744, approximate moves: 154795
+//  18:1985, files: 978, approximate moves: 202844
+//  19:1986, files: 1006, approximate moves: 211331
+//  20:1987, files: 963, approximate moves: 194109
+//  21:1988, files: 1144, approximate moves: 236411
+//  22:1989, files: 1414, approximate moves: 288660
+//  23:1990, files: 1377, approximate moves: 283506
+//  24:1991, files: 1279, approximate moves: 265952
+//  25:1992, files: 1374, approximate moves: 286559
+//  26:1993, files: 1383, approximate moves: 294227
+//  27:1994, files: 1326, approximate moves: 278442
+//  28:1995, files: 1620, approximate moves: 343337
+//  29:1996, files: 1873, approximate moves: 393597
+//  30:1997, files: 1691, approximate moves: 353836
+//  31:1998, files: 1734, approximate moves: 361426
+//  32:1999, files: 1407, approximate moves: 293231
+//  33:2000, files: 1659, approximate moves: 343388
+//  34:2001, files: 2007, approximate moves: 421291
+//  35:2002, files: 1728, approximate moves: 363739
+//  36:2003, files: 2013, approximate moves: 429808
+//  37:2004, files: 1949, approximate moves: 414287
+//  38:2005, files: 1875, approximate moves: 401196
+//  39:2006, files: 2778, approximate moves: 598914
+//  40:2007, files: 2716, approximate moves: 578552
+//  41:2008, files: 2985, approximate moves: 641211
+//  42:2009, files: 2622, approximate moves: 563081
+//  43:2010, files: 2635, approximate moves: 569596
+//  44:2011, files: 2219, approximate moves: 473040
+//  45:2012, files: 0, approximate moves: 0
+//  46:GoLibrary2, files: 0, approximate moves: 0
+//  47:Onomasticon2, files: 0, approximate moves: 0
+// Total SGF files = 70179, total approximate moves = 14582375
 
 // ExampleReadWriteDatabase will use the GoGoD database if present.
 // If not, it will use the directories found in ../sgf/.
@@ -222,16 +216,6 @@ func ExampleReadWriteDatabase() {
 
 	fmt.Println("Running ExampleReadWriteDatabase, OK using:", useDir, "with output to:", testOutDir)
 
-	// Need to run this with 1 CPU to get reproducible results
-	oldMaxProcs := 0
-	nCPUsOnMachine := runtime.NumCPU()
-	if nCPUsOnMachine > 1 {
-		oldMaxProcs = runtime.GOMAXPROCS(1)
-		fmt.Printf("num CPUs on Machine = %d, default max Procs was %d, now set to 1\n", nCPUsOnMachine, oldMaxProcs)
-	} else {
-		fmt.Printf("num CPUs on Machine = %d.\n", nCPUsOnMachine)
-	}
-
 	// do not ask for verification of SGF Specification file,
 	// or ask for verbose output. These are done in sgf_test.go
 	// If that test is ok, then the file is ok.
@@ -254,19 +238,12 @@ func ExampleReadWriteDatabase() {
 		}
 	}
 
-	// if GOMAXPROCS was changed, set it back
-	if oldMaxProcs != 0 {
-		fmt.Printf(" max Procs set back to %d.\n", oldMaxProcs)
-		runtime.GOMAXPROCS(oldMaxProcs)
-	}
-
 	// Output:
 	// Not doing GoGoD ExampleReadWriteDatabase: stat /usr/local/GoGoD: no such file or directory accessing: /usr/local/GoGoD
 	// Running ExampleReadWriteDatabase, OK using: ../sgf/ with output to: ../sgfdb/dbout/
-	// num CPUs on Machine = 4, default max Procs was 1, now set to 1
-	//   0:testdata, files: 55, moves: 9127
-	//   1:testout, files: 55, moves: 9127
-	// Total SGF files = 110, total moves = 18254
+	//   0:testdata, files: 55, approximate moves: 9127
+	//   1:testout, files: 55, approximate moves: 9127
+	// Total SGF files = 110, total approximate moves = 18254
 	// Reading and writing database, db_dir = ../sgf/, testout_dir = ../sgfdb/dbout/
 	//   0:testdata, files: 55, tokens: 0
 	//   1:testout, files: 55, tokens: 0
@@ -413,7 +390,6 @@ func ExampleReadWriteDatabase() {
 	//  2 : Zhang Xuebin, first: 1019, 4p, last: 1019, 4p
 	//  2 : Ch'oe Myeong-hun, first: 1006, 4p, last: 1006, 4p
 	//  2 : Ding Wei, first: 1021, 5p, last: 1021, 5p
-	//  max Procs set back to 1.
 }
 
 // the Output: for ExampleReadWriteDatabase when /usr/local/GoGoD does not exit:
@@ -422,56 +398,55 @@ func ExampleReadWriteDatabase() {
 
 // Output:
 // Running ExampleReadWriteDatabase, OK using: /usr/local/GoGoD/Go/Database/ with output to: /usr/local/GoGoD/dbout/
-// num CPUs on Machine = 4, default max Procs was 1, now set to 1
-//   0:0196-1699, files: 784, moves: 149431
-//   1:1700-99, files: 1195, moves: 248166
-//   2:1800-49, files: 1254, moves: 232289
-//   3:1850-99, files: 1618, moves: 290802
-//   4:1900-09, files: 412, moves: 81389
-//   5:1910-19, files: 379, moves: 73195
-//   6:1920-29, files: 895, moves: 178732
-//   7:1930-39, files: 2224, moves: 469415
-//   8:1940-49, files: 743, moves: 155292
-//   9:1950-59, files: 1636, moves: 343773
-//  10:1960-69, files: 3178, moves: 647853
-//  11:1970-75, files: 2201, moves: 442334
-//  12:1976-79, files: 2126, moves: 429100
-//  13:1980, files: 804, moves: 156099
-//  14:1981, files: 651, moves: 129838
-//  15:1982, files: 718, moves: 140165
-//  16:1983, files: 862, moves: 174136
-//  17:1984, files: 744, moves: 154795
-//  18:1985, files: 978, moves: 202844
-//  19:1986, files: 1006, moves: 211331
-//  20:1987, files: 963, moves: 194109
-//  21:1988, files: 1144, moves: 236411
-//  22:1989, files: 1414, moves: 288660
-//  23:1990, files: 1377, moves: 283506
-//  24:1991, files: 1279, moves: 265952
-//  25:1992, files: 1374, moves: 286559
-//  26:1993, files: 1383, moves: 294227
-//  27:1994, files: 1326, moves: 278442
-//  28:1995, files: 1620, moves: 343337
-//  29:1996, files: 1873, moves: 393597
-//  30:1997, files: 1691, moves: 353836
-//  31:1998, files: 1734, moves: 361426
-//  32:1999, files: 1407, moves: 293231
-//  33:2000, files: 1659, moves: 343388
-//  34:2001, files: 2007, moves: 421291
-//  35:2002, files: 1728, moves: 363739
-//  36:2003, files: 2013, moves: 429808
-//  37:2004, files: 1949, moves: 414287
-//  38:2005, files: 1875, moves: 401196
-//  39:2006, files: 2778, moves: 598914
-//  40:2007, files: 2716, moves: 578552
-//  41:2008, files: 2985, moves: 641211
-//  42:2009, files: 2622, moves: 563081
-//  43:2010, files: 2635, moves: 569596
-//  44:2011, files: 2219, moves: 473040
-//  45:2012, files: 0, moves: 0
-//  46:GoLibrary2, files: 0, moves: 0
-//  47:Onomasticon2, files: 0, moves: 0
-// Total SGF files = 70179, total moves = 14582375
+//   0:0196-1699, files: 784, approximate moves: 149431
+//   1:1700-99, files: 1195, approximate moves: 248166
+//   2:1800-49, files: 1254, approximate moves: 232289
+//   3:1850-99, files: 1618, approximate moves: 290802
+//   4:1900-09, files: 412, approximate moves: 81389
+//   5:1910-19, files: 379, approximate moves: 73195
+//   6:1920-29, files: 895, approximate moves: 178732
+//   7:1930-39, files: 2224, approximate moves: 469415
+//   8:1940-49, files: 743, approximate moves: 155292
+//   9:1950-59, files: 1636, approximate moves: 343773
+//  10:1960-69, files: 3178, approximate moves: 647853
+//  11:1970-75, files: 2201, approximate moves: 442334
+//  12:1976-79, files: 2126, approximate moves: 429100
+//  13:1980, files: 804, approximate moves: 156099
+//  14:1981, files: 651, approximate moves: 129838
+//  15:1982, files: 718, approximate moves: 140165
+//  16:1983, files: 862, approximate moves: 174136
+//  17:1984, files: 744, approximate moves: 154795
+//  18:1985, files: 978, approximate moves: 202844
+//  19:1986, files: 1006, approximate moves: 211331
+//  20:1987, files: 963, approximate moves: 194109
+//  21:1988, files: 1144, approximate moves: 236411
+//  22:1989, files: 1414, approximate moves: 288660
+//  23:1990, files: 1377, approximate moves: 283506
+//  24:1991, files: 1279, approximate moves: 265952
+//  25:1992, files: 1374, approximate moves: 286559
+//  26:1993, files: 1383, approximate moves: 294227
+//  27:1994, files: 1326, approximate moves: 278442
+//  28:1995, files: 1620, approximate moves: 343337
+//  29:1996, files: 1873, approximate moves: 393597
+//  30:1997, files: 1691, approximate moves: 353836
+//  31:1998, files: 1734, approximate moves: 361426
+//  32:1999, files: 1407, approximate moves: 293231
+//  33:2000, files: 1659, approximate moves: 343388
+//  34:2001, files: 2007, approximate moves: 421291
+//  35:2002, files: 1728, approximate moves: 363739
+//  36:2003, files: 2013, approximate moves: 429808
+//  37:2004, files: 1949, approximate moves: 414287
+//  38:2005, files: 1875, approximate moves: 401196
+//  39:2006, files: 2778, approximate moves: 598914
+//  40:2007, files: 2716, approximate moves: 578552
+//  41:2008, files: 2985, approximate moves: 641211
+//  42:2009, files: 2622, approximate moves: 563081
+//  43:2010, files: 2635, approximate moves: 569596
+//  44:2011, files: 2219, approximate moves: 473040
+//  45:2012, files: 0, approximate moves: 0
+//  46:GoLibrary2, files: 0, approximate moves: 0
+//  47:Onomasticon2, files: 0, approximate moves: 0
+// Total SGF files = 70179, total approximate moves = 14582375
 // Reading and writing database, db_dir = /usr/local/GoGoD/Go/Database/, testout_dir = /usr/local/GoGoD/dbout/
 // BAD Property Value: /usr/local/GoGoD/Go/Database/0196-1699/0800+.sgf:3:1: PW[] check name:An Immortal
 // BAD Property Value: /usr/local/GoGoD/Go/Database/0196-1699/0800+.sgf:4:1: PB[] check name:Another Immortal
@@ -15851,4 +15826,426 @@ func ExampleReadWriteDatabase() {
 //  1 : muchen1999, first: 2009-03-26a, 4d ama, last: 2009-03-26a, 4d ama
 //  1 : pachi2 program, first: 2011-04-14g, 3a, last: 2011-04-14g, 3a
 //  1 : thug, first: 1996-01-16a, 6d*, last: 1996-01-16a, 6d*
-//  max Procs set back to 1.
+
+// makeTestDB creates a database directory with one sub-directory
+// for each entry in dirs, holding the given .sgf files.
+func makeTestDB(t *testing.T, dirs map[string]map[string]string) string {
+	root := t.TempDir()
+	for d, files := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, d), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		for f, content := range files {
+			if err := os.WriteFile(filepath.Join(root, d, f), []byte(content), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return root + "/"
+}
+
+// memDB returns dbReq, set to process the database of files, held in memory.
+// files maps slash separated paths, i.e. "a/1.sgf", to their contents.
+func memDB(files map[string]string, dbReq DBProcessRequest) *DBProcessRequest {
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	dbReq.DBIndexName = "mem:"
+	dbReq.DBFS = fsys
+	return &dbReq
+}
+
+func TestProcessDatabaseContextErrors(t *testing.T) {
+	root := makeTestDB(t, map[string]map[string]string{
+		"a": {"1.sgf": "(;GM[1];B[aa];W[bb])"},
+		"b": {"2.sgf": "(;GM[1];B[cc])"},
+	})
+	// a directory named like an .sgf file cannot be read as a file
+	if err := os.Mkdir(root+"b/bad.sgf", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	dbReq := DBProcessRequest{DBIndexName: root, FileActionFunc: CountMoves}
+	err := ProcessDatabaseContext(context.Background(), &dbReq)
+	var de *DirectoryError
+	if !errors.As(err, &de) {
+		t.Fatalf("expected a DirectoryError, got %v", err)
+	}
+	if de.Dir != root+"b" || de.File != "bad.sgf" {
+		t.Errorf("DirectoryError names %s/%s", de.Dir, de.File)
+	}
+	if len(dbReq.DBErrors) != 1 {
+		t.Errorf("expected 1 error, got %d", len(dbReq.DBErrors))
+	}
+	// ProcessDatabase only reports an Index directory which cannot be read
+	dbReq = DBProcessRequest{DBIndexName: root, FileActionFunc: CountMoves}
+	if status := ProcessDatabase(&dbReq); status != 0 || len(dbReq.DBErrors) != 1 {
+		t.Errorf("ProcessDatabase status %d, %d errors", status, len(dbReq.DBErrors))
+	}
+}
+
+func TestProcessDatabaseContextCancel(t *testing.T) {
+	root := makeTestDB(t, map[string]map[string]string{
+		"a": {"1.sgf": "(;GM[1];B[aa])"},
+		"b": {"2.sgf": "(;GM[1];B[cc])"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dirs := 0
+	dbReq := DBProcessRequest{DBIndexName: root, FileActionFunc: CountMoves,
+		EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) { dirs++ }}
+	err := ProcessDatabaseContext(ctx, &dbReq)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if dirs != 0 {
+		t.Errorf("%d directories processed after cancel", dirs)
+	}
+	if ProcessDatabase(&DBProcessRequest{DBIndexName: root + "missing/"}) != 2 {
+		t.Errorf("missing database directory not reported")
+	}
+
+	// cancelled while the files are queued: one error for the directory
+	files := map[string]string{}
+	for i := 0; i < 50; i++ {
+		files[strconv.Itoa(i)+".sgf"] = "(;GM[1];B[aa])"
+	}
+	root = makeTestDB(t, map[string]map[string]string{"a": files})
+	ctx, cancel = context.WithCancel(context.Background())
+	dbReq = DBProcessRequest{DBIndexName: root, DoMultiCPU: true, MaxAtOnce: 2, NumCPUs: 2,
+		Schedule: ScheduleFiles,
+		FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			cancel()
+		}}
+	err = ProcessDatabaseContext(ctx, &dbReq)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	// the directory's error, and the context's error
+	if len(dbReq.DBErrors) != 2 {
+		t.Errorf("%d errors: %v", len(dbReq.DBErrors), dbReq.DBErrors)
+	}
+}
+
+func TestScheduleFiles(t *testing.T) {
+	big := map[string]string{}
+	for i := 0; i < 100; i++ {
+		big[strconv.Itoa(i)+".sgf"] = "(;GM[1];B[aa];W[bb])"
+	}
+	root := makeTestDB(t, map[string]map[string]string{
+		"big":   big,
+		"empty": {},
+		"small": {"1.sgf": "(;GM[1];B[aa])"},
+	})
+	var files int32
+	var mu sync.Mutex
+	dirCalls := map[string]int{}
+	dbReq := DBProcessRequest{DBIndexName: root, DoMultiCPU: true, MaxAtOnce: 4, NumCPUs: 4,
+		Schedule: ScheduleFiles,
+		FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			atomic.AddInt32(&files, 1)
+		},
+		EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			mu.Lock()
+			dirCalls[filepath.Base(r.Dir())]++
+			mu.Unlock()
+		}}
+	if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if files != 101 {
+		t.Errorf("processed %d files, expected 101", files)
+	}
+	for _, d := range []string{"big", "empty", "small"} {
+		if dirCalls[d] != 1 {
+			t.Errorf("EndDirActionFunc called %d times for %s", dirCalls[d], d)
+		}
+	}
+}
+
+func TestSkipFilesAndFileLimit(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 5; i++ {
+		files["g"+strconv.Itoa(i)+".sgf"] = "(;GM[1];B[aa])"
+	}
+	// empty files are not processed, so not counted
+	files["g0a.sgf"] = ""
+	files["g3a.sgf"] = ""
+	root := makeTestDB(t, map[string]map[string]string{"a": files, "b": files, "c": files})
+	for _, tc := range []struct {
+		scope    LimitScope
+		schedule ScheduleMode
+		skip     int
+		limit    int
+		expect   string
+	}{
+		{LimitPerDirectory, ScheduleDirectories, 1, 2, "a/g1 a/g2 b/g1 b/g2 c/g1 c/g2"},
+		{LimitPerDirectory, ScheduleFiles, 4, 0, "a/g4 b/g4 c/g4"},
+		{LimitPerDatabase, ScheduleDirectories, 4, 3, "a/g4 b/g0 b/g1"},
+		{LimitPerDatabase, ScheduleFiles, 9, 0, "b/g4 c/g0 c/g1 c/g2 c/g3 c/g4"},
+		{LimitPerDatabase, ScheduleDirectories, 20, 3, ""},
+	} {
+		var mu sync.Mutex
+		var seen []string
+		dbReq := DBProcessRequest{DBIndexName: root, SkipFiles: tc.skip, FileLimit: tc.limit,
+			LimitScope: tc.scope, Schedule: tc.schedule,
+			FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+				mu.Lock()
+				seen = append(seen, filepath.Base(r.Dir())+"/"+fName[:len(fName)-4])
+				mu.Unlock()
+			}}
+		if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(seen)
+		if got := strings.Join(seen, " "); got != tc.expect {
+			t.Errorf("skip %d limit %d scope %d: got %q, expected %q", tc.skip, tc.limit, tc.scope, got, tc.expect)
+		}
+	}
+}
+
+// readDirCounter is an fs.FS counting the directories read through it.
+type readDirCounter struct {
+	fs.FS
+	mu    sync.Mutex
+	reads map[string]int
+}
+
+func (c *readDirCounter) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.mu.Lock()
+	c.reads[name]++
+	c.mu.Unlock()
+	return fs.ReadDir(c.FS, name)
+}
+
+func TestLimitPerDatabaseListsOnce(t *testing.T) {
+	fsys := &readDirCounter{FS: fstest.MapFS{
+		"a/1.sgf": {Data: []byte("(;GM[1];B[aa])")},
+		"a/2.sgf": {Data: []byte("(;GM[1];B[aa])")},
+		"b/3.sgf": {Data: []byte("(;GM[1];B[aa])")},
+	}, reads: map[string]int{}}
+	var files int32
+	dbReq := DBProcessRequest{DBIndexName: "mem:", DBFS: fsys, LimitScope: LimitPerDatabase, SkipFiles: 1, FileLimit: 1,
+		FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			atomic.AddInt32(&files, 1)
+		}}
+	if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if files != 1 || fsys.reads["a"] != 1 || fsys.reads["b"] != 1 {
+		t.Errorf("%d files processed, directories read %v", files, fsys.reads)
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	root := makeTestDB(t, map[string]map[string]string{
+		"a":     {"1.sgf": "(;GM[1];B[aa])"},
+		"a/b/c": {"2.sgf": "(;GM[1];B[aa])", "3.sgf": "(;GM[1];B[bb])"},
+		"d":     {"notes.txt": "no games"},
+	})
+	if err := os.WriteFile(root+"r.sgf", []byte("(;GM[1])"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	dbReq := DBProcessRequest{DBIndexName: root, Recursive: true, FileActionFunc: CountMoves,
+		EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			dirs = append(dirs, r.RelDir())
+		}}
+	if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(dirs, " "); got != ". a a/b/c" {
+		t.Errorf("processed directories %q", got)
+	}
+}
+
+// ExampleWriteSGFDatabase shows the totals, with the count of errors,
+// of a database with a file which cannot be read.
+func ExampleWriteSGFDatabase() {
+	files := map[string]string{
+		"a/1.sgf": "(;GM[1];B[aa];W[bb])",
+		"a/2.sgf": "(;GM[1];B[cc]",
+		"b/3.sgf": "(;GM[1];B[dd])",
+	}
+	dbReq := memDB(files, DBProcessRequest{FileActionFunc: CountNodesAndMoves,
+		EndDirActionFunc: WriteSGFDirectory, EndDBActionFunc: WriteSGFDatabase,
+		OrderedResults: true})
+	ProcessDatabase(dbReq)
+	// Output:
+	//   0:a, files: 2, tokens: 3
+	//   1:b, files: 1, tokens: 1
+	// Total SGF files = 3, tokens = 4, errors: 1
+}
+
+func TestDBFSAndOutputSink(t *testing.T) {
+	var sink MapSink
+	dbReq := memDB(map[string]string{
+		"a/1.sgf":       "(;GM[1];B[aa];W[bb])",
+		"a/b/2.sgf":     "(;GM[1];B[cc])",
+		"readme.txt":    "not a game",
+		"top/empty.sgf": "",
+	}, DBProcessRequest{Output: &sink, Recursive: true,
+		FileActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			if err := r.WriteOutput(fName, b); err != nil {
+				t.Error(err)
+			}
+		}})
+	if err := ProcessDatabaseContext(context.Background(), dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.Files) != 2 || string(sink.Files["a/b/2.sgf"]) != "(;GM[1];B[cc])" {
+		t.Errorf("unexpected output files: %v", sink.Files)
+	}
+}
+
+func TestTotalsMultiCPU(t *testing.T) {
+	dirs := map[string]map[string]string{}
+	for d := 0; d < 16; d++ {
+		files := map[string]string{}
+		for f := 0; f <= d; f++ {
+			files[strconv.Itoa(f)+".sgf"] = "(;GM[1];B[aa];W[bb])"
+		}
+		dirs["d"+strconv.Itoa(d)] = files
+	}
+	root := makeTestDB(t, dirs)
+	for _, schedule := range []ScheduleMode{ScheduleDirectories, ScheduleFiles} {
+		dbReq := DBProcessRequest{DBIndexName: root, DoMultiCPU: true, MaxAtOnce: 8, NumCPUs: 8,
+			Schedule: schedule, FileActionFunc: CountMoves, EndDirActionFunc: ReportDirCounts}
+		if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+			t.Fatal(err)
+		}
+		// 136 files with 3 nodes each
+		if d, f, m, e := dbReq.Totals(); d != 16 || f != 136 || m != 408 || e != 0 {
+			t.Errorf("schedule %d: totals %d directories, %d files, %d moves, %d errors", schedule, d, f, m, e)
+		}
+	}
+}
+
+func TestOrderedResults(t *testing.T) {
+	dirs := map[string]map[string]string{}
+	var expect []string
+	for d := 0; d < 20; d++ {
+		files := map[string]string{}
+		// earlier directories have more files, so finish later
+		for f := 0; f < 40-2*d; f++ {
+			files[strconv.Itoa(f)+".sgf"] = "(;GM[1];B[aa];W[bb])"
+		}
+		name := fmt.Sprintf("d%02d", d)
+		dirs[name] = files
+		expect = append(expect, name)
+	}
+	root := makeTestDB(t, dirs)
+	var got []string
+	dbReq := DBProcessRequest{DBIndexName: root, DoMultiCPU: true, MaxAtOnce: 8, NumCPUs: 8,
+		OrderedResults: true, FileActionFunc: CountMoves,
+		EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+			got = append(got, r.RelDir())
+		}}
+	if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != strings.Join(expect, " ") {
+		t.Errorf("directories ended in order %v", got)
+	}
+}
+
+func TestEndDirectoryOnceLopsided(t *testing.T) {
+	// one directory holds most of the files, so the others end while it is processed
+	dirs := map[string]map[string]string{"big": {}}
+	for f := 0; f < 200; f++ {
+		dirs["big"][strconv.Itoa(f)+".sgf"] = "(;GM[1];B[aa];W[bb])"
+	}
+	for d := 0; d < 7; d++ {
+		dirs["small"+strconv.Itoa(d)] = map[string]string{"0.sgf": "(;GM[1];B[aa])"}
+	}
+	root := makeTestDB(t, dirs)
+	for _, ordered := range []bool{false, true} {
+		ended := map[string]int{}
+		dbReq := DBProcessRequest{DBIndexName: root, DoMultiCPU: true, MaxAtOnce: 4, NumCPUs: 4,
+			Schedule: ScheduleFiles, OrderedResults: ordered, FileActionFunc: CountMoves,
+			EndDirActionFunc: func(r *DirectoryProcessRequest, fName string, b []byte) {
+				ended[r.RelDir()]++
+			}}
+		if err := ProcessDatabaseContext(context.Background(), &dbReq); err != nil {
+			t.Fatal(err)
+		}
+		if len(ended) != len(dirs) {
+			t.Errorf("ordered %v: %d directories ended, want %d", ordered, len(ended), len(dirs))
+		}
+		for dir, n := range ended {
+			if n != 1 {
+				t.Errorf("ordered %v: directory %s ended %d times", ordered, dir, n)
+			}
+		}
+		if d, f, _, _ := dbReq.Totals(); d != 8 || f != 207 {
+			t.Errorf("ordered %v: totals %d directories, %d files", ordered, d, f)
+		}
+	}
+}
+
+// treeHasMove reports whether the pattern tree in fileName has the move
+// id[v] of a game, under any of the transformations of the board.
+func treeHasMove(t *testing.T, fileName string, id string, v string) bool {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for s := ah.BoardTrans(0); int(s) < len(ah.TransName); s++ {
+		col, row := TransPoint(s, int(v[0]-'a'), int(v[1]-'a'), 19)
+		if strings.Contains(string(b), id+"["+string(rune('a'+col))+string(rune('a'+row))+"]") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReadDatabaseAndBuildPatterns(t *testing.T) {
+	root := makeTestDB(t, map[string]map[string]string{
+		"a": {"1.sgf": "(;GM[1]FF[4]SZ[19]PB[Black]PW[White]RE[B+R];B[pd];W[dp];B[pp];W[dd])"},
+		"b": {"2.sgf": "(;GM[1]FF[4]SZ[19]PB[Black]PW[White]RE[W+R];B[qd];W[dc];B[pq];W[oc])",
+			"3.sgf": "(;GM[1]FF[4]SZ[19]HA[2]PB[Black]PW[White]RE[W+R]AB[dp][pd];W[qc];B[qd];W[oc])"},
+	})
+	patDir := filepath.Join(t.TempDir(), "patterns") + "/"
+	if status := ReadDatabaseAndBuildPatterns(root, patDir, ah.WHOLE_BOARD_PATTERN, 0, 0, 0); status != 0 {
+		t.Fatalf("status %d", status)
+	}
+	files, err := filepath.Glob(patDir + "*.sgf")
+	if err != nil || len(files) != 2 {
+		t.Fatalf("pattern files %v, %v", files, err)
+	}
+	// the games without handicap are in one tree, the game with 2 stones in another
+	even := filepath.Join(patDir, fmt.Sprintf("Patterns_%v_HA_0.sgf", ah.WHOLE_BOARD_PATTERN))
+	ha2 := filepath.Join(patDir, fmt.Sprintf("Patterns_%v_HA_2.sgf", ah.WHOLE_BOARD_PATTERN))
+	if !treeHasMove(t, even, "W", "dp") || !treeHasMove(t, even, "W", "dc") || treeHasMove(t, even, "W", "qc") {
+		t.Errorf("tree of the even games")
+	}
+	if !treeHasMove(t, ha2, "W", "qc") || treeHasMove(t, ha2, "W", "dc") {
+		t.Errorf("tree of the handicap games")
+	}
+	// the trees on disk are extended
+	if status := ReadDatabaseAndBuildPatterns(root, patDir, ah.WHOLE_BOARD_PATTERN, 0, 0, 0); status != 0 {
+		t.Errorf("extending: status %d", status)
+	}
+	trees, err := ReadDirectoryAndBuildPatternTrees(root, "a", patDir, nil, ah.WHOLE_BOARD_PATTERN, 0, 0, 0)
+	if err != nil || trees == nil || trees[0] == nil || trees[2] == nil {
+		t.Errorf("directory: %v, %v", trees, err)
+	}
+	// the old signature extends, and returns, the tree of the even games,
+	// and adds the games with a handicap to the trees saved
+	evenTree, err := ReadDirectoryAndBuildPatterns(root, "b", patDir, trees[0], ah.WHOLE_BOARD_PATTERN, 0, 0, 0)
+	if err != nil || evenTree == nil {
+		t.Errorf("directory, one tree: %v, %v", evenTree, err)
+	}
+	if !treeHasMove(t, even, "W", "dc") || !treeHasMove(t, ha2, "W", "qc") {
+		t.Errorf("trees saved by ReadDirectoryAndBuildPatterns")
+	}
+	if _, err := ReadDirectoryAndBuildPatterns(root, "a", "", nil, ah.WHOLE_BOARD_PATTERN+1, 0, 0, 0); err != ErrPatternType {
+		t.Errorf("pattern type: %v", err)
+	}
+	if status := ReadDatabaseAndBuildPatterns(root+"missing/", patDir, ah.WHOLE_BOARD_PATTERN, 0, 0, 0); status != 2 {
+		t.Errorf("missing database: status %d", status)
+	}
+	if status := ReadDatabaseAndBuildPatterns(root, patDir, ah.WHOLE_BOARD_PATTERN+1, 0, 0, 0); status != 4 {
+		t.Errorf("pattern type: status %d", status)
+	}
+}
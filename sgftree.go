@@ -0,0 +1,434 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/sgftree.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The sgf package parses a file into an sgf.GameTree, and plays its moves on
+// the board of the ah package, stopping at the first error. sgf.GameTree
+// exports neither its nodes nor its properties, only GetHA, GetSize, and
+// WriteFile, and sgf.ParseFile does not expose the position after each move.
+// sgfdb reads the properties of each node, in file order, to index, compare,
+// normalize, lint, and repair the games, and to replay them on Board, so
+// sgftree.go reads the SGF syntax itself:
+//	Collection = GameTree { GameTree }
+//	GameTree   = "(" Sequence { GameTree } ")"
+//	Sequence   = Node { Node }
+//	Node       = ";" { Property }
+//	Property   = PropIdent PropValue { PropValue }
+// sgfReader is the one parser of this syntax, and sgfScanner its tokenizer,
+// also used by TranscodeSGF. Files are still checked by sgf.ParseFile, i.e.
+// by WriteSGFFile and Lint.
+
+// sgfProp is an SGF property: an identifier, and its values, without escapes.
+type sgfProp struct {
+	id   string
+	vals []string
+}
+
+// sgfNode is an SGF node: a list of properties, in file order.
+type sgfNode struct {
+	props []sgfProp
+}
+
+// sgfTree is an SGF GameTree: a sequence of nodes, followed by variations.
+type sgfTree struct {
+	nodes []*sgfNode
+	vars  []*sgfTree
+}
+
+// SGFSyntaxError reports an error in the SGF syntax of a file,
+// at byte Offset of the file.
+type SGFSyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SGFSyntaxError) Error() string {
+	return "SGF syntax error at offset " + strconv.Itoa(e.Offset) + ": " + e.Msg
+}
+
+// prop returns the property id of the node, or nil.
+func (n *sgfNode) prop(id string) *sgfProp {
+	for i := range n.props {
+		if n.props[i].id == id {
+			return &n.props[i]
+		}
+	}
+	return nil
+}
+
+// value returns the first value of the property id of the node, or "".
+func (n *sgfNode) value(id string) string {
+	p := n.prop(id)
+	if p == nil || len(p.vals) == 0 {
+		return ""
+	}
+	return p.vals[0]
+}
+
+// mainLine returns the nodes of the main line of the tree,
+// i.e. following the first variation at each branch.
+func (t *sgfTree) mainLine() []*sgfNode {
+	var nodes []*sgfNode
+	for t != nil {
+		nodes = append(nodes, t.nodes...)
+		if len(t.vars) == 0 {
+			break
+		}
+		t = t.vars[0]
+	}
+	return nodes
+}
+
+// sgfScanner reads the tokens of an SGF file: white space, the PropIdents,
+// and the PropValues. The other tokens, "(", ")", and ";", are single bytes.
+type sgfScanner struct {
+	b   []byte
+	pos int
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isLetter(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+func (s *sgfScanner) skipSpace() {
+	for s.pos < len(s.b) && isSpace(s.b[s.pos]) {
+		s.pos++
+	}
+}
+
+// ident reads the letters of a PropIdent, as written.
+func (s *sgfScanner) ident() string {
+	start := s.pos
+	for s.pos < len(s.b) && isLetter(s.b[s.pos]) {
+		s.pos++
+	}
+	return string(s.b[start:s.pos])
+}
+
+// value reads a PropValue, from its "[", removes the escapes and the escaped
+// newlines (soft line breaks), and reports whether its "]" was found.
+// If closes is not nil, it is called for each "]", with the offset after it,
+// and whether it is escaped, and reports whether the "]" ends the value:
+// an unescaped "]" which does not is kept in the value, and an escaped "]"
+// which does ends the value, and its "\" is kept.
+func (s *sgfScanner) value(closes func(end int, escaped bool) bool) (string, bool) {
+	s.pos++ // skip "["
+	var v []byte
+	for s.pos < len(s.b) {
+		c := s.b[s.pos]
+		switch c {
+		case '\\':
+			s.pos++
+			if s.pos >= len(s.b) {
+				break
+			}
+			c = s.b[s.pos]
+			if c == ']' && closes != nil && closes(s.pos+1, true) {
+				s.pos++
+				return string(append(v, '\\')), true
+			}
+			if c == '\n' || c == '\r' {
+				// soft line break
+				s.pos++
+				if s.pos < len(s.b) && (s.b[s.pos] == '\n' || s.b[s.pos] == '\r') && s.b[s.pos] != c {
+					s.pos++
+				}
+				continue
+			}
+			v = append(v, c)
+		case ']':
+			s.pos++
+			if closes != nil && !closes(s.pos, false) {
+				v = append(v, c)
+				continue
+			}
+			return string(v), true
+		default:
+			v = append(v, c)
+		}
+		s.pos++
+	}
+	return string(v), false
+}
+
+// sgfReader reads an SGF file, into its game trees. It is the one parser
+// of the syntax in sgfdb. In the repair mode, used by RepairSGF, it repairs
+// the defects it finds, instead of stopping at the first one, and records
+// the changes made. In either mode, the PropIdents written with lower case
+// letters, of old versions of SGF (i.e. AddBlack for AB), are read by their
+// upper case letters, and recorded.
+type sgfReader struct {
+	sgfScanner
+	repair  bool     // repair the defects found
+	changes []string // the changes made to the file, as read
+}
+
+// readSGF reads b, an SGF collection, and returns its game trees.
+// If there is an error, the trees read before the error are returned.
+func readSGF(b []byte) ([]*sgfTree, error) {
+	r := sgfReader{sgfScanner: sgfScanner{b: b}}
+	return r.collection()
+}
+
+func (r *sgfReader) errorf(msg string) error {
+	return &SGFSyntaxError{Offset: r.pos, Msg: msg}
+}
+
+func (r *sgfReader) change(offset int, format string, args ...any) {
+	r.changes = append(r.changes, fmt.Sprintf("offset %d: ", offset)+fmt.Sprintf(format, args...))
+}
+
+// stray skips the characters from r.pos which are not in stop.
+// In the repair mode, they are recorded as a change.
+func (r *sgfReader) stray(stop string) {
+	start := r.pos
+	for r.pos < len(r.b) && strings.IndexByte(stop, r.b[r.pos]) < 0 {
+		r.pos++
+	}
+	if !r.repair {
+		return
+	}
+	if s := strings.TrimSpace(string(r.b[start:r.pos])); s != "" {
+		if len(s) > 20 {
+			s = s[:20] + "..."
+		}
+		r.change(start, "stray characters %q removed", s)
+	}
+}
+
+// collection reads the game trees of the file.
+// Text before the first "(" and after the last ")" is ignored.
+func (r *sgfReader) collection() ([]*sgfTree, error) {
+	var trees []*sgfTree
+	for {
+		r.stray("(")
+		if r.pos >= len(r.b) {
+			break
+		}
+		start := r.pos
+		t, err := r.gameTree()
+		if len(t.nodes) > 0 {
+			trees = append(trees, t)
+		} else if r.repair {
+			if len(t.vars) > 0 {
+				r.change(start, "game tree without nodes replaced by its variations")
+			}
+			trees = append(trees, t.vars...)
+		}
+		if err != nil {
+			return trees, err
+		}
+	}
+	if len(trees) == 0 {
+		return nil, r.errorf("no GameTree found")
+	}
+	return trees, nil
+}
+
+// gameTree reads a GameTree, starting at "(".
+func (r *sgfReader) gameTree() (*sgfTree, error) {
+	r.pos++ // skip "("
+	t := new(sgfTree)
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.b) {
+			if r.repair {
+				r.change(r.pos, "missing ) added at end of file")
+				return t, nil
+			}
+			return t, r.errorf("missing ) at end of file")
+		}
+		switch c := r.b[r.pos]; {
+		case c == ';':
+			if len(t.vars) > 0 {
+				if !r.repair {
+					return t, r.errorf("node after variations")
+				}
+				r.change(r.pos, "node after the variations moved to a new variation")
+				v := new(sgfTree)
+				for r.pos < len(r.b) && r.b[r.pos] == ';' {
+					n, _ := r.node()
+					v.nodes = append(v.nodes, n)
+					r.skipSpace()
+				}
+				t.vars = append(t.vars, v)
+				continue
+			}
+			n, err := r.node()
+			t.nodes = append(t.nodes, n)
+			if err != nil {
+				return t, err
+			}
+		case c == '(':
+			if len(t.nodes) == 0 && !r.repair {
+				return t, r.errorf("variation before first node")
+			}
+			start := r.pos
+			v, err := r.gameTree()
+			if len(v.nodes) > 0 {
+				t.vars = append(t.vars, v)
+			} else if r.repair {
+				if len(v.vars) > 0 {
+					r.change(start, "variation without nodes replaced by its variations")
+				}
+				t.vars = append(t.vars, v.vars...)
+			}
+			if err != nil {
+				return t, err
+			}
+		case c == ')':
+			r.pos++
+			if len(t.nodes) == 0 && !r.repair {
+				return t, r.errorf("empty GameTree")
+			}
+			return t, nil
+		case r.repair:
+			r.stray(";()")
+		default:
+			return t, r.errorf("unexpected character " + strconv.QuoteRune(rune(c)))
+		}
+	}
+}
+
+// node reads a node, starting at ";".
+func (r *sgfReader) node() (*sgfNode, error) {
+	r.pos++ // skip ";"
+	n := new(sgfNode)
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.b) {
+			return n, nil
+		}
+		c := r.b[r.pos]
+		if c == ';' || c == '(' || c == ')' {
+			return n, nil
+		}
+		if !isLetter(c) {
+			if !r.repair {
+				return n, r.errorf("unexpected character " + strconv.QuoteRune(rune(c)))
+			}
+			r.stray(";()" + "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+			continue
+		}
+		start := r.pos
+		p, err := r.property()
+		if err != nil {
+			return n, err
+		}
+		if r.repair && len(p.vals) == 0 {
+			r.change(start, "property %s without a value removed", p.id)
+			continue
+		}
+		if r.repair && r.repeated(n, p, start) {
+			continue
+		}
+		n.props = append(n.props, p)
+	}
+}
+
+// property reads a PropIdent and its values.
+func (r *sgfReader) property() (p sgfProp, err error) {
+	start := r.pos
+	id := r.ident()
+	p.id = strings.Map(func(c rune) rune {
+		if 'A' <= c && c <= 'Z' {
+			return c
+		}
+		return -1
+	}, id)
+	switch {
+	case p.id == "" && !r.repair:
+		return p, r.errorf("property identifier without upper case letters")
+	case p.id == "":
+		// i.e. b[pd]
+		p.id = strings.ToUpper(id)
+		r.change(start, "property %s written %s", id, p.id)
+	case p.id != id:
+		// the lower case letters of FF[3], i.e. AddBlack[pd]
+		r.change(start, "property %s written %s", id, p.id)
+	}
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.b) || r.b[r.pos] != '[' {
+			break
+		}
+		if r.repair {
+			p.vals = append(p.vals, r.repairValue(p.id))
+			continue
+		}
+		start := r.pos
+		v, ok := r.value(nil)
+		if !ok {
+			r.pos = start
+			return p, r.errorf("missing ] at end of value")
+		}
+		p.vals = append(p.vals, v)
+	}
+	if len(p.vals) == 0 && !r.repair {
+		return p, r.errorf("property " + p.id + " without value")
+	}
+	return p, nil
+}
+
+// sgfEscape returns v, with "\" and "]" escaped, to be written as a PropValue.
+func sgfEscape(v string) string {
+	if !strings.ContainsAny(v, "\\]") {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' || v[i] == ']' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(v[i])
+	}
+	return sb.String()
+}
+
+// writeSGF writes the trees as an SGF collection, with the root node of
+// each game on its own line, and each variation on a new line.
+func writeSGF(buf *bytes.Buffer, trees []*sgfTree) {
+	for _, t := range trees {
+		writeTree(buf, t, true)
+		buf.WriteByte('\n')
+	}
+}
+
+func writeTree(buf *bytes.Buffer, t *sgfTree, game bool) {
+	buf.WriteByte('(')
+	for i, n := range t.nodes {
+		buf.WriteByte(';')
+		for _, p := range n.props {
+			buf.WriteString(p.id)
+			for _, v := range p.vals {
+				buf.WriteByte('[')
+				buf.WriteString(sgfEscape(v))
+				buf.WriteByte(']')
+			}
+		}
+		if game && i == 0 && len(t.nodes) > 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	for _, v := range t.vars {
+		buf.WriteByte('\n')
+		writeTree(buf, v, false)
+	}
+	buf.WriteByte(')')
+}
@@ -0,0 +1,44 @@
+package sgfdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadSGF(t *testing.T) {
+	trees, err := readSGF([]byte("junk (;FF[4]AddBlack[aa][bb]C[a \\] b\\\\ c\\\nd] ;B[cc](;W[dd])(;W[ee];B[ff])) (;GM[1])"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trees) != 2 {
+		t.Fatalf("%d trees", len(trees))
+	}
+	root := trees[0].nodes[0]
+	if p := root.prop("AB"); p == nil || len(p.vals) != 2 || p.vals[1] != "bb" {
+		t.Errorf("AB property: %v", p)
+	}
+	if c := root.value("C"); c != "a ] b\\ cd" {
+		t.Errorf("C value: %q", c)
+	}
+	if n := len(trees[0].mainLine()); n != 3 {
+		t.Errorf("main line of %d nodes", n)
+	}
+	// the lower case letters of the PropIdents are recorded
+	r := sgfReader{sgfScanner: sgfScanner{b: []byte("(;FF[3]AddBlack[aa];B[bb])")}}
+	if _, err := r.collection(); err != nil || len(r.changes) != 1 || r.changes[0] != "offset 7: property AddBlack written AB" {
+		t.Errorf("changes %q, %v", r.changes, err)
+	}
+	for _, bad := range []string{"", "(;B[aa]", "(;B[aa)", "(;B[aa]W)", "(;B[aa];(;W[bb]))x", "(;B[aa]{)"} {
+		_, err := readSGF([]byte(bad))
+		var se *SGFSyntaxError
+		if bad == "(;B[aa];(;W[bb]))x" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %s", bad, err)
+			}
+			continue
+		}
+		if !errors.As(err, &se) {
+			t.Errorf("%q: expected a syntax error, got %v", bad, err)
+		}
+	}
+}
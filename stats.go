@@ -0,0 +1,385 @@
+/*
+ *  File:		src/github.com/Ken1JF/sgfdb/stats.go
+ *  Project:	sgfdb
+ *
+ *  Copyright 2026 The sgfdb Authors. All rights reserved.
+ */
+
+package sgfdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResultCounts are the results of the games of a player.
+// Games without a result, or void, are counted in Games only.
+type ResultCounts struct {
+	Games  int `json:"games"`
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+// WinRate returns the percentage of wins of the games won or lost.
+func (rc *ResultCounts) WinRate() float64 {
+	return percent(rc.Wins, rc.Wins+rc.Losses)
+}
+
+func (rc *ResultCounts) add(r gameResult) {
+	rc.Games++
+	switch r {
+	case resultWin:
+		rc.Wins++
+	case resultLoss:
+		rc.Losses++
+	case resultDraw:
+		rc.Draws++
+	}
+}
+
+// YearStats are the results of a player in a year.
+type YearStats struct {
+	Year int `json:"year"`
+	ResultCounts
+}
+
+// OpponentStats are the results of a player against an opponent.
+type OpponentStats struct {
+	ID string `json:"id"`
+	ResultCounts
+}
+
+// PlayerStats are the career statistics of a player.
+// The players are named by their IDs in the PlayerTable of the
+// PlayerStatsOptions, or else by PB and PW.
+type PlayerStats struct {
+	ID string `json:"id"`
+	ResultCounts
+	Black     ResultCounts    `json:"black"`
+	White     ResultCounts    `json:"white"`
+	Years     []YearStats     `json:"years"`     // the years of the games, in order
+	First     string          `json:"first"`     // the date of the first game, from DT, "" if none
+	Last      string          `json:"last"`      // the date of the last game
+	Opponents []OpponentStats `json:"opponents"` // the most frequent first
+	Handicaps map[int]int     `json:"handicaps"` // number of games by HA, 0 for even games
+	Komi      map[string]int  `json:"komi"`      // number of games by KM, "" if not given
+	Moves     int             `json:"moves"`     // moves of the main lines of the games
+}
+
+// AverageMoves returns the average length of the games of the player.
+func (ps *PlayerStats) AverageMoves() float64 {
+	if ps.Games == 0 {
+		return 0
+	}
+	return float64(ps.Moves) / float64(ps.Games)
+}
+
+// PlayerStatsOptions select the games, and the players, of a PlayerReport.
+type PlayerStatsOptions struct {
+	Players   *PlayerTable // resolves PB and PW to the IDs of the players; the names are the IDs if nil
+	Filter    *Query       // the games, i.e. DT>=1990 AND EV~Meijin; all if nil
+	Opponents int          // number of opponents kept for each player, 10 if 0
+	MinGames  int          // players of fewer games are dropped
+}
+
+// PlayerReport holds the statistics of the players of a database,
+// the players of most games first.
+type PlayerReport struct {
+	Players []*PlayerStats
+}
+
+type gameResult int
+
+const (
+	resultUnknown gameResult = iota
+	resultWin
+	resultLoss
+	resultDraw
+)
+
+// playerGame holds the facts of a game counted in a PlayerReport.
+type playerGame struct {
+	black, white string
+	winner       Color
+	draw         bool
+	date         string
+	handicap     int
+	komi         string
+	moves        int
+}
+
+// result returns the result of the game for the player of color c.
+func (g *playerGame) result(c Color) gameResult {
+	switch {
+	case g.draw:
+		return resultDraw
+	case g.winner == Empty:
+		return resultUnknown
+	case g.winner == c:
+		return resultWin
+	}
+	return resultLoss
+}
+
+// isDraw reports whether the RE value re is a draw.
+func isDraw(re string) bool {
+	switch strings.ToLower(strings.TrimSpace(re)) {
+	case "0", "draw", "jigo":
+		return true
+	}
+	return false
+}
+
+// newPlayerGame returns the facts of the game rec, with the players resolved by pt.
+func newPlayerGame(rec *GameRecord, pt *PlayerTable) *playerGame {
+	g := &playerGame{
+		black:  strings.TrimSpace(rec.PB),
+		white:  strings.TrimSpace(rec.PW),
+		winner: gameWinner(rec.RE),
+		draw:   isDraw(rec.RE),
+		moves:  rec.Moves,
+	}
+	if pt != nil {
+		g.black, g.white = pt.ResolveRecord(rec)
+	}
+	if dt := normalizeDate(rec.DT); gameYear(dt) != 0 {
+		g.date, _, _ = strings.Cut(dt, ",")
+	}
+	g.handicap, _ = strconv.Atoi(strings.TrimSpace(rec.HA))
+	if km, err := strconv.ParseFloat(strings.TrimSpace(rec.KM), 64); err == nil {
+		g.komi = strconv.FormatFloat(km, 'f', -1, 64)
+	}
+	return g
+}
+
+// readPlayerGames reads the root properties of the games of the database of dbrq
+// selected by filter, with the players resolved by pt.
+func readPlayerGames(ctx context.Context, dbrq *DBProcessRequest, filter *Query, pt *PlayerTable) ([]*playerGame, error) {
+	return ProcessDatabaseReduce(ctx, dbrq, Reducer[*playerGame, []*playerGame, []*playerGame]{
+		File: func(r *DirectoryProcessRequest, fName string, b []byte) *playerGame {
+			trees, err := readSGF(b)
+			if len(trees) == 0 {
+				r.FileError(fName, "Reading players", err)
+				return nil
+			}
+			var rec GameRecord
+			rec.readTree(trees[0])
+			if filter != nil && !filter.Match(&rec) {
+				return nil
+			}
+			return newPlayerGame(&rec, pt)
+		},
+		Dir: func(d []*playerGame, g *playerGame) []*playerGame {
+			if g == nil {
+				return d
+			}
+			return append(d, g)
+		},
+		DB: func(res []*playerGame, d []*playerGame) []*playerGame {
+			return append(res, d...)
+		},
+	})
+}
+
+// BuildPlayerStats reads the root properties of the games of the database of dbrq,
+// and returns the statistics of their players.
+func BuildPlayerStats(ctx context.Context, dbrq *DBProcessRequest, opts PlayerStatsOptions) (*PlayerReport, error) {
+	defer un(trace("BuildPlayerStats"), nil)
+	if opts.Opponents <= 0 {
+		opts.Opponents = 10
+	}
+	games, err := readPlayerGames(ctx, dbrq, opts.Filter, opts.Players)
+	return newPlayerReport(games, opts), err
+}
+
+// playerAcc accumulates the statistics of a player.
+type playerAcc struct {
+	stats     PlayerStats
+	years     map[int]*YearStats
+	opponents map[string]*OpponentStats
+}
+
+// newPlayerReport returns the statistics of the players of games.
+func newPlayerReport(games []*playerGame, opts PlayerStatsOptions) *PlayerReport {
+	players := make(map[string]*playerAcc)
+	count := func(id string, opp string, c Color, g *playerGame) {
+		if id == "" {
+			return
+		}
+		a := players[id]
+		if a == nil {
+			a = &playerAcc{
+				stats:     PlayerStats{ID: id, Handicaps: make(map[int]int), Komi: make(map[string]int)},
+				years:     make(map[int]*YearStats),
+				opponents: make(map[string]*OpponentStats),
+			}
+			players[id] = a
+		}
+		ps := &a.stats
+		r := g.result(c)
+		ps.add(r)
+		if c == Black {
+			ps.Black.add(r)
+		} else {
+			ps.White.add(r)
+		}
+		if y := gameYear(g.date); y != 0 {
+			if a.years[y] == nil {
+				a.years[y] = &YearStats{Year: y}
+			}
+			a.years[y].add(r)
+			if ps.First == "" || g.date < ps.First {
+				ps.First = g.date
+			}
+			if g.date > ps.Last {
+				ps.Last = g.date
+			}
+		}
+		if opp != "" {
+			if a.opponents[opp] == nil {
+				a.opponents[opp] = &OpponentStats{ID: opp}
+			}
+			a.opponents[opp].add(r)
+		}
+		ps.Handicaps[g.handicap]++
+		ps.Komi[g.komi]++
+		ps.Moves += g.moves
+	}
+	for _, g := range games {
+		count(g.black, g.white, Black, g)
+		count(g.white, g.black, White, g)
+	}
+	pr := &PlayerReport{}
+	for _, a := range players {
+		ps := &a.stats
+		if ps.Games < opts.MinGames {
+			continue
+		}
+		for _, ys := range a.years {
+			ps.Years = append(ps.Years, *ys)
+		}
+		sort.Slice(ps.Years, func(i, j int) bool { return ps.Years[i].Year < ps.Years[j].Year })
+		for _, opp := range a.opponents {
+			ps.Opponents = append(ps.Opponents, *opp)
+		}
+		sort.Slice(ps.Opponents, func(i, j int) bool {
+			if ps.Opponents[i].Games != ps.Opponents[j].Games {
+				return ps.Opponents[i].Games > ps.Opponents[j].Games
+			}
+			return ps.Opponents[i].ID < ps.Opponents[j].ID
+		})
+		if len(ps.Opponents) > opts.Opponents {
+			ps.Opponents = ps.Opponents[:opts.Opponents]
+		}
+		pr.Players = append(pr.Players, ps)
+	}
+	sort.Slice(pr.Players, func(i, j int) bool {
+		if pr.Players[i].Games != pr.Players[j].Games {
+			return pr.Players[i].Games > pr.Players[j].Games
+		}
+		return pr.Players[i].ID < pr.Players[j].ID
+	})
+	return pr
+}
+
+// Player returns the statistics of the player id, or nil.
+func (pr *PlayerReport) Player(id string) *PlayerStats {
+	for _, ps := range pr.Players {
+		if ps.ID == id {
+			return ps
+		}
+	}
+	return nil
+}
+
+// summary returns the results, i.e. "12 games, 7-4-1 (63.6%)", as wins-losses-draws.
+func (rc *ResultCounts) summary() string {
+	return fmt.Sprintf("%d games, %d-%d-%d (%.1f%%)", rc.Games, rc.Wins, rc.Losses, rc.Draws, rc.WinRate())
+}
+
+// sortedKeys returns the keys of m, in order.
+func sortedKeys[K int | string](m map[K]int) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// WriteText writes the statistics of each player, as a paragraph of text.
+func (pr *PlayerReport) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i, ps := range pr.Players {
+		if i > 0 {
+			bw.WriteByte('\n')
+		}
+		fmt.Fprintf(bw, "%s: %s, %.1f moves on average\n", ps.ID, ps.summary(), ps.AverageMoves())
+		fmt.Fprintf(bw, "  Black: %s\n", ps.Black.summary())
+		fmt.Fprintf(bw, "  White: %s\n", ps.White.summary())
+		if ps.First != "" {
+			fmt.Fprintf(bw, "  Dates: %s to %s\n", ps.First, ps.Last)
+		}
+		for _, ys := range ps.Years {
+			fmt.Fprintf(bw, "  %d: %s\n", ys.Year, ys.summary())
+		}
+		for _, opp := range ps.Opponents {
+			fmt.Fprintf(bw, "  vs %s: %s\n", opp.ID, opp.summary())
+		}
+		bw.WriteString("  Handicap:")
+		for _, ha := range sortedKeys(ps.Handicaps) {
+			fmt.Fprintf(bw, " %d: %d", ha, ps.Handicaps[ha])
+		}
+		bw.WriteString("\n  Komi:")
+		for _, km := range sortedKeys(ps.Komi) {
+			if km == "" {
+				fmt.Fprintf(bw, " ?: %d", ps.Komi[km])
+			} else {
+				fmt.Fprintf(bw, " %s: %d", km, ps.Komi[km])
+			}
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// WriteCSV writes a line of totals for each player, after a line of column names.
+// The years, opponents, handicaps, and komi are written by WriteJSON.
+func (pr *PlayerReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "games", "wins", "losses", "draws", "win_rate",
+		"black_games", "black_wins", "black_losses", "white_games", "white_wins", "white_losses",
+		"first", "last", "average_moves", "top_opponent"})
+	for _, ps := range pr.Players {
+		top := ""
+		if len(ps.Opponents) > 0 {
+			top = ps.Opponents[0].ID
+		}
+		cw.Write([]string{ps.ID, strconv.Itoa(ps.Games), strconv.Itoa(ps.Wins), strconv.Itoa(ps.Losses),
+			strconv.Itoa(ps.Draws), strconv.FormatFloat(ps.WinRate(), 'f', 1, 64),
+			strconv.Itoa(ps.Black.Games), strconv.Itoa(ps.Black.Wins), strconv.Itoa(ps.Black.Losses),
+			strconv.Itoa(ps.White.Games), strconv.Itoa(ps.White.Wins), strconv.Itoa(ps.White.Losses),
+			ps.First, ps.Last, strconv.FormatFloat(ps.AverageMoves(), 'f', 1, 64), top})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the statistics of each player, as a JSON line.
+func (pr *PlayerReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, ps := range pr.Players {
+		if err := enc.Encode(ps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package sgfdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	. "github.com/Ken1JF/sgfdb"
+	"strings"
+	"testing"
+)
+
+// statsDB returns a request to process four games of Go Seigen and Kitani Minoru.
+func statsDB() *DBProcessRequest {
+	return memDB(map[string]string{
+		"a/1.sgf": "(;GM[1]SZ[19]PB[Go Seigen]PW[Kitani Minoru]DT[1933-10-16]RE[B+2]KM[0];B[cd];W[dd];B[pp])",
+		"a/2.sgf": "(;GM[1]SZ[19]PB[Kitani Minoru]PW[Wu Qingyuan]DT[1939/9/28]RE[B+R]KM[0];B[pd])",
+		"a/3.sgf": "(;GM[1]SZ[19]PB[Fujisawa Kuranosuke]PW[Go Seigen]DT[1942]RE[Jigo]HA[2]KM[4.50];B[pd];W[dd])",
+		"b/4.sgf": "(;GM[1]SZ[19]PB[Go Seigen]PW[Kitani Minoru]RE[?];B[pd];W[dd];B[pp];W[dp])",
+	}, DBProcessRequest{Recursive: true})
+}
+
+func TestBuildPlayerStats(t *testing.T) {
+	pt, err := ReadPlayerTable(strings.NewReader(aliases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbrq := statsDB()
+	pr, err := BuildPlayerStats(context.Background(), dbrq, PlayerStatsOptions{Players: pt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Players) != 3 || pr.Players[0].ID != "Go Seigen" || pr.Players[1].ID != "Kitani Minoru" {
+		t.Fatalf("players: %v", pr.Players)
+	}
+	ps := pr.Player("Go Seigen")
+	if ps.Games != 4 || ps.Wins != 1 || ps.Losses != 1 || ps.Draws != 1 || ps.WinRate() != 50 {
+		t.Errorf("results: %+v", ps.ResultCounts)
+	}
+	if ps.Black.Games != 2 || ps.Black.Wins != 1 || ps.White.Games != 2 || ps.White.Losses != 1 {
+		t.Errorf("black %+v, white %+v", ps.Black, ps.White)
+	}
+	if ps.First != "1933-10-16" || ps.Last != "1942" || len(ps.Years) != 3 || ps.Years[1].Year != 1939 || ps.Years[1].Losses != 1 {
+		t.Errorf("dates %s %s, years %+v", ps.First, ps.Last, ps.Years)
+	}
+	if len(ps.Opponents) != 2 || ps.Opponents[0].ID != "Kitani Minoru" || ps.Opponents[0].Games != 3 {
+		t.Errorf("opponents: %+v", ps.Opponents)
+	}
+	if ps.Handicaps[0] != 3 || ps.Handicaps[2] != 1 || ps.Komi["0"] != 2 || ps.Komi["4.5"] != 1 || ps.Komi[""] != 1 {
+		t.Errorf("handicaps %v, komi %v", ps.Handicaps, ps.Komi)
+	}
+	if ps.AverageMoves() != 2.5 {
+		t.Errorf("average moves %f", ps.AverageMoves())
+	}
+
+	// without aliases, Wu Qingyuan is another player
+	dbrq = statsDB()
+	pr, err = BuildPlayerStats(context.Background(), dbrq, PlayerStatsOptions{MinGames: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pr.Players) != 2 || pr.Player("Go Seigen").Games != 3 || pr.Player("Wu Qingyuan") != nil {
+		t.Errorf("players: %v", pr.Players)
+	}
+}
+
+func TestPlayerReportWrite(t *testing.T) {
+	dbrq := statsDB()
+	q, err := ParseQuery("DT>=1930")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := BuildPlayerStats(context.Background(), dbrq, PlayerStatsOptions{Filter: q, MinGames: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pr.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Go Seigen: 2 games, 1-0-1 (100.0%), 2.5 moves on average
+  Black: 1 games, 1-0-0 (100.0%)
+  White: 1 games, 0-0-1 (0.0%)
+  Dates: 1933-10-16 to 1942
+  1933: 1 games, 1-0-0 (100.0%)
+  1942: 1 games, 0-0-1 (0.0%)
+  vs Fujisawa Kuranosuke: 1 games, 0-0-1 (0.0%)
+  vs Kitani Minoru: 1 games, 1-0-0 (100.0%)
+  Handicap: 0: 1 2: 1
+  Komi: 0: 1 4.5: 1
+
+Kitani Minoru: 2 games, 1-1-0 (50.0%), 2.0 moves on average
+  Black: 1 games, 1-0-0 (100.0%)
+  White: 1 games, 0-1-0 (0.0%)
+  Dates: 1933-10-16 to 1939-09-28
+  1933: 1 games, 0-1-0 (0.0%)
+  1939: 1 games, 1-0-0 (100.0%)
+  vs Go Seigen: 1 games, 0-1-0 (0.0%)
+  vs Wu Qingyuan: 1 games, 1-0-0 (100.0%)
+  Handicap: 0: 2
+  Komi: 0: 2
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%sexpected\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := pr.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "id,games,wins") || lines[1] != "Go Seigen,2,1,0,1,100.0,1,1,0,1,0,0,1933-10-16,1942,2.5,Fujisawa Kuranosuke" {
+		t.Errorf("CSV:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := pr.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var ps PlayerStats
+	if err := json.Unmarshal(bytes.Split(buf.Bytes(), []byte("\n"))[0], &ps); err != nil {
+		t.Fatal(err)
+	}
+	if ps.ID != "Go Seigen" || ps.Draws != 1 || ps.Komi["4.5"] != 1 || len(ps.Years) != 2 {
+		t.Errorf("JSON: %+v", ps)
+	}
+}